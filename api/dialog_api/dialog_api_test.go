@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
@@ -214,3 +215,370 @@ func TestNewChatSync_WithoutParent(t *testing.T) {
 		t.Errorf("应该有2个根dialogs，实际：%d", len(dialogs))
 	}
 }
+
+// TestNewChatSync_ProviderSelection 测试按请求选择提供商和模型
+func TestNewChatSync_ProviderSelection(t *testing.T) {
+	db, router := setupTestEnvironment(t)
+	sessionID, _, conversationIDs := createTestSessionAndDialog(t, db)
+
+	temperature := 0.2
+	reqBody := NewChatReq{
+		Content:              "指定模型的问题",
+		SessionID:            sessionID,
+		ParentConversationID: &conversationIDs[2],
+		Provider:             "mock",
+		Model:                "mock-large",
+		Temperature:          &temperature,
+	}
+
+	jsonBody, _ := json.Marshal(reqBody)
+	req, _ := http.NewRequest("POST", "/api/dialog/chat/sync", bytes.NewBuffer(jsonBody))
+	req.Header.Set("Content-Type", "application/json")
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	var conversation models.ConversationModel
+	if err := db.Where("prompt = ?", "指定模型的问题").First(&conversation).Error; err != nil {
+		t.Fatalf("应该保存新的conversation: %v，响应体：%s", err, w.Body.String())
+	}
+	if conversation.Provider != "mock" || conversation.ModelName != "mock-large" {
+		t.Errorf("应该记录提供商和模型，实际：%s/%s", conversation.Provider, conversation.ModelName)
+	}
+
+	// 未配置的提供商应该直接失败，而不是回退到其他提供商
+	reqBody.Content = "未知提供商的问题"
+	reqBody.Provider = "not-configured"
+	jsonBody, _ = json.Marshal(reqBody)
+	req, _ = http.NewRequest("POST", "/api/dialog/chat/sync", bytes.NewBuffer(jsonBody))
+	req.Header.Set("Content-Type", "application/json")
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	var count int64
+	db.Model(&models.ConversationModel{}).Where("prompt = ?", "未知提供商的问题").Count(&count)
+	if count != 0 {
+		t.Errorf("未知提供商不应该保存conversation，实际：%d", count)
+	}
+}
+
+// TestPreviewContext 预览上下文：返回每条对话的来源和 token 估算，不保存任何记录
+func TestPreviewContext(t *testing.T) {
+	db, router := setupTestEnvironment(t)
+	router.POST("/api/dialog/context/preview", DialogApi{}.PreviewContext)
+	sessionID, _, conversationIDs := createTestSessionAndDialog(t, db)
+
+	reqBody := NewChatReq{
+		Content:              "预览的问题",
+		SessionID:            sessionID,
+		ParentConversationID: &conversationIDs[2],
+	}
+	jsonBody, _ := json.Marshal(reqBody)
+	req, _ := http.NewRequest("POST", "/api/dialog/context/preview", bytes.NewBuffer(jsonBody))
+	req.Header.Set("Content-Type", "application/json")
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("期望状态码200，实际%d", w.Code)
+	}
+
+	var response struct {
+		Data ContextPreviewResponse `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("解析响应失败: %v", err)
+	}
+
+	report := response.Data.Report
+	if response.Data.Context.Current != "预览的问题" || len(response.Data.Context.Recent) != 3 {
+		t.Errorf("上下文不正确：%+v", response.Data.Context)
+	}
+	if len(report.Items) != 3 || report.Items[0].ConversationID != conversationIDs[2] {
+		t.Fatalf("应该按最近优先报告3条祖先对话：%+v", report.Items)
+	}
+	for _, item := range report.Items {
+		if item.Source != "ancestor" || item.Tokens == 0 || item.Q == "" {
+			t.Errorf("条目缺少来源、token 估算或内容：%+v", item)
+		}
+	}
+
+	var count int64
+	db.Model(&models.ConversationModel{}).Count(&count)
+	if count != 3 {
+		t.Errorf("预览不应该保存对话，实际：%d", count)
+	}
+}
+
+// TestNewChatSync_Template 多步模板在同一分支中依次执行，上一步的回答替换 {{previous}}
+func TestNewChatSync_Template(t *testing.T) {
+	db, router := setupTestEnvironment(t)
+	sessionID, _, _ := createTestSessionAndDialog(t, db)
+
+	tmpl := models.PromptTemplateModel{
+		Name:  "审查",
+		Steps: []string{"请审查{{lang}}代码：{{input}}", "根据审查意见给出修改：{{previous}}"},
+	}
+	db.Create(&tmpl)
+
+	send := func(body NewChatReq) map[string]any {
+		jsonBody, _ := json.Marshal(body)
+		req, _ := http.NewRequest("POST", "/api/dialog/chat/sync", bytes.NewBuffer(jsonBody))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		var response map[string]any
+		json.Unmarshal(w.Body.Bytes(), &response)
+		return response
+	}
+
+	t.Run("缺少变量", func(t *testing.T) {
+		response := send(NewChatReq{SessionID: sessionID, TemplateID: &tmpl.ID, Content: "x := 1"})
+		if response["code"].(float64) == 0 || response["msg"] != "缺少模板变量: lang" {
+			t.Errorf("应该提示缺少变量，实际：%v", response)
+		}
+	})
+
+	t.Run("依次执行", func(t *testing.T) {
+		response := send(NewChatReq{
+			SessionID:  sessionID,
+			TemplateID: &tmpl.ID,
+			Content:    "x := 1",
+			Variables:  map[string]string{"lang": "Go"},
+		})
+		if response["code"].(float64) != 0 {
+			t.Fatalf("执行模板失败：%v", response)
+		}
+		if steps := response["data"].(map[string]any)["steps"].([]any); len(steps) != 1 {
+			t.Errorf("应返回前面1步的结果，实际：%d", len(steps))
+		}
+
+		dialogID := response["data"].(map[string]any)["dialogId"].(float64)
+		var conversations []models.ConversationModel
+		db.Where("dialog_id = ?", int64(dialogID)).Order("id ASC").Find(&conversations)
+		if len(conversations) != 2 {
+			t.Fatalf("两步都应在同一分支上，实际 %d 条", len(conversations))
+		}
+		first, second := conversations[0], conversations[1]
+		if first.Prompt != "请审查Go代码：x := 1" {
+			t.Errorf("第一步变量替换错误：%s", first.Prompt)
+		}
+		if second.Prompt != "根据审查意见给出修改："+first.Answer {
+			t.Errorf("第二步应包含上一步的回答：%s", second.Prompt)
+		}
+	})
+}
+
+// TestRegenerateConversation 重新生成的回答与原对话同一个父对话，作为兄弟分支保存
+func TestRegenerateConversation(t *testing.T) {
+	db, router := setupTestEnvironment(t)
+	router.POST("/api/dialog/conversations/:conversationId/regenerate", DialogApi{}.RegenerateConversation)
+	sessionID, _, conversationIDs := createTestSessionAndDialog(t, db)
+
+	regenerate := func(id int64) string {
+		req, _ := http.NewRequest("POST", fmt.Sprintf("/api/dialog/conversations/%d/regenerate", id), nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Body.String()
+	}
+
+	body := regenerate(conversationIDs[1])
+	if !strings.Contains(body, "event: saved") {
+		t.Fatalf("应该发送 saved 事件：%s", body)
+	}
+
+	var original, alternative models.ConversationModel
+	db.First(&original, conversationIDs[1])
+	db.Where("regenerated_from_id = ?", original.ID).First(&alternative)
+	if alternative.ID == 0 || alternative.Prompt != original.Prompt {
+		t.Fatalf("应该用原问题保存新回答：%+v", alternative)
+	}
+	if alternative.DialogID == original.DialogID {
+		t.Error("新回答应位于兄弟分支")
+	}
+
+	// 两个回答的父对话都是第一条对话
+	var newDialog, originalDialog models.DialogModel
+	db.First(&newDialog, alternative.DialogID)
+	db.First(&originalDialog, original.DialogID)
+	if newDialog.BranchFromConversationID == nil || *newDialog.BranchFromConversationID != conversationIDs[0] ||
+		originalDialog.BranchFromConversationID == nil || *originalDialog.BranchFromConversationID != conversationIDs[0] {
+		t.Errorf("两个分支都应从第一条对话分出：new=%v original=%v", newDialog.BranchFromConversationID, originalDialog.BranchFromConversationID)
+	}
+
+	// 对重新生成的回答再次重新生成，仍指向最初的对话
+	regenerate(alternative.ID)
+	var count int64
+	db.Model(&models.ConversationModel{}).Where("regenerated_from_id = ?", original.ID).Count(&count)
+	if count != 2 {
+		t.Errorf("两次重新生成都应指向原对话，实际 %d 条", count)
+	}
+
+	// 重新生成会话根部的对话时，会话的根dialog和摘要保持不变
+	var before, after models.SessionModel
+	db.First(&before, sessionID)
+	regenerate(conversationIDs[0])
+	db.First(&after, sessionID)
+	if after.RootDialogID == nil || *after.RootDialogID != *before.RootDialogID || after.Summary != before.Summary {
+		t.Errorf("会话的根dialog和摘要不应改变：before=%v %q after=%v %q", *before.RootDialogID, before.Summary, after.RootDialogID, after.Summary)
+	}
+}
+
+// TestEditAndResend 修改过去的问题从其父对话分叉，原分支保持不变
+func TestEditAndResend(t *testing.T) {
+	db, router := setupTestEnvironment(t)
+	router.POST("/api/dialog/conversations/:conversationId/edit-and-resend", DialogApi{}.EditAndResend)
+	sessionID, _, conversationIDs := createTestSessionAndDialog(t, db)
+
+	resend := func(id int64, content string) models.ConversationModel {
+		jsonBody, _ := json.Marshal(EditResendReq{Content: content})
+		req, _ := http.NewRequest("POST", fmt.Sprintf("/api/dialog/conversations/%d/edit-and-resend", id), bytes.NewBuffer(jsonBody))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if !strings.Contains(w.Body.String(), "event: saved") {
+			t.Fatalf("应该发送 saved 事件：%s", w.Body.String())
+		}
+		var conv models.ConversationModel
+		db.Where("prompt = ?", content).First(&conv)
+		return conv
+	}
+
+	t.Run("从中间的问题分叉", func(t *testing.T) {
+		edited := resend(conversationIDs[1], "修改后的问题2")
+
+		var dialog models.DialogModel
+		db.First(&dialog, edited.DialogID)
+		if dialog.BranchFromConversationID == nil || *dialog.BranchFromConversationID != conversationIDs[0] {
+			t.Errorf("新分支应从第一条对话分出：%v", dialog.BranchFromConversationID)
+		}
+
+		var originals []models.ConversationModel
+		db.Find(&originals, conversationIDs)
+		for i, conv := range originals {
+			if conv.Prompt != fmt.Sprintf("问题%d", i+1) {
+				t.Errorf("原对话不应被修改：%+v", conv)
+			}
+		}
+		if originals[1].DialogID != originals[2].DialogID || originals[1].DialogID == edited.DialogID {
+			t.Error("原来的问题2和问题3应留在同一个分支中")
+		}
+	})
+
+	t.Run("从第一个问题分叉时从会话根部开始", func(t *testing.T) {
+		var before models.SessionModel
+		db.First(&before, sessionID)
+		edited := resend(conversationIDs[0], "修改后的问题1")
+
+		var dialog models.DialogModel
+		db.First(&dialog, edited.DialogID)
+		if dialog.ParentID != nil {
+			t.Errorf("新分支应位于会话根部：%+v", dialog)
+		}
+
+		// 会话的根dialog和摘要保持不变
+		var after models.SessionModel
+		db.First(&after, sessionID)
+		if after.RootDialogID == nil || *after.RootDialogID != *before.RootDialogID || after.Summary != before.Summary {
+			t.Errorf("会话的根dialog和摘要不应改变：before=%v %q after=%v %q", *before.RootDialogID, before.Summary, after.RootDialogID, after.Summary)
+		}
+	})
+}
+
+// TestEditAndResendRootContext 修改第一个问题后重新发送时，原分支的对话不放入上下文
+func TestEditAndResendRootContext(t *testing.T) {
+	db, router := setupTestEnvironment(t)
+	router.POST("/api/dialog/conversations/:conversationId/edit-and-resend", DialogApi{}.EditAndResend)
+	_, _, conversationIDs := createTestSessionAndDialog(t, db)
+
+	jsonBody, _ := json.Marshal(EditResendReq{Content: "修改后的问题1"})
+	req, _ := http.NewRequest("POST", fmt.Sprintf("/api/dialog/conversations/%d/edit-and-resend", conversationIDs[0]), bytes.NewBuffer(jsonBody))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	body := w.Body.String()
+	if !strings.Contains(body, "event: context") {
+		t.Fatalf("应该发送 context 事件：%s", body)
+	}
+	for _, id := range conversationIDs {
+		if strings.Contains(body, fmt.Sprintf(`"conversationId":%d,`, id)) {
+			t.Errorf("原分支的对话 %d 不应放入上下文：%s", id, body)
+		}
+	}
+}
+
+// TestMergeConversations 合并两个分支末端，新对话记录两个父对话
+func TestMergeConversations(t *testing.T) {
+	db, router := setupTestEnvironment(t)
+	router.POST("/api/dialog/conversations/:conversationId/regenerate", DialogApi{}.RegenerateConversation)
+	router.POST("/api/dialog/merge", DialogApi{}.MergeConversations)
+	_, _, conversationIDs := createTestSessionAndDialog(t, db)
+
+	// 重新生成第三个问题，得到两个分支末端
+	req, _ := http.NewRequest("POST", fmt.Sprintf("/api/dialog/conversations/%d/regenerate", conversationIDs[2]), nil)
+	router.ServeHTTP(httptest.NewRecorder(), req)
+	var alternative models.ConversationModel
+	db.Where("regenerated_from_id = ?", conversationIDs[2]).First(&alternative)
+	if alternative.ID == 0 {
+		t.Fatal("应该生成兄弟分支")
+	}
+
+	merge := func(left, right int64) map[string]any {
+		jsonBody, _ := json.Marshal(MergeReq{LeftConversationID: left, RightConversationID: right})
+		req, _ := http.NewRequest("POST", "/api/dialog/merge", bytes.NewBuffer(jsonBody))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		var response map[string]any
+		json.Unmarshal(w.Body.Bytes(), &response)
+		return response
+	}
+
+	t.Run("合并两个分支末端", func(t *testing.T) {
+		response := merge(conversationIDs[2], alternative.ID)
+		if response["code"] != float64(0) {
+			t.Fatalf("合并应该成功：%v", response)
+		}
+		data := response["data"].(map[string]any)
+		mergedID := int64(data["conversationId"].(float64))
+
+		var edges []models.ConversationParentModel
+		db.Where("conversation_id = ?", mergedID).Order("id").Find(&edges)
+		if len(edges) != 2 || edges[0].ParentConversationID != conversationIDs[2] || edges[1].ParentConversationID != alternative.ID {
+			t.Fatalf("应记录两个父对话：%+v", edges)
+		}
+
+		var original models.ConversationModel
+		var dialog models.DialogModel
+		db.First(&original, conversationIDs[2])
+		db.First(&dialog, int64(data["dialogId"].(float64)))
+		if dialog.ParentID == nil || *dialog.ParentID != original.DialogID ||
+			dialog.BranchFromConversationID == nil || *dialog.BranchFromConversationID != original.ID {
+			t.Errorf("合并结果应挂在第一个分支末端之后：%+v", dialog)
+		}
+
+		// 上下文中两个分支共同的前序对话只出现一次
+		common := 0
+		for _, item := range data["context"].(map[string]any)["items"].([]any) {
+			if item.(map[string]any)["section"] == "common" {
+				common++
+			}
+		}
+		if common != 2 {
+			t.Errorf("共同前序应包含前两条对话，实际 %d 条", common)
+		}
+	})
+
+	t.Run("已合并的对话不再是分支末端", func(t *testing.T) {
+		if response := merge(conversationIDs[2], alternative.ID); response["code"] == float64(0) {
+			t.Error("不应重复合并")
+		}
+	})
+
+	t.Run("不能合并中间的对话", func(t *testing.T) {
+		if response := merge(conversationIDs[0], alternative.ID); response["code"] == float64(0) {
+			t.Error("不应合并非末端对话")
+		}
+	})
+}
@@ -3,16 +3,17 @@
 package dialog_api
 
 import (
+	"context"
 	"dialogTree/common/res"
 	"dialogTree/global"
 	"dialogTree/models"
 	"dialogTree/service/ai_service"
-	"dialogTree/service/ai_service/chat_anywhere"
 	"dialogTree/service/dialog_service"
+	"dialogTree/service/stream_service"
+	"encoding/json"
 	"fmt"
 	"strconv"
 	"strings"
-	"time"
 	"unicode/utf8"
 
 	"github.com/gin-gonic/gin"
@@ -20,61 +21,231 @@ import (
 )
 
 type NewChatReq struct {
-	Content              string `json:"content" binding:"required"`
-	SessionID            int64  `json:"sessionId" binding:"required"`
-	ParentConversationID *int64 `json:"parentConversationId"` // 可选，指定从哪个conversation继续对话（用于分叉）
+	Content              string   `json:"content"` // 问题内容，使用模板时可为空，作为模板变量 {{input}}
+	SessionID            int64    `json:"sessionId" binding:"required"`
+	ParentConversationID *int64   `json:"parentConversationId"`                        // 可选，指定从哪个conversation继续对话（用于分叉）
+	Provider             string   `json:"provider"`                                    // 可选，提供商名称，为空使用默认提供商
+	Model                string   `json:"model"`                                       // 可选，覆盖提供商配置的模型
+	Temperature          *float64 `json:"temperature" binding:"omitempty,min=0,max=2"` // 可选，采样温度
+	MaxTokens            *int     `json:"maxTokens" binding:"omitempty,min=1"`         // 可选，最大生成 token 数
+
+	TemplateID *int64            `json:"templateId"` // 可选，使用提示词模板，多步模板在同一分支中依次执行
+	Variables  map[string]string `json:"variables"`  // 模板变量
+
+	persona         string                      // 会话角色的说明，由 applyPersona 填充
+	regeneratedFrom *int64                      // 重新生成时指向最初的那条对话
+	fork            *dialog_service.ForkContext // 重新生成或修改后重新发送时的上下文范围
+}
+
+// templateInputVar 请求中的 content 在模板中对应的变量名
+const templateInputVar = "input"
+
+// chatSteps 返回本次请求要依次发送的问题，普通对话只有一步
+// 使用模板时返回未替换 {{previous}} 的步骤，templated 为 true
+func (req *NewChatReq) chatSteps() (steps []string, templated bool, err error) {
+	if req.TemplateID == nil {
+		if strings.TrimSpace(req.Content) == "" {
+			return nil, false, fmt.Errorf("参数错误")
+		}
+		return []string{req.Content}, false, nil
+	}
+
+	tmpl, err := dialog_service.GetTemplate(*req.TemplateID)
+	if err != nil {
+		return nil, true, err
+	}
+	if len(tmpl.Steps) == 0 {
+		return nil, true, fmt.Errorf("模板 %s 没有步骤", tmpl.Name)
+	}
+	if req.Variables == nil {
+		req.Variables = map[string]string{}
+	}
+	if _, ok := req.Variables[templateInputVar]; !ok && req.Content != "" {
+		req.Variables[templateInputVar] = req.Content
+	}
+	if err := dialog_service.CheckTemplateVars(tmpl.Steps, req.Variables); err != nil {
+		return nil, true, err
+	}
+	return tmpl.Steps, true, nil
+}
+
+// stepRequest 构建第 i 步的请求，后续步骤接在上一步的对话之后
+func (req NewChatReq) stepRequest(step string, templated bool, previous *ChatResponse) NewChatReq {
+	if !templated {
+		return req
+	}
+	var previousAnswer string
+	if previous != nil {
+		req.ParentConversationID = &previous.ConversationID
+		previousAnswer = previous.answer
+	}
+	req.Content = dialog_service.RenderTemplateStep(step, req.Variables, previousAnswer)
+	return req
+}
+
+// chatOptions 从请求中提取本次调用的模型参数
+func (req NewChatReq) chatOptions() ai_service.ChatOptions {
+	return ai_service.ChatOptions{
+		Model:       req.Model,
+		Temperature: req.Temperature,
+		MaxTokens:   req.MaxTokens,
+		Persona:     req.persona,
+	}
+}
+
+// applyPersona 按会话的角色补全请求未指定的提供商、模型和温度
+func (req *NewChatReq) applyPersona(session models.SessionModel) error {
+	persona, err := dialog_service.GetSessionPersona(session)
+	if err != nil {
+		return err
+	}
+	var opts ai_service.ChatOptions
+	req.Provider, opts = dialog_service.ApplyPersona(persona, req.Provider, req.chatOptions())
+	req.Model, req.Temperature, req.persona = opts.Model, opts.Temperature, opts.Persona
+	return nil
 }
 
 type ChatResponse struct {
-	DialogID       int64  `json:"dialogId"`
-	ConversationID int64  `json:"conversationId"`
-	Title          string `json:"title"`
-	Summary        string `json:"summary"`
+	DialogID         int64   `json:"dialogId"`
+	ConversationID   int64   `json:"conversationId"`
+	Title            string  `json:"title"`
+	Summary          string  `json:"summary"`
+	Provider         string  `json:"provider"`
+	Model            string  `json:"model"`
+	PromptTokens     int     `json:"promptTokens"`
+	CompletionTokens int     `json:"completionTokens"`
+	Cost             float64 `json:"cost"`
+
+	Context *dialog_service.ContextReport `json:"context,omitempty"` // 上下文取舍情况，仅同步接口返回
+	Steps   []*ChatResponse               `json:"steps,omitempty"`   // 多步模板中前面各步的结果，仅同步接口返回
+
+	answer string // 回答内容，作为多步模板中下一步的 {{previous}}
 }
 
 // NewChat 发起新对话
+// 使用多步模板时各步骤在同一分支中依次执行，每步开始前发送 step 事件，保存后发送 saved 事件
 func (DialogApi) NewChat(c *gin.Context) {
 	var req NewChatReq
 	if err := c.ShouldBindJSON(&req); err != nil {
 		res.FailWithMessage("参数错误", c)
 		return
 	}
+	steps, templated, err := req.chatSteps()
+	if err != nil {
+		res.FailWithMessage(err.Error(), c)
+		return
+	}
 
 	// 检查会话是否存在
 	var session models.SessionModel
-	err := global.DB.First(&session, req.SessionID).Error
+	err = global.DB.First(&session, req.SessionID).Error
 	if err != nil {
 		res.FailWithMessage("会话不存在", c)
 		return
 	}
+	if err := req.applyPersona(session); err != nil {
+		res.Fail(err, "获取会话角色失败", c)
+		return
+	}
 
-	// 构建上下文（短期记忆 + 向量检索）- 现在返回JSON格式
-	contextJSON, err := dialog_service.BuildDialogContextFromConversation(req.SessionID, req.ParentConversationID, req.Content)
+	provider, err := ai_service.ResolveProvider(req.Provider)
 	if err != nil {
-		res.Fail(err, "构建上下文失败", c)
+		res.Fail(err, "AI提供商不可用", c)
 		return
 	}
 
-	// 直接使用JSON格式的上下文作为消息
-	fullMessage := contextJSON
+	// 客户端断开或主动停止时取消上游请求
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	defer cancel()
+	streamID := stream_service.Register(cancel)
+	defer stream_service.Unregister(streamID)
+
+	var previous *ChatResponse
+	for i, step := range steps {
+		stepReq := req.stepRequest(step, templated, previous)
+		if templated {
+			startSSE(c, streamID)
+			writeSSEJSON(c, "step", gin.H{
+				"index":  i + 1,
+				"total":  len(steps),
+				"prompt": stepReq.Content,
+			})
+		}
+		previous = streamChat(ctx, c, streamID, stepReq, provider, templated)
+		if previous == nil {
+			return
+		}
+	}
 
-	logrus.Debugf("合并后的消息: %s", fullMessage)
+	// 最后的Flush确保所有缓冲数据都已发送
+	c.Writer.Flush()
+}
 
-	// 调用AI进行流式对话
-	provider := ai_service.GetDefaultProvider()
-	msgChan, sumChan, err := ai_service.ChatStreamSum(fullMessage, provider)
-	if err != nil {
-		res.Fail(err, "AI服务调用失败", c)
+// startSSE 设置SSE响应头并告知前端 streamId，用于停止生成，重复调用无效
+func startSSE(c *gin.Context, streamID string) {
+	if c.Writer.Written() {
 		return
 	}
-
-	// 设置SSE响应头
 	c.Header("Content-Type", "text/event-stream")
 	c.Header("Cache-Control", "no-cache")
 	c.Header("Connection", "keep-alive")
 	c.Header("Access-Control-Allow-Origin", "*")
 	c.Header("Access-Control-Allow-Headers", "Cache-Control")
 
+	writeSSEJSON(c, "stream", gin.H{"streamId": streamID})
+}
+
+// failChat 响应开始前返回错误，SSE 已开始时发送 error 事件
+func failChat(c *gin.Context, err error, msg string) {
+	if !c.Writer.Written() {
+		res.Fail(err, msg, c)
+		return
+	}
+	writeSSEJSON(c, "error", gin.H{"msg": msg, "error": err.Error()})
+}
+
+// streamChat 流式发送一轮问答并保存
+// saveSync 为 false 时在后台保存并返回不含记录信息的响应；失败或被取消且不保存时返回 nil
+func streamChat(ctx context.Context, c *gin.Context, streamID string, req NewChatReq, provider ai_service.ChatProvider, saveSync bool) *ChatResponse {
+	// 构建上下文（短期记忆 + 向量检索）- 按模型的 token 预算裁剪，返回JSON格式
+	contextJSON, contextReport, err := dialog_service.BuildForkContextForModel(req.SessionID, req.ParentConversationID, req.Content, ai_service.ModelOf(provider, req.chatOptions()), req.fork)
+	if err != nil {
+		failChat(c, err, "构建上下文失败")
+		return nil
+	}
+
+	// 直接使用JSON格式的上下文作为消息
+	fullMessage := contextJSON
+
+	logrus.Debugf("合并后的消息: %s", fullMessage)
+
+	// 记录本次回答的用量
+	var usage ai_service.Usage
+	opts := req.chatOptions()
+	opts.Usage = &usage
+
+	msgChan, sumChan, answerer, err := ai_service.ChatStreamSumWithFailover(ctx, fullMessage, provider, opts)
+	if err != nil {
+		failChat(c, err, "AI服务调用失败")
+		return nil
+	}
+	// 记录实际回答的提供商和模型
+	req.Provider, req.Model = answerer.Provider, answerer.Model
+
+	startSSE(c, streamID)
+
+	// 告知前端哪些上下文被降级或省略
+	writeSSEJSON(c, "context", contextReport)
+
+	// 首选提供商不可用时，通知前端由哪个备用提供商接管
+	if answerer.Fallback {
+		writeSSEJSON(c, "notice", gin.H{
+			"type":     "failover",
+			"provider": answerer.Provider,
+			"model":    answerer.Model,
+		})
+	}
+
 	// 流式响应
 	var fullAnswer strings.Builder
 	var summary string
@@ -97,22 +268,53 @@ func (DialogApi) NewChat(c *gin.Context) {
 		c.Writer.Flush()
 	}
 
-	// 等待摘要处理完成
+	// 等待摘要处理完成，此时用量已写入
 	<-done
 
+	writeSSEJSON(c, "usage", gin.H{
+		"promptTokens":     usage.PromptTokens,
+		"completionTokens": usage.CompletionTokens,
+		"cost":             ai_service.CostOf(req.Model, usage),
+		"estimated":        usage.Estimated,
+	})
+
+	// 被取消的回答默认丢弃，仅当用户主动停止并要求保留时才保存部分内容
+	stopped := ctx.Err() != nil
+	if stopped {
+		if !stream_service.KeepPartial(streamID) {
+			logrus.Infof("回答生成已取消，丢弃部分回答，SessionID: %d", req.SessionID)
+			writeSSEJSON(c, "stopped", gin.H{"saved": false})
+			return nil
+		}
+		logrus.Infof("回答生成已停止，保存部分回答，SessionID: %d", req.SessionID)
+		writeSSEJSON(c, "stopped", gin.H{"saved": true})
+	}
+
+	if saveSync {
+		response, err := SaveChatRecord(req, fullAnswer.String(), summary, usage)
+		if err != nil {
+			failChat(c, err, "保存对话失败")
+			return nil
+		}
+		writeSSEJSON(c, "saved", response)
+		if stopped {
+			return nil
+		}
+		response.answer = fullAnswer.String()
+		return response
+	}
+
 	// 保存对话记录
 	logrus.Debugf("准备异步保存对话记录，SessionID: %d, ContentLength: %d", req.SessionID, len(fullAnswer.String()))
 	go func() {
-		_, err := SaveChatRecord(req, fullAnswer.String(), summary)
+		_, err := SaveChatRecord(req, fullAnswer.String(), summary, usage)
 		if err != nil {
 			logrus.Errorf("异步保存对话记录失败: %v", err)
 		} else {
 			logrus.Debugf("异步保存对话记录成功")
 		}
 	}()
-
-	// 最后的Flush确保所有缓冲数据都已发送
-	c.Writer.Flush()
+	return &ChatResponse{answer: fullAnswer.String()}
 }
 
 // NewChatSync 同步版本的新对话（用于简单测试）
@@ -122,31 +324,71 @@ func (DialogApi) NewChatSync(c *gin.Context) {
 		res.FailWithMessage("参数错误", c)
 		return
 	}
+	steps, templated, err := req.chatSteps()
+	if err != nil {
+		res.FailWithMessage(err.Error(), c)
+		return
+	}
 
 	// 检查会话是否存在
 	var session models.SessionModel
-	err := global.DB.First(&session, req.SessionID).Error
+	err = global.DB.First(&session, req.SessionID).Error
 	if err != nil {
 		res.FailWithMessage("会话不存在", c)
 		return
 	}
+	if err := req.applyPersona(session); err != nil {
+		res.Fail(err, "获取会话角色失败", c)
+		return
+	}
 
-	// 构建上下文 - 现在返回JSON格式
-	contextJSON, err := dialog_service.BuildDialogContextFromConversation(req.SessionID, req.ParentConversationID, req.Content)
+	provider, err := ai_service.ResolveProvider(req.Provider)
 	if err != nil {
-		res.Fail(err, "构建上下文失败", c)
+		res.Fail(err, "AI提供商不可用", c)
 		return
 	}
 
+	var responses []*ChatResponse
+	var previous *ChatResponse
+	for _, step := range steps {
+		stepReq := req.stepRequest(step, templated, previous)
+		previous, err = chatSync(c.Request.Context(), stepReq, provider)
+		if err != nil {
+			res.Fail(err, err.Error(), c)
+			return
+		}
+		if previous == nil {
+			return
+		}
+		responses = append(responses, previous)
+	}
+
+	response := responses[len(responses)-1]
+	response.Steps = responses[:len(responses)-1]
+	res.OkWithDetail(response, "对话成功", c)
+}
+
+// chatSync 同步完成一轮问答并保存，客户端已断开时返回 nil
+func chatSync(ctx context.Context, req NewChatReq, provider ai_service.ChatProvider) (*ChatResponse, error) {
+	// 构建上下文 - 按模型的 token 预算裁剪，返回JSON格式
+	contextJSON, contextReport, err := dialog_service.BuildForkContextForModel(req.SessionID, req.ParentConversationID, req.Content, ai_service.ModelOf(provider, req.chatOptions()), req.fork)
+	if err != nil {
+		return nil, fmt.Errorf("构建上下文失败: %v", err)
+	}
+
 	// 直接使用JSON格式的上下文作为消息
 	fullMessage := contextJSON
 
 	// 调用AI（简化版，直接返回结果）
-	msgChan, sumChan, err := chat_anywhere.ChatStreamSum(fullMessage)
+	var usage ai_service.Usage
+	opts := req.chatOptions()
+	opts.Usage = &usage
+	msgChan, sumChan, answerer, err := ai_service.ChatStreamSumWithFailover(ctx, fullMessage, provider, opts)
 	if err != nil {
-		res.Fail(err, "AI服务调用失败", c)
-		return
+		return nil, fmt.Errorf("AI服务调用失败: %v", err)
 	}
+	// 记录实际回答的提供商和模型
+	req.Provider, req.Model = answerer.Provider, answerer.Model
 
 	// 收集完整回答
 	var fullAnswer strings.Builder
@@ -159,168 +401,93 @@ func (DialogApi) NewChatSync(c *gin.Context) {
 		summary += s
 	}
 
+	// 客户端已断开，不保存不完整的回答
+	if ctx.Err() != nil {
+		logrus.Infof("客户端已断开，丢弃回答，SessionID: %d", req.SessionID)
+		return nil, nil
+	}
+
 	// 保存对话记录
-	response, err := SaveChatRecord(req, fullAnswer.String(), summary)
+	response, err := SaveChatRecord(req, fullAnswer.String(), summary, usage)
 	if err != nil {
-		res.Fail(err, "保存对话失败", c)
-		return
+		return nil, fmt.Errorf("保存对话失败: %v", err)
 	}
-
-	res.OkWithDetail(response, "对话成功", c)
+	response.Context = &contextReport
+	response.answer = fullAnswer.String()
+	return response, nil
 }
 
-type summarizeType struct {
-	Title   string `json:"title"`
-	Summary string `json:"summary"`
+type StopChatReq struct {
+	KeepPartial bool `json:"keepPartial"` // 是否保存已生成的部分回答
 }
 
-// SaveChatRecord 保存对话记录的辅助函数
-func SaveChatRecord(req NewChatReq, answer, summaryRaw string) (*ChatResponse, error) {
-	logrus.Debugf("SaveChatRecord 开始执行，SessionID: %d, ParentConversationID: %v", req.SessionID, req.ParentConversationID)
-	var s summarizeType
-	// AI现在返回纯文本摘要，不再需要解析JSON
-	s.Summary = summaryRaw
-	// 标题可以从请求内容中截取，或者设置为默认值
-	s.Title = ""
-
-	var dialogID int64
-	var isNewSession bool
-
-	logrus.Debugf("SaveChatRecord 开始处理Dialog逻辑，ParentConversationID: %v", req.ParentConversationID)
-	if req.ParentConversationID == nil {
-		// 没有指定父conversation，在会话根部创建新的对话分支
-		dialog := models.DialogModel{
-			SessionID: req.SessionID,
-			ParentID:  nil,
-		}
-		if err := global.DB.Create(&dialog).Error; err != nil {
-			logrus.Errorf("SaveChatRecord 创建Dialog失败: %v", err)
-			return nil, fmt.Errorf("创建对话节点失败: %v", err)
-		}
-
-		logrus.Debugf("SaveChatRecord 成功创建新Dialog，ID: %d", dialog.ID)
-		dialogID = dialog.ID
-		isNewSession = true
-	} else {
-		// 指定了父conversation，需要检查是否分叉
-		parentConv := &models.ConversationModel{}
-		if err := global.DB.First(parentConv, *req.ParentConversationID).Error; err != nil {
-			return nil, fmt.Errorf("找不到父conversation: %v", err)
-		}
+// StopChat 停止正在生成的回答
+func (DialogApi) StopChat(c *gin.Context) {
+	streamID := c.Param("streamId")
 
-		// 检查是否需要分叉
-		needsBranching, err := dialog_service.CheckIfBranchingByConversation(*req.ParentConversationID)
-		if err != nil {
-			return nil, fmt.Errorf("检查分叉失败: %v", err)
+	var req StopChatReq
+	// 请求体可选，缺省时丢弃部分回答
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			res.FailWithMessage("参数错误", c)
+			return
 		}
-
-		if needsBranching {
-			logrus.Debugf("\n需要分叉：创建分叉dialogs")
-			// 需要分叉：创建分叉dialogs
-			newDialogID, _, err := dialog_service.CreateBranchingDialogs(req.SessionID, *req.ParentConversationID, parentConv.DialogID)
-			if err != nil {
-				return nil, fmt.Errorf("创建分叉失败: %v", err)
-			}
-			dialogID = newDialogID
-		} else {
-			// 找一下父 dialog 是否有子 dialog
-			var childCount int64
-			err :=
-				global.DB.Model(&models.DialogModel{}).Where("parent_id = ?",
-					parentConv.DialogID).Count(&childCount).Error
-			if err != nil {
-				return nil, fmt.Errorf("数据库查询失败: %v", err)
-			}
-
-			if childCount == 0 {
-				// 如果没有别的子节点，则不需要分叉：直接在当前dialog中添加conversation
-				dialogID = parentConv.DialogID
-			} else {
-				// 找到了父 dialog 的其他子节点，则需要新建一个 dialog
-				newDialog := models.DialogModel{
-					SessionID:                parentConv.SessionID,
-					ParentID:                 &parentConv.DialogID,
-					BranchFromConversationID: &parentConv.ID,
-				}
-				if err := global.DB.Create(&newDialog).Error; err !=
-					nil {
-					return nil, fmt.Errorf("创建dialog失败: %v", err)
-				}
-				dialogID = newDialog.ID
-			}
-		}
-	}
-
-	// 创建会话记录
-	conversation := models.ConversationModel{
-		Prompt:    req.Content,
-		Answer:    answer,
-		SessionID: req.SessionID,
-		DialogID:  dialogID,
-		Title:     s.Title,
-		Summary:   s.Summary,
-		IsStarred: false,
-		Comment:   "",
 	}
 
-	logrus.Debugf("SaveChatRecord 准备创建Conversation记录，DialogID: %d", dialogID)
-	err := global.DB.Create(&conversation).Error
-	if err != nil {
-		logrus.Errorf("SaveChatRecord 创建Conversation失败: %v", err)
-		return nil, fmt.Errorf("创建对话记录失败: %v", err)
+	if !stream_service.Stop(streamID, req.KeepPartial) {
+		res.FailWithMessage("回答不存在或已结束", c)
+		return
 	}
-	logrus.Debugf("SaveChatRecord 成功创建Conversation，ID: %d", conversation.ID)
 
-	// 如果是新会话的第一条对话，更新会话信息
-	if isNewSession {
-		// 先查询当前会话的标题
-		var currentSession models.SessionModel
-		err = global.DB.First(&currentSession, req.SessionID).Error
-		if err != nil {
-			logrus.Errorf("查询会话信息失败: %v", err)
-		} else {
-			updates := map[string]interface{}{
-				"summary":        s.Summary,
-				"root_dialog_id": &dialogID,
-			}
-			
-			// 仅当现有标题为空时才更新标题
-			if currentSession.Tittle == "" && s.Title != "" {
-				updates["tittle"] = s.Title
-			}
-			
-			err = global.DB.Model(&models.SessionModel{}).Where("id = ?", req.SessionID).Updates(updates).Error
-			if err != nil {
-				logrus.Errorf("更新会话信息失败: %v", err)
-			}
-		}
-	}
+	res.OkWithDetail(gin.H{
+		"streamId":    streamID,
+		"keepPartial": req.KeepPartial,
+	}, "已停止生成", c)
+}
 
-	// 异步处理向量化存储
-	if global.Config.Vector.Enable {
-		go func() {
-			err := dialog_service.StoreConversationVector(conversation.ID, req.Content, answer, s.Summary)
-			if err != nil {
-				logrus.Errorf("向量化存储失败: %v", err)
-			}
-		}()
-	}
+// writeSSEJSON 发送一条 JSON 数据的 SSE 事件
+func writeSSEJSON(c *gin.Context, event string, data any) {
+	byteData, _ := json.Marshal(data)
+	fmt.Fprintf(c.Writer, "event: %s\ndata: %s\n\n", event, byteData)
+	c.Writer.Flush()
+}
 
-	// 更新 session 时间
-	err = global.DB.Model(&models.SessionModel{}).
-		Where("id = ?", req.SessionID).
-		Update("updated_at", time.Now()).Error
+// SaveChatRecord 保存对话记录的辅助函数
+func SaveChatRecord(req NewChatReq, answer, summaryRaw string, usage ai_service.Usage) (*ChatResponse, error) {
+	logrus.Debugf("SaveChatRecord 开始执行，SessionID: %d, ParentConversationID: %v", req.SessionID, req.ParentConversationID)
+	conversation, err := dialog_service.SaveConversation(dialog_service.ConversationRecord{
+		SessionID:            req.SessionID,
+		ParentConversationID: req.ParentConversationID,
+		Prompt:               req.Content,
+		Answer:               answer,
+		SummaryRaw:           summaryRaw,
+		Provider:             req.Provider,
+		Model:                req.Model,
+		Usage:                usage,
+		RegeneratedFromID:    req.regeneratedFrom,
+	})
 	if err != nil {
-		logrus.Errorf("更新session时间失败: %v", err)
+		logrus.Errorf("SaveChatRecord 保存对话失败: %v", err)
+		return nil, err
 	}
 
-	logrus.Debugf("SaveChatRecord 执行完成，ConversationID: %d, DialogID: %d", conversation.ID, dialogID)
+	logrus.Debugf("SaveChatRecord 执行完成，ConversationID: %d, DialogID: %d", conversation.ID, conversation.DialogID)
+	return newChatResponse(conversation), nil
+}
+
+// newChatResponse 由保存的对话记录构建响应
+func newChatResponse(conversation *models.ConversationModel) *ChatResponse {
 	return &ChatResponse{
-		DialogID:       dialogID,
-		ConversationID: conversation.ID,
-		Title:          s.Title,
-		Summary:        s.Summary,
-	}, nil
+		DialogID:         conversation.DialogID,
+		ConversationID:   conversation.ID,
+		Title:            conversation.Title,
+		Summary:          conversation.Summary,
+		Provider:         conversation.Provider,
+		Model:            conversation.ModelName,
+		PromptTokens:     conversation.PromptTokens,
+		CompletionTokens: conversation.CompletionTokens,
+		Cost:             conversation.Cost,
+	}
 }
 
 // StarConversation 标星/取消标星会话
@@ -357,6 +524,40 @@ func (DialogApi) StarConversation(c *gin.Context) {
 	}, status, c)
 }
 
+// PinConversation 固定/取消固定对话，固定的对话在同一会话的每次提问中都会作为上下文带上
+func (DialogApi) PinConversation(c *gin.Context) {
+	conversationIdStr := c.Param("conversationId")
+	conversationId, err := strconv.ParseInt(conversationIdStr, 10, 64)
+	if err != nil {
+		res.FailWithMessage("会话ID无效", c)
+		return
+	}
+
+	var conversation models.ConversationModel
+	err = global.DB.First(&conversation, conversationId).Error
+	if err != nil {
+		res.FailWithMessage("会话不存在", c)
+		return
+	}
+
+	// 切换固定状态
+	conversation.IsPinned = !conversation.IsPinned
+	err = global.DB.Model(&conversation).Update("is_pinned", conversation.IsPinned).Error
+	if err != nil {
+		res.Fail(err, "更新失败", c)
+		return
+	}
+
+	status := "已固定"
+	if !conversation.IsPinned {
+		status = "已取消固定"
+	}
+
+	res.OkWithDetail(gin.H{
+		"isPinned": conversation.IsPinned,
+	}, status, c)
+}
+
 type CommentReq struct {
 	ConversationID int64  `json:"id" binding:"required"`
 	Comment        string `json:"comment"`
@@ -459,7 +660,7 @@ func (DialogApi) GetAncestors(c *gin.Context) {
 	}
 
 	// 获取所有祖先对话
-	ancestors, err := getDialogAncestors(conversationId)
+	ancestors, err := dialog_service.GetAncestors(conversationId)
 	if err != nil {
 		res.Fail(err, "获取祖先对话失败", c)
 		return
@@ -470,65 +671,6 @@ func (DialogApi) GetAncestors(c *gin.Context) {
 	res.OkWithDetail(ancestors, "获取祖先对话成功", c)
 }
 
-// getDialogAncestors 递归获取conversation的所有祖先对话
-func getDialogAncestors(conversationID int64) ([]models.ConversationModel, error) {
-	var ancestors []models.ConversationModel
-
-	// 查找当前conversation
-	var currentConv models.ConversationModel
-	err := global.DB.First(&currentConv, conversationID).Error
-	if err != nil {
-		return ancestors, err
-	}
-
-	// 1. 先在同一个dialog内查找所有比当前conversation创建时间更早的conversations
-	var sameDialogAncestors []models.ConversationModel
-	err = global.DB.Where("dialog_id = ? AND created_at < ?", currentConv.DialogID, currentConv.CreatedAt).
-		Order("created_at ASC").
-		Find(&sameDialogAncestors).Error
-	if err != nil {
-		return ancestors, err
-	}
-
-	// 添加同一dialog内的祖先（按创建时间升序）
-	ancestors = append(ancestors, sameDialogAncestors...)
-
-	// 2. 查找当前dialog的父dialog
-	var currentDialog models.DialogModel
-	err = global.DB.First(&currentDialog, currentConv.DialogID).Error
-	if err != nil {
-		return ancestors, err
-	}
-
-	// 3. 如果有父dialog，需要找到分叉点conversation
-	if currentDialog.ParentID != nil {
-		// 找到父dialog中的分叉点conversation（即最后一个conversation，也就是分叉的起点）
-		var branchPointConv models.ConversationModel
-		err = global.DB.Where("dialog_id = ?", *currentDialog.ParentID).
-			Order("created_at DESC").
-			Limit(1).
-			First(&branchPointConv).Error
-		if err != nil {
-			return ancestors, err
-		}
-
-		// 递归获取分叉点conversation的所有祖先
-		branchPointAncestors, err := getDialogAncestors(branchPointConv.ID)
-		if err != nil {
-			return ancestors, err
-		}
-
-		// 将分叉点及其祖先添加到最前面（保持时间顺序）
-		result := make([]models.ConversationModel, 0, len(branchPointAncestors)+len(ancestors)+1)
-		result = append(result, branchPointAncestors...)
-		result = append(result, branchPointConv)
-		result = append(result, ancestors...)
-		ancestors = result
-	}
-
-	return ancestors, nil
-}
-
 func min(a, b int) int {
 	if a < b {
 		return a
@@ -0,0 +1,64 @@
+// Path: ./api/dialog_api/dialog_context.go
+
+package dialog_api
+
+import (
+	"dialogTree/common/res"
+	"dialogTree/global"
+	"dialogTree/models"
+	"dialogTree/service/ai_service"
+	"dialogTree/service/dialog_service"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ContextPreviewResponse struct {
+	Context dialog_service.ContextData   `json:"context"` // 实际会发送给模型的上下文
+	Report  dialog_service.ContextReport `json:"report"`  // 每条对话的来源、保留程度和 token 估算
+}
+
+// PreviewContext 预览发起对话时会拼装的上下文，不调用模型也不保存
+// 请求体与 /chat 相同，使用模板时预览第一步
+func (DialogApi) PreviewContext(c *gin.Context) {
+	var req NewChatReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		res.FailWithMessage("参数错误", c)
+		return
+	}
+	steps, templated, err := req.chatSteps()
+	if err != nil {
+		res.FailWithMessage(err.Error(), c)
+		return
+	}
+	req = req.stepRequest(steps[0], templated, nil)
+
+	// 检查会话是否存在
+	var session models.SessionModel
+	err = global.DB.First(&session, req.SessionID).Error
+	if err != nil {
+		res.FailWithMessage("会话不存在", c)
+		return
+	}
+	if err := req.applyPersona(session); err != nil {
+		res.Fail(err, "获取会话角色失败", c)
+		return
+	}
+
+	// 预算取决于实际使用的模型
+	provider, err := ai_service.ResolveProvider(req.Provider)
+	if err != nil {
+		res.Fail(err, "AI提供商不可用", c)
+		return
+	}
+
+	contextData, report, err := dialog_service.PreviewContext(req.SessionID, req.ParentConversationID, req.Content, ai_service.ModelOf(provider, req.chatOptions()))
+	if err != nil {
+		res.Fail(err, "构建上下文失败", c)
+		return
+	}
+
+	res.OkWithDetail(ContextPreviewResponse{
+		Context: contextData,
+		Report:  report,
+	}, "预览成功", c)
+}
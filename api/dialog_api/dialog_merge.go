@@ -0,0 +1,62 @@
+// Path: ./api/dialog_api/dialog_merge.go
+
+package dialog_api
+
+import (
+	"dialogTree/common/res"
+	"dialogTree/global"
+	"dialogTree/models"
+	"dialogTree/service/ai_service"
+	"dialogTree/service/dialog_service"
+
+	"github.com/gin-gonic/gin"
+)
+
+type MergeReq struct {
+	LeftConversationID  int64  `json:"leftConversationId" binding:"required"`  // 第一个分支的末端对话，合并结果挂在它所在的dialog下
+	RightConversationID int64  `json:"rightConversationId" binding:"required"` // 第二个分支的末端对话
+	Content             string `json:"content"`                                // 可选，合并要求，为空使用默认要求
+	RegenerateReq
+}
+
+// MergeConversations 合并同一会话中两个分支的末端对话，生成综合两个分支的回答
+// 新对话同时记录两个父对话，会话树中表现为有两个父节点的节点
+func (DialogApi) MergeConversations(c *gin.Context) {
+	var body MergeReq
+	if err := c.ShouldBindJSON(&body); err != nil {
+		res.FailWithMessage("参数错误", c)
+		return
+	}
+
+	var left models.ConversationModel
+	if err := global.DB.First(&left, body.LeftConversationID).Error; err != nil {
+		res.FailWithMessage("会话不存在", c)
+		return
+	}
+	var session models.SessionModel
+	if err := global.DB.First(&session, left.SessionID).Error; err != nil {
+		res.FailWithMessage("会话不存在", c)
+		return
+	}
+
+	req := body.chatReq(body.Content, session.ID, &left.ID)
+	if err := req.applyPersona(session); err != nil {
+		res.Fail(err, "获取会话角色失败", c)
+		return
+	}
+	provider, err := ai_service.ResolveProvider(req.Provider)
+	if err != nil {
+		res.Fail(err, "AI提供商不可用", c)
+		return
+	}
+
+	result, err := dialog_service.MergeConversations(c.Request.Context(), body.LeftConversationID, body.RightConversationID, body.Content, provider, req.chatOptions())
+	if err != nil {
+		res.FailWithMessage(err.Error(), c)
+		return
+	}
+
+	resp := newChatResponse(&result.Conversation)
+	resp.Context = &result.Report
+	res.OkWithDetail(resp, "合并成功", c)
+}
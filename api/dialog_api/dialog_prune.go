@@ -0,0 +1,69 @@
+// Path: ./api/dialog_api/dialog_prune.go
+
+package dialog_api
+
+import (
+	"dialogTree/common/res"
+	"dialogTree/service/dialog_service"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DeleteConversation 删除单条对话，从它分出的分支改为接在它的父对话之后
+func (DialogApi) DeleteConversation(c *gin.Context) {
+	conversationId, err := strconv.ParseInt(c.Param("conversationId"), 10, 64)
+	if err != nil {
+		res.FailWithMessage("会话ID无效", c)
+		return
+	}
+
+	if err := dialog_service.DeleteConversation(conversationId); err != nil {
+		res.FailWithMessage(err.Error(), c)
+		return
+	}
+	res.OkWithMessage("删除成功", c)
+}
+
+// DeleteConversationSubtree 删除对话及其后续的全部对话和分支
+func (DialogApi) DeleteConversationSubtree(c *gin.Context) {
+	conversationId, err := strconv.ParseInt(c.Param("conversationId"), 10, 64)
+	if err != nil {
+		res.FailWithMessage("会话ID无效", c)
+		return
+	}
+
+	count, err := dialog_service.DeleteConversationSubtree(conversationId)
+	if err != nil {
+		res.FailWithMessage(err.Error(), c)
+		return
+	}
+	res.OkWithDetail(gin.H{
+		"count": count,
+	}, "删除成功", c)
+}
+
+// ArchiveConversation 归档/恢复对话及其后续分支，归档的对话在对话树中隐藏且不参与向量召回
+func (DialogApi) ArchiveConversation(c *gin.Context) {
+	conversationId, err := strconv.ParseInt(c.Param("conversationId"), 10, 64)
+	if err != nil {
+		res.FailWithMessage("会话ID无效", c)
+		return
+	}
+
+	// 切换归档状态，当前状态在会话锁内读取
+	archived, count, err := dialog_service.ToggleArchiveConversation(conversationId)
+	if err != nil {
+		res.Fail(err, "更新失败", c)
+		return
+	}
+
+	status := "已归档"
+	if !archived {
+		status = "已恢复"
+	}
+	res.OkWithDetail(gin.H{
+		"isArchived": archived,
+		"count":      count,
+	}, status, c)
+}
@@ -0,0 +1,138 @@
+// Path: ./api/dialog_api/dialog_regenerate.go
+
+package dialog_api
+
+import (
+	"context"
+	"dialogTree/common/res"
+	"dialogTree/global"
+	"dialogTree/models"
+	"dialogTree/service/ai_service"
+	"dialogTree/service/dialog_service"
+	"dialogTree/service/stream_service"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+type RegenerateReq struct {
+	Provider    string   `json:"provider"`                                    // 可选，换一个提供商比较回答
+	Model       string   `json:"model"`                                       // 可选，覆盖提供商配置的模型
+	Temperature *float64 `json:"temperature" binding:"omitempty,min=0,max=2"` // 可选，采样温度
+	MaxTokens   *int     `json:"maxTokens" binding:"omitempty,min=1"`         // 可选，最大生成 token 数
+}
+
+type EditResendReq struct {
+	Content string `json:"content" binding:"required"` // 修改后的问题
+	RegenerateReq
+}
+
+// RegenerateConversation 用同一个问题和相同的父上下文重新生成回答，新回答作为原对话的兄弟分支保存
+// 响应与 /chat 相同为 SSE，保存后发送 saved 事件
+func (DialogApi) RegenerateConversation(c *gin.Context) {
+	conversationId, err := strconv.ParseInt(c.Param("conversationId"), 10, 64)
+	if err != nil {
+		res.FailWithMessage("会话ID无效", c)
+		return
+	}
+
+	var body RegenerateReq
+	// 请求体可选，缺省时沿用会话角色或默认提供商
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&body); err != nil {
+			res.FailWithMessage("参数错误", c)
+			return
+		}
+	}
+
+	target, err := dialog_service.GetRegenerateTarget(conversationId)
+	if err != nil {
+		res.FailWithMessage("会话不存在", c)
+		return
+	}
+
+	fork, err := dialog_service.NewForkContext(conversationId)
+	if err != nil {
+		res.Fail(err, "获取上下文范围失败", c)
+		return
+	}
+
+	req := body.chatReq(target.Conversation.Prompt, target.Conversation.SessionID, target.ParentConversationID)
+	req.regeneratedFrom = &target.OriginalID
+	req.fork = fork
+	forkChat(c, req)
+}
+
+// EditAndResend 修改过去的问题并重新发送，从该问题的父对话（或会话根部）分出新分支，原分支保持不变
+// 响应与 /chat 相同为 SSE，保存后发送 saved 事件
+func (DialogApi) EditAndResend(c *gin.Context) {
+	conversationId, err := strconv.ParseInt(c.Param("conversationId"), 10, 64)
+	if err != nil {
+		res.FailWithMessage("会话ID无效", c)
+		return
+	}
+
+	var body EditResendReq
+	if err := c.ShouldBindJSON(&body); err != nil || strings.TrimSpace(body.Content) == "" {
+		res.FailWithMessage("参数错误", c)
+		return
+	}
+
+	conv, parentID, err := dialog_service.GetForkPoint(conversationId)
+	if err != nil {
+		res.FailWithMessage("会话不存在", c)
+		return
+	}
+
+	fork, err := dialog_service.NewForkContext(conv.ID)
+	if err != nil {
+		res.Fail(err, "获取上下文范围失败", c)
+		return
+	}
+
+	req := body.chatReq(body.Content, conv.SessionID, parentID)
+	req.fork = fork
+	forkChat(c, req)
+}
+
+// chatReq 构建从指定父对话发起的对话请求
+func (r RegenerateReq) chatReq(content string, sessionID int64, parentID *int64) NewChatReq {
+	return NewChatReq{
+		Content:              content,
+		SessionID:            sessionID,
+		ParentConversationID: parentID,
+		Provider:             r.Provider,
+		Model:                r.Model,
+		Temperature:          r.Temperature,
+		MaxTokens:            r.MaxTokens,
+	}
+}
+
+// forkChat 按会话角色补全参数后流式发送，并同步保存以便在 saved 事件中返回新分支
+func forkChat(c *gin.Context, req NewChatReq) {
+	var session models.SessionModel
+	if err := global.DB.First(&session, req.SessionID).Error; err != nil {
+		res.FailWithMessage("会话不存在", c)
+		return
+	}
+	if err := req.applyPersona(session); err != nil {
+		res.Fail(err, "获取会话角色失败", c)
+		return
+	}
+
+	provider, err := ai_service.ResolveProvider(req.Provider)
+	if err != nil {
+		res.Fail(err, "AI提供商不可用", c)
+		return
+	}
+
+	// 客户端断开或主动停止时取消上游请求
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	defer cancel()
+	streamID := stream_service.Register(cancel)
+	defer stream_service.Unregister(streamID)
+
+	streamChat(ctx, c, streamID, req, provider, true)
+	c.Writer.Flush()
+}
@@ -0,0 +1,51 @@
+// Path: ./api/dialog_api/dialog_subtree.go
+
+package dialog_api
+
+import (
+	"dialogTree/common/res"
+	"dialogTree/models"
+	"dialogTree/service/dialog_service"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type SubtreeReq struct {
+	TargetConversationID int64 `json:"targetConversationId" binding:"required"` // 子树挂到哪条对话之后，可以在其他会话中
+}
+
+type SubtreeResponse struct {
+	DialogID  int64 `json:"dialogId"`  // 子树根dialog，复制时为新建的dialog
+	SessionID int64 `json:"sessionId"` // 子树现在所属的会话
+}
+
+// MoveSubtree 把dialog及其所有子dialog移动到目标对话之后
+func (DialogApi) MoveSubtree(c *gin.Context) {
+	subtreeAction(c, dialog_service.MoveSubtree, "移动成功")
+}
+
+// CopySubtree 把dialog及其所有子dialog复制到目标对话之后，原子树保持不变
+func (DialogApi) CopySubtree(c *gin.Context) {
+	subtreeAction(c, dialog_service.CopySubtree, "复制成功")
+}
+
+func subtreeAction(c *gin.Context, action func(dialogID, targetConversationID int64) (*models.DialogModel, error), msg string) {
+	dialogId, err := strconv.ParseInt(c.Param("dialogId"), 10, 64)
+	if err != nil {
+		res.FailWithMessage("dialog ID无效", c)
+		return
+	}
+	var req SubtreeReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		res.FailWithMessage("参数错误", c)
+		return
+	}
+
+	dialog, err := action(dialogId, req.TargetConversationID)
+	if err != nil {
+		res.FailWithMessage(err.Error(), c)
+		return
+	}
+	res.OkWithDetail(SubtreeResponse{DialogID: dialog.ID, SessionID: dialog.SessionID}, msg, c)
+}
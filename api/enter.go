@@ -5,13 +5,19 @@ package api
 import (
 	"dialogTree/api/category_api"
 	"dialogTree/api/dialog_api"
+	"dialogTree/api/persona_api"
 	"dialogTree/api/session_api"
+	"dialogTree/api/stats_api"
+	"dialogTree/api/template_api"
 )
 
 type Api struct {
 	SessionApi  session_api.SessionApi
 	DialogApi   dialog_api.DialogApi
 	CategoryApi category_api.CategoryApi
+	StatsApi    stats_api.StatsApi
+	PersonaApi  persona_api.PersonaApi
+	TemplateApi template_api.TemplateApi
 }
 
 var App = new(Api)
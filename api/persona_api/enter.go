@@ -0,0 +1,5 @@
+// Path: ./api/persona_api/enter.go
+
+package persona_api
+
+type PersonaApi struct{}
@@ -0,0 +1,153 @@
+// Path: ./api/persona_api/persona_api.go
+
+package persona_api
+
+import (
+	"dialogTree/common/res"
+	"dialogTree/global"
+	"dialogTree/models"
+	"dialogTree/service/ai_service"
+	"github.com/gin-gonic/gin"
+	"strconv"
+	"strings"
+)
+
+type PersonaReq struct {
+	Name         string   `json:"name" binding:"required"`
+	SystemPrompt string   `json:"systemPrompt" binding:"required"`
+	Provider     string   `json:"provider"`                                    // 可选，默认提供商
+	Model        string   `json:"model"`                                       // 可选，默认模型
+	Temperature  *float64 `json:"temperature" binding:"omitempty,min=0,max=2"` // 可选，默认采样温度
+}
+
+// validate 校验名称和角色说明不为空，指定的提供商必须已配置
+func (req PersonaReq) validate() string {
+	if strings.TrimSpace(req.Name) == "" {
+		return "无效角色名"
+	}
+	if strings.TrimSpace(req.SystemPrompt) == "" {
+		return "角色说明不能为空"
+	}
+	if req.Provider != "" {
+		if _, err := ai_service.ResolveProvider(req.Provider); err != nil {
+			return "AI提供商不存在"
+		}
+	}
+	return ""
+}
+
+// personaID 解析路径中的角色ID
+func personaID(c *gin.Context) (int64, bool) {
+	id, err := strconv.ParseInt(c.Param("personaId"), 10, 64)
+	if err != nil {
+		res.FailWithMessage("角色ID无效", c)
+		return 0, false
+	}
+	return id, true
+}
+
+func (*PersonaApi) GetPersonaList(c *gin.Context) {
+	var personas []models.PersonaModel
+	err := global.DB.Order("id ASC").Find(&personas).Error
+	if err != nil {
+		res.Fail(err, "查询失败", c)
+		return
+	}
+	res.SuccessWithList(personas, len(personas), c)
+}
+
+func (*PersonaApi) GetPersona(c *gin.Context) {
+	id, ok := personaID(c)
+	if !ok {
+		return
+	}
+	var persona models.PersonaModel
+	if err := global.DB.First(&persona, id).Error; err != nil {
+		res.FailWithMessage("角色不存在", c)
+		return
+	}
+	res.OkWithDetail(persona, "获取成功", c)
+}
+
+func (*PersonaApi) CreatePersona(c *gin.Context) {
+	var req PersonaReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		res.FailWithMessage("参数错误", c)
+		return
+	}
+	if msg := req.validate(); msg != "" {
+		res.FailWithMessage(msg, c)
+		return
+	}
+
+	persona := models.PersonaModel{
+		Name:         strings.TrimSpace(req.Name),
+		SystemPrompt: req.SystemPrompt,
+		Provider:     req.Provider,
+		ModelName:    req.Model,
+		Temperature:  req.Temperature,
+	}
+	if err := global.DB.Create(&persona).Error; err != nil {
+		if strings.Contains(err.Error(), "Duplicate entry") || strings.Contains(err.Error(), "UNIQUE constraint") {
+			res.Fail(err, "角色已存在", c)
+			return
+		}
+		res.Fail(err, "角色创建失败", c)
+		return
+	}
+	res.OkWithDetail(persona, "角色创建成功", c)
+}
+
+func (*PersonaApi) UpdatePersona(c *gin.Context) {
+	id, ok := personaID(c)
+	if !ok {
+		return
+	}
+	var req PersonaReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		res.FailWithMessage("参数错误", c)
+		return
+	}
+	if msg := req.validate(); msg != "" {
+		res.FailWithMessage(msg, c)
+		return
+	}
+
+	var persona models.PersonaModel
+	if err := global.DB.First(&persona, id).Error; err != nil {
+		res.FailWithMessage("角色不存在", c)
+		return
+	}
+	persona.Name = strings.TrimSpace(req.Name)
+	persona.SystemPrompt = req.SystemPrompt
+	persona.Provider = req.Provider
+	persona.ModelName = req.Model
+	persona.Temperature = req.Temperature
+	if err := global.DB.Save(&persona).Error; err != nil {
+		res.Fail(err, "更新失败", c)
+		return
+	}
+	res.OkWithDetail(persona, "更新成功", c)
+}
+
+func (*PersonaApi) DeletePersona(c *gin.Context) {
+	id, ok := personaID(c)
+	if !ok {
+		return
+	}
+	var count int64
+	err := global.DB.Model(&models.SessionModel{}).Where("persona_id = ?", id).Count(&count).Error
+	if err != nil {
+		res.Fail(err, "查询数据库失败", c)
+		return
+	}
+	if count > 0 {
+		res.FailWithMessage("无法删除仍被会话使用的角色", c)
+		return
+	}
+	if err := global.DB.Delete(&models.PersonaModel{}, "id = ?", id).Error; err != nil {
+		res.Fail(err, "删除失败", c)
+		return
+	}
+	res.SuccessWithMsg("删除成功", c)
+}
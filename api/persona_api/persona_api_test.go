@@ -0,0 +1,102 @@
+package persona_api
+
+import (
+	"bytes"
+	"dialogTree/models"
+	"dialogTree/service/test_service"
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// setupTestEnvironment 设置测试环境
+func setupTestEnvironment(t *testing.T) (*gorm.DB, *gin.Engine) {
+	db, router := test_service.SetupTestEnvironment(t)
+
+	personaApi := PersonaApi{}
+	router.GET("/api/personas", personaApi.GetPersonaList)
+	router.GET("/api/personas/:personaId", personaApi.GetPersona)
+	router.POST("/api/personas", personaApi.CreatePersona)
+	router.PUT("/api/personas/:personaId", personaApi.UpdatePersona)
+	router.DELETE("/api/personas/:personaId", personaApi.DeletePersona)
+
+	return db, router
+}
+
+func doRequest(router *gin.Engine, method, url string, body any) map[string]any {
+	var buf bytes.Buffer
+	if body != nil {
+		json.NewEncoder(&buf).Encode(body)
+	}
+	req, _ := http.NewRequest(method, url, &buf)
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	var response map[string]any
+	json.Unmarshal(w.Body.Bytes(), &response)
+	return response
+}
+
+func TestPersonaCRUD(t *testing.T) {
+	db, router := setupTestEnvironment(t)
+	temperature := 0.2
+
+	var personaID int64
+	t.Run("创建角色", func(t *testing.T) {
+		response := doRequest(router, "POST", "/api/personas", PersonaReq{
+			Name:         "Go代码审查员",
+			SystemPrompt: "你是一名严格的Go代码审查员。",
+			Model:        "test-model",
+			Temperature:  &temperature,
+		})
+		assert.Equal(t, float64(0), response["code"])
+
+		var persona models.PersonaModel
+		assert.NoError(t, db.Where("name = ?", "Go代码审查员").First(&persona).Error)
+		assert.Equal(t, "test-model", persona.ModelName)
+		assert.Equal(t, 0.2, *persona.Temperature)
+		personaID = persona.ID
+	})
+
+	t.Run("名称重复和缺少角色说明", func(t *testing.T) {
+		response := doRequest(router, "POST", "/api/personas", PersonaReq{Name: "Go代码审查员", SystemPrompt: "重复"})
+		assert.NotEqual(t, float64(0), response["code"])
+		response = doRequest(router, "POST", "/api/personas", map[string]string{"name": "旅行规划师"})
+		assert.NotEqual(t, float64(0), response["code"])
+	})
+
+	t.Run("修改角色", func(t *testing.T) {
+		response := doRequest(router, "PUT", fmt.Sprintf("/api/personas/%d", personaID), PersonaReq{
+			Name:         "Go代码审查员",
+			SystemPrompt: "你是一名友好的Go代码审查员。",
+		})
+		assert.Equal(t, float64(0), response["code"])
+
+		var persona models.PersonaModel
+		db.First(&persona, personaID)
+		assert.Equal(t, "你是一名友好的Go代码审查员。", persona.SystemPrompt)
+		assert.Nil(t, persona.Temperature)
+	})
+
+	t.Run("被会话使用时不能删除", func(t *testing.T) {
+		session := models.SessionModel{Tittle: "审查", PersonaID: &personaID}
+		db.Create(&session)
+		doRequest(router, "DELETE", fmt.Sprintf("/api/personas/%d", personaID), nil)
+
+		var count int64
+		db.Model(&models.PersonaModel{}).Where("id = ?", personaID).Count(&count)
+		assert.Equal(t, int64(1), count)
+
+		db.Delete(&session)
+		response := doRequest(router, "DELETE", fmt.Sprintf("/api/personas/%d", personaID), nil)
+		assert.Equal(t, float64(0), response["code"])
+		db.Model(&models.PersonaModel{}).Where("id = ?", personaID).Count(&count)
+		assert.Equal(t, int64(0), count)
+	})
+}
@@ -16,11 +16,23 @@ import (
 type CreateSessionReq struct {
 	Title      string `json:"title" binding:"required"`
 	CategoryID int64  `json:"categoryID"`
+	PersonaID  *int64 `json:"personaID"` // 可选，会话使用的角色
 }
 
 type UpdateSessionReq struct {
 	Title      string `json:"title" binding:"required"`
 	CategoryID int64  `json:"categoryID" binding:"required"`
+	PersonaID  *int64 `json:"personaID"` // 为空表示不使用角色
+}
+
+// checkPersona 校验指定的角色是否存在
+func checkPersona(personaID *int64) bool {
+	if personaID == nil {
+		return true
+	}
+	var count int64
+	global.DB.Model(&models.PersonaModel{}).Where("id = ?", *personaID).Count(&count)
+	return count > 0
 }
 
 type SessionListResponse struct {
@@ -28,6 +40,7 @@ type SessionListResponse struct {
 	Title      string `json:"title"`
 	Summary    string `json:"summary"`
 	CategoryID int64  `json:"categoryID"`
+	PersonaID  *int64 `json:"personaID"`
 	CreatedAt  string `json:"createdAt"`
 	UpdatedAt  string `json:"updatedAt"`
 }
@@ -37,6 +50,9 @@ type DialogTreeNode struct {
 	ParentID      *int64             `json:"parentId"`
 	Conversations []ConversationInfo `json:"conversations"`
 	Children      []*DialogTreeNode  `json:"children"`
+
+	// 合并产生的dialog除 ParentID 外的其他父dialog，树因此成为有向无环图
+	MergeParentIDs []int64 `json:"mergeParentIds,omitempty"`
 }
 
 type ConversationInfo struct {
@@ -45,9 +61,21 @@ type ConversationInfo struct {
 	Summary   string `json:"summary"`
 	Prompt    string `json:"prompt"`
 	Answer    string `json:"answer"`
+	Provider  string `json:"provider"`
+	Model     string `json:"model"`
 	IsStarred bool   `json:"isStarred"`
+	IsPinned  bool   `json:"isPinned"`
 	Comment   string `json:"comment"`
 	CreatedAt string `json:"createdAt"`
+
+	// 同一问题的原回答和重新生成的回答共享同一个值（原对话ID），前端据此展示为备选回答
+	RegeneratedFromID *int64 `json:"regeneratedFromId,omitempty"`
+	AlternativeGroup  *int64 `json:"alternativeGroup,omitempty"`
+
+	// 合并两个分支产生的对话记录全部父对话
+	MergeParents []int64 `json:"mergeParents,omitempty"`
+
+	IsArchived bool `json:"isArchived"` // 仅 includeArchived=true 时可能为 true
 }
 
 // GetSessionList 获取会话列表
@@ -66,6 +94,7 @@ func (SessionApi) GetSessionList(c *gin.Context) {
 			Title:      session.Tittle, // 注意：原模型中是 Tittle
 			Summary:    session.Summary,
 			CategoryID: session.CategoryID,
+			PersonaID:  session.PersonaID,
 			CreatedAt:  session.CreatedAt.Format("2006-01-02 15:04:05"),
 			UpdatedAt:  session.UpdatedAt.Format("2006-01-02 15:04:05"),
 		})
@@ -108,6 +137,7 @@ func (SessionApi) GetSessionsByCategory(c *gin.Context) {
 			Title:      session.Tittle,
 			Summary:    session.Summary,
 			CategoryID: session.CategoryID,
+			PersonaID:  session.PersonaID,
 			CreatedAt:  session.CreatedAt.Format("2006-01-02 15:04:05"),
 			UpdatedAt:  session.UpdatedAt.Format("2006-01-02 15:04:05"),
 		})
@@ -149,6 +179,11 @@ func (SessionApi) UpdateSession(c *gin.Context) {
 		return
 	}
 
+	if !checkPersona(req.PersonaID) {
+		res.FailWithMessage("角色不存在", c)
+		return
+	}
+
 	// 检查session是否存在
 	var session models.SessionModel
 	err = global.DB.First(&session, sessionId).Error
@@ -160,6 +195,7 @@ func (SessionApi) UpdateSession(c *gin.Context) {
 	// 更新session信息
 	session.Tittle = req.Title
 	session.CategoryID = req.CategoryID
+	session.PersonaID = req.PersonaID
 
 	err = global.DB.Save(&session).Error
 	if err != nil {
@@ -172,6 +208,7 @@ func (SessionApi) UpdateSession(c *gin.Context) {
 		"title":        session.Tittle,
 		"categoryId":   session.CategoryID,
 		"categoryName": category.Name,
+		"personaId":    session.PersonaID,
 	}, "更新成功", c)
 }
 
@@ -186,11 +223,16 @@ func (SessionApi) CreateSession(c *gin.Context) {
 	if req.CategoryID == 0 {
 		req.CategoryID = 1 // 默认分类
 	}
+	if !checkPersona(req.PersonaID) {
+		res.FailWithMessage("角色不存在", c)
+		return
+	}
 
 	session := models.SessionModel{
 		Tittle:     req.Title,
 		Summary:    "",
 		CategoryID: req.CategoryID,
+		PersonaID:  req.PersonaID,
 	}
 
 	err := global.DB.Create(&session).Error
@@ -205,7 +247,45 @@ func (SessionApi) CreateSession(c *gin.Context) {
 	}, "创建成功", c)
 }
 
-// GetSessionTree 获取会话的对话树
+type RetitleSessionReq struct {
+	KeepSessionTitle bool `json:"keepSessionTitle"` // 保留已有的会话标题，仅为空时才更新
+}
+
+// RetitleSession 为会话中的所有对话重新生成标题
+func (SessionApi) RetitleSession(c *gin.Context) {
+	sessionIdStr := c.Param("sessionId")
+	sessionId, err := strconv.ParseInt(sessionIdStr, 10, 64)
+	if err != nil {
+		res.FailWithMessage("会话ID无效", c)
+		return
+	}
+
+	var req RetitleSessionReq
+	// 请求体可选
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			res.FailWithMessage("参数错误", c)
+			return
+		}
+	}
+
+	var session models.SessionModel
+	err = global.DB.First(&session, sessionId).Error
+	if err != nil {
+		res.FailWithMessage("会话不存在", c)
+		return
+	}
+
+	result, err := dialog_service.RetitleSession(c.Request.Context(), sessionId, req.KeepSessionTitle)
+	if err != nil {
+		res.Fail(err, "重新生成标题失败", c)
+		return
+	}
+
+	res.OkWithDetail(result, "重新生成标题成功", c)
+}
+
+// GetSessionTree 获取会话的对话树，归档的分支默认隐藏，includeArchived=true 时一并返回
 func (SessionApi) GetSessionTree(c *gin.Context) {
 	sessionIdStr := c.Param("sessionId")
 	sessionId, err := strconv.ParseInt(sessionIdStr, 10, 64)
@@ -232,8 +312,18 @@ func (SessionApi) GetSessionTree(c *gin.Context) {
 		return
 	}
 
+	if c.Query("includeArchived") != "true" {
+		dialogs = hideArchived(dialogs)
+	}
+
+	mergeParents, err := dialog_service.GetMergeParents(sessionId)
+	if err != nil {
+		res.Fail(err, "获取对话树失败", c)
+		return
+	}
+
 	// 构建树结构
-	tree := buildDialogTree(dialogs)
+	tree := buildDialogTree(dialogs, mergeParents)
 
 	res.OkWithDetail(gin.H{
 		"sessionId":   sessionId,
@@ -242,6 +332,41 @@ func (SessionApi) GetSessionTree(c *gin.Context) {
 	}, "获取成功", c)
 }
 
+// GetSessionPins 获取会话中固定的对话
+func (SessionApi) GetSessionPins(c *gin.Context) {
+	sessionIdStr := c.Param("sessionId")
+	sessionId, err := strconv.ParseInt(sessionIdStr, 10, 64)
+	if err != nil {
+		res.FailWithMessage("会话ID无效", c)
+		return
+	}
+
+	conversations, err := dialog_service.GetPinnedConversations(sessionId)
+	if err != nil {
+		res.Fail(err, "获取固定对话失败", c)
+		return
+	}
+
+	response := make([]ConversationInfo, 0, len(conversations))
+	for _, conv := range conversations {
+		response = append(response, ConversationInfo{
+			ID:        conv.ID,
+			Title:     conv.Title,
+			Summary:   conv.Summary,
+			Prompt:    conv.Prompt,
+			Answer:    conv.Answer,
+			Provider:  conv.Provider,
+			Model:     conv.ModelName,
+			IsStarred: conv.IsStarred,
+			IsPinned:  conv.IsPinned,
+			Comment:   conv.Comment,
+			CreatedAt: conv.CreatedAt.Format("2006-01-02 15:04:05"),
+		})
+	}
+
+	res.OkWithDetail(response, "获取成功", c)
+}
+
 // DeleteSession 删除会话
 func (SessionApi) DeleteSession(c *gin.Context) {
 	sessionIdStr := c.Param("sessionId")
@@ -327,11 +452,42 @@ func deleteSessionTransaction(sessionID int64) error {
 	return nil
 }
 
-// 构建对话树的辅助函数
-func buildDialogTree(dialogs []models.DialogModel) []*DialogTreeNode {
+// hideArchived 去掉归档的对话，对话全部归档的dialog及其子树不再出现在对话树中
+func hideArchived(dialogs []models.DialogModel) []models.DialogModel {
+	visible := make([]models.DialogModel, 0, len(dialogs))
+	for _, dialog := range dialogs {
+		convs := make([]*models.ConversationModel, 0, len(dialog.ConversationModels))
+		for _, conv := range dialog.ConversationModels {
+			if !conv.IsArchived {
+				convs = append(convs, conv)
+			}
+		}
+		if len(convs) == 0 && len(dialog.ConversationModels) > 0 {
+			continue
+		}
+		dialog.ConversationModels = convs
+		visible = append(visible, dialog)
+	}
+	return visible
+}
+
+// 构建对话树的辅助函数，mergeParents 为合并产生的对话及其全部父对话
+func buildDialogTree(dialogs []models.DialogModel, mergeParents map[int64][]int64) []*DialogTreeNode {
 	dialogMap := make(map[int64]*DialogTreeNode)
 	var roots []*DialogTreeNode
 
+	// 被重新生成过的原对话，以及每条对话所在的dialog
+	regenerated := make(map[int64]bool)
+	convDialog := make(map[int64]int64)
+	for _, dialog := range dialogs {
+		for _, conv := range dialog.ConversationModels {
+			if conv.RegeneratedFromID != nil {
+				regenerated[*conv.RegeneratedFromID] = true
+			}
+			convDialog[conv.ID] = dialog.ID
+		}
+	}
+
 	// 创建所有节点
 	for _, dialog := range dialogs {
 		node := &DialogTreeNode{
@@ -343,16 +499,37 @@ func buildDialogTree(dialogs []models.DialogModel) []*DialogTreeNode {
 
 		// 添加会话信息
 		for _, conv := range dialog.ConversationModels {
-			node.Conversations = append(node.Conversations, ConversationInfo{
+			info := ConversationInfo{
 				ID:        conv.ID,
 				Title:     conv.Title,
 				Summary:   conv.Summary,
 				Prompt:    conv.Prompt,
 				Answer:    conv.Answer,
+				Provider:  conv.Provider,
+				Model:     conv.ModelName,
 				IsStarred: conv.IsStarred,
+				IsPinned:  conv.IsPinned,
 				Comment:   conv.Comment,
 				CreatedAt: conv.CreatedAt.Format("2006-01-02 15:04:05"),
-			})
+
+				RegeneratedFromID: conv.RegeneratedFromID,
+				IsArchived:        conv.IsArchived,
+			}
+			if conv.RegeneratedFromID != nil {
+				info.AlternativeGroup = conv.RegeneratedFromID
+			} else if regenerated[conv.ID] {
+				info.AlternativeGroup = &conv.ID
+			}
+			if parents, ok := mergeParents[conv.ID]; ok {
+				info.MergeParents = parents
+				for _, parentID := range parents {
+					parentDialog, exists := convDialog[parentID]
+					if exists && (dialog.ParentID == nil || parentDialog != *dialog.ParentID) {
+						node.MergeParentIDs = append(node.MergeParentIDs, parentDialog)
+					}
+				}
+			}
+			node.Conversations = append(node.Conversations, info)
 		}
 
 		dialogMap[dialog.ID] = node
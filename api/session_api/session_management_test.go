@@ -2,6 +2,7 @@ package session_api
 
 import (
 	"bytes"
+	"dialogTree/conf"
 	"dialogTree/global"
 	"dialogTree/models"
 	"encoding/json"
@@ -135,3 +136,50 @@ func TestDeleteSession(t *testing.T) {
 	global.DB.Model(&models.SessionModel{}).Where("id = ?", session.ID).Count(&count)
 	assert.Equal(t, int64(0), count)
 }
+
+func TestRetitleSession(t *testing.T) {
+	global.Config = &conf.Config{} // 未配置提供商，使用 mock
+	global.DB.Exec("DELETE FROM conversation_models")
+	global.DB.Exec("DELETE FROM dialog_models")
+	global.DB.Exec("DELETE FROM session_models")
+
+	session := models.SessionModel{}
+	global.DB.Create(&session)
+	dialog := models.DialogModel{SessionID: session.ID}
+	global.DB.Create(&dialog)
+	global.DB.Model(&session).Update("root_dialog_id", dialog.ID)
+	for i := 0; i < 2; i++ {
+		global.DB.Create(&models.ConversationModel{
+			SessionID: session.ID,
+			DialogID:  dialog.ID,
+			Prompt:    fmt.Sprintf("问题%d", i),
+			Answer:    fmt.Sprintf("回答%d", i),
+		})
+	}
+
+	router := setupRouter()
+	api := SessionApi{}
+	router.POST("/sessions/:sessionId/retitle", api.RetitleSession)
+
+	req, _ := http.NewRequest("POST", fmt.Sprintf("/sessions/%d/retitle", session.ID), nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var response map[string]any
+	json.Unmarshal(w.Body.Bytes(), &response)
+	data := response["data"].(map[string]any)
+	assert.Equal(t, float64(2), data["updated"])
+	assert.Equal(t, "测试标题", data["sessionTitle"])
+
+	var convs []models.ConversationModel
+	global.DB.Where("session_id = ?", session.ID).Find(&convs)
+	for _, conv := range convs {
+		assert.Equal(t, "测试标题", conv.Title)
+		assert.Equal(t, "这是一个测试摘要", conv.Summary)
+	}
+
+	var updated models.SessionModel
+	global.DB.First(&updated, session.ID)
+	assert.Equal(t, "测试标题", updated.Tittle)
+}
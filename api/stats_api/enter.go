@@ -0,0 +1,5 @@
+// Path: ./api/stats_api/enter.go
+
+package stats_api
+
+type StatsApi struct{}
@@ -0,0 +1,151 @@
+// Path: ./api/stats_api/usage.go
+
+package stats_api
+
+import (
+	"dialogTree/common/res"
+	"dialogTree/global"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type UsageStatsReq struct {
+	GroupBy string `form:"groupBy"` // day/session/category/provider，默认 day
+	Start   string `form:"start"`   // 起始日期 2006-01-02，可选
+	End     string `form:"end"`     // 结束日期（含当天），可选
+}
+
+type UsageStat struct {
+	Key              string  `json:"key"`
+	Label            string  `json:"label"`
+	Conversations    int     `json:"conversations"`
+	PromptTokens     int     `json:"promptTokens"`
+	CompletionTokens int     `json:"completionTokens"`
+	TotalTokens      int     `json:"totalTokens"`
+	Cost             float64 `json:"cost"`
+}
+
+func (s *UsageStat) add(row usageRow) {
+	s.Conversations++
+	s.PromptTokens += row.PromptTokens
+	s.CompletionTokens += row.CompletionTokens
+	s.TotalTokens += row.PromptTokens + row.CompletionTokens
+	s.Cost += row.Cost
+}
+
+type usageRow struct {
+	CreatedAt        time.Time
+	SessionID        int64
+	Provider         string
+	PromptTokens     int
+	CompletionTokens int
+	Cost             float64
+	CategoryID       int64
+	SessionTitle     string
+	CategoryName     string
+}
+
+// groupKey 返回用量记录在指定维度下的分组键和展示名称
+func groupKey(groupBy string, row usageRow) (key, label string) {
+	switch groupBy {
+	case "session":
+		return strconv.FormatInt(row.SessionID, 10), row.SessionTitle
+	case "category":
+		return strconv.FormatInt(row.CategoryID, 10), row.CategoryName
+	case "provider":
+		if row.Provider == "" {
+			return "", "未知"
+		}
+		return row.Provider, row.Provider
+	default:
+		day := row.CreatedAt.Local().Format("2006-01-02")
+		return day, day
+	}
+}
+
+// GetUsageStats 按天、会话、分类或提供商汇总 token 用量和费用
+func (StatsApi) GetUsageStats(c *gin.Context) {
+	var req UsageStatsReq
+	if err := c.ShouldBindQuery(&req); err != nil {
+		res.FailWithMessage("参数错误", c)
+		return
+	}
+
+	switch req.GroupBy {
+	case "":
+		req.GroupBy = "day"
+	case "day", "session", "category", "provider":
+	default:
+		res.FailWithMessage("groupBy 只能是 day/session/category/provider", c)
+		return
+	}
+
+	query := global.DB.Table("conversation_models").
+		Select("conversation_models.created_at, conversation_models.session_id, conversation_models.provider, " +
+			"conversation_models.prompt_tokens, conversation_models.completion_tokens, conversation_models.cost, " +
+			"session_models.category_id, session_models.tittle AS session_title, category_models.name AS category_name").
+		Joins("LEFT JOIN session_models ON session_models.id = conversation_models.session_id").
+		Joins("LEFT JOIN category_models ON category_models.id = session_models.category_id")
+
+	if req.Start != "" {
+		start, err := time.ParseInLocation("2006-01-02", req.Start, time.Local)
+		if err != nil {
+			res.FailWithMessage("起始日期格式应为 2006-01-02", c)
+			return
+		}
+		query = query.Where("conversation_models.created_at >= ?", start)
+	}
+	if req.End != "" {
+		end, err := time.ParseInLocation("2006-01-02", req.End, time.Local)
+		if err != nil {
+			res.FailWithMessage("结束日期格式应为 2006-01-02", c)
+			return
+		}
+		query = query.Where("conversation_models.created_at < ?", end.AddDate(0, 0, 1))
+	}
+
+	var rows []usageRow
+	if err := query.Scan(&rows).Error; err != nil {
+		res.Fail(err, "查询用量失败", c)
+		return
+	}
+
+	// 在内存中分组，避免不同数据库日期函数和时区处理的差异
+	var total UsageStat
+	groups := map[string]*UsageStat{}
+	for _, row := range rows {
+		key, label := groupKey(req.GroupBy, row)
+		g, ok := groups[key]
+		if !ok {
+			g = &UsageStat{Key: key, Label: label}
+			groups[key] = g
+		}
+		g.add(row)
+		total.add(row)
+	}
+
+	list := make([]UsageStat, 0, len(groups))
+	for _, g := range groups {
+		list = append(list, *g)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if req.GroupBy == "day" {
+			return list[i].Key < list[j].Key
+		}
+		if list[i].Cost != list[j].Cost {
+			return list[i].Cost > list[j].Cost
+		}
+		return list[i].TotalTokens > list[j].TotalTokens
+	})
+
+	total.Key, total.Label = "total", "合计"
+	res.OkWithDetail(gin.H{
+		"groupBy": req.GroupBy,
+		"groups":  list,
+		"total":   total,
+	}, fmt.Sprintf("共 %d 条对话", total.Conversations), c)
+}
@@ -0,0 +1,104 @@
+package stats_api
+
+import (
+	"dialogTree/global"
+	"dialogTree/models"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	if err != nil {
+		panic("Failed to connect to database!")
+	}
+	global.DB = db
+
+	err = global.DB.AutoMigrate(&models.SessionModel{}, &models.DialogModel{}, &models.ConversationModel{}, &models.CategoryModel{})
+	if err != nil {
+		panic("Failed to migrate database!")
+	}
+
+	os.Exit(m.Run())
+}
+
+func TestGetUsageStats(t *testing.T) {
+	category := models.CategoryModel{Name: "工作"}
+	global.DB.Create(&category)
+	s1 := models.SessionModel{Tittle: "会话一", CategoryID: category.ID}
+	s2 := models.SessionModel{Tittle: "会话二", CategoryID: category.ID}
+	global.DB.Create(&s1)
+	global.DB.Create(&s2)
+
+	convs := []models.ConversationModel{
+		{SessionID: s1.ID, Provider: "deepseek", PromptTokens: 100, CompletionTokens: 50, Cost: 0.1},
+		{SessionID: s1.ID, Provider: "openai", PromptTokens: 200, CompletionTokens: 100, Cost: 0.5},
+		{SessionID: s2.ID, Provider: "deepseek", PromptTokens: 10, CompletionTokens: 5, Cost: 0.01},
+	}
+	for i := range convs {
+		global.DB.Create(&convs[i])
+	}
+
+	router := gin.Default()
+	router.GET("/stats/usage", StatsApi{}.GetUsageStats)
+
+	get := func(query string) map[string]any {
+		req, _ := http.NewRequest("GET", "/stats/usage"+query, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+		var response map[string]any
+		json.Unmarshal(w.Body.Bytes(), &response)
+		return response["data"].(map[string]any)
+	}
+
+	t.Run("按提供商", func(t *testing.T) {
+		data := get("?groupBy=provider")
+		groups := data["groups"].([]any)
+		assert.Len(t, groups, 2)
+		first := groups[0].(map[string]any)
+		assert.Equal(t, "openai", first["key"])
+		second := groups[1].(map[string]any)
+		assert.Equal(t, float64(2), second["conversations"])
+		assert.Equal(t, float64(165), second["totalTokens"])
+
+		total := data["total"].(map[string]any)
+		assert.Equal(t, float64(465), total["totalTokens"])
+		assert.InDelta(t, 0.61, total["cost"], 1e-9)
+	})
+
+	t.Run("按会话", func(t *testing.T) {
+		groups := get("?groupBy=session")["groups"].([]any)
+		assert.Len(t, groups, 2)
+		assert.Equal(t, "会话一", groups[0].(map[string]any)["label"])
+	})
+
+	t.Run("按分类", func(t *testing.T) {
+		groups := get("?groupBy=category")["groups"].([]any)
+		assert.Len(t, groups, 1)
+		assert.Equal(t, "工作", groups[0].(map[string]any)["label"])
+	})
+
+	t.Run("按天", func(t *testing.T) {
+		groups := get("")["groups"].([]any)
+		assert.Len(t, groups, 1)
+	})
+
+	t.Run("无效分组", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", "/stats/usage?groupBy=model", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		var response map[string]any
+		json.Unmarshal(w.Body.Bytes(), &response)
+		assert.NotEqual(t, float64(0), response["code"])
+	})
+}
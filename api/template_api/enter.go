@@ -0,0 +1,5 @@
+// Path: ./api/template_api/enter.go
+
+package template_api
+
+type TemplateApi struct{}
@@ -0,0 +1,152 @@
+// Path: ./api/template_api/template_api.go
+
+package template_api
+
+import (
+	"dialogTree/common/res"
+	"dialogTree/global"
+	"dialogTree/models"
+	"dialogTree/service/dialog_service"
+	"github.com/gin-gonic/gin"
+	"strconv"
+	"strings"
+)
+
+type TemplateReq struct {
+	Name        string   `json:"name" binding:"required"`
+	Description string   `json:"description"`
+	Steps       []string `json:"steps" binding:"required,min=1"` // 依次执行的步骤，{{变量}} 在调用时替换，{{previous}} 为上一步的回答
+}
+
+// TemplateResponse 模板及其需要的变量
+type TemplateResponse struct {
+	models.PromptTemplateModel
+	Variables []string `json:"variables"`
+}
+
+func newTemplateResponse(tmpl models.PromptTemplateModel) TemplateResponse {
+	variables := dialog_service.TemplateVariables(tmpl.Steps)
+	if variables == nil {
+		variables = []string{}
+	}
+	return TemplateResponse{PromptTemplateModel: tmpl, Variables: variables}
+}
+
+// validate 校验名称和步骤不为空
+func (req TemplateReq) validate() string {
+	if strings.TrimSpace(req.Name) == "" {
+		return "无效模板名"
+	}
+	for _, step := range req.Steps {
+		if strings.TrimSpace(step) == "" {
+			return "模板步骤不能为空"
+		}
+	}
+	return ""
+}
+
+// templateID 解析路径中的模板ID
+func templateID(c *gin.Context) (int64, bool) {
+	id, err := strconv.ParseInt(c.Param("templateId"), 10, 64)
+	if err != nil {
+		res.FailWithMessage("模板ID无效", c)
+		return 0, false
+	}
+	return id, true
+}
+
+func (*TemplateApi) GetTemplateList(c *gin.Context) {
+	var templates []models.PromptTemplateModel
+	err := global.DB.Order("id ASC").Find(&templates).Error
+	if err != nil {
+		res.Fail(err, "查询失败", c)
+		return
+	}
+	list := make([]TemplateResponse, 0, len(templates))
+	for _, tmpl := range templates {
+		list = append(list, newTemplateResponse(tmpl))
+	}
+	res.SuccessWithList(list, len(list), c)
+}
+
+func (*TemplateApi) GetTemplate(c *gin.Context) {
+	id, ok := templateID(c)
+	if !ok {
+		return
+	}
+	tmpl, err := dialog_service.GetTemplate(id)
+	if err != nil {
+		res.FailWithMessage("模板不存在", c)
+		return
+	}
+	res.OkWithDetail(newTemplateResponse(*tmpl), "获取成功", c)
+}
+
+func (*TemplateApi) CreateTemplate(c *gin.Context) {
+	var req TemplateReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		res.FailWithMessage("参数错误", c)
+		return
+	}
+	if msg := req.validate(); msg != "" {
+		res.FailWithMessage(msg, c)
+		return
+	}
+
+	tmpl := models.PromptTemplateModel{
+		Name:        strings.TrimSpace(req.Name),
+		Description: req.Description,
+		Steps:       req.Steps,
+	}
+	if err := global.DB.Create(&tmpl).Error; err != nil {
+		if strings.Contains(err.Error(), "Duplicate entry") || strings.Contains(err.Error(), "UNIQUE constraint") {
+			res.Fail(err, "模板已存在", c)
+			return
+		}
+		res.Fail(err, "模板创建失败", c)
+		return
+	}
+	res.OkWithDetail(newTemplateResponse(tmpl), "模板创建成功", c)
+}
+
+func (*TemplateApi) UpdateTemplate(c *gin.Context) {
+	id, ok := templateID(c)
+	if !ok {
+		return
+	}
+	var req TemplateReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		res.FailWithMessage("参数错误", c)
+		return
+	}
+	if msg := req.validate(); msg != "" {
+		res.FailWithMessage(msg, c)
+		return
+	}
+
+	tmpl, err := dialog_service.GetTemplate(id)
+	if err != nil {
+		res.FailWithMessage("模板不存在", c)
+		return
+	}
+	tmpl.Name = strings.TrimSpace(req.Name)
+	tmpl.Description = req.Description
+	tmpl.Steps = req.Steps
+	if err := global.DB.Save(tmpl).Error; err != nil {
+		res.Fail(err, "更新失败", c)
+		return
+	}
+	res.OkWithDetail(newTemplateResponse(*tmpl), "更新成功", c)
+}
+
+func (*TemplateApi) DeleteTemplate(c *gin.Context) {
+	id, ok := templateID(c)
+	if !ok {
+		return
+	}
+	if err := global.DB.Delete(&models.PromptTemplateModel{}, "id = ?", id).Error; err != nil {
+		res.Fail(err, "删除失败", c)
+		return
+	}
+	res.SuccessWithMsg("删除成功", c)
+}
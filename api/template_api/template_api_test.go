@@ -0,0 +1,44 @@
+package template_api
+
+import (
+	"bytes"
+	"dialogTree/service/test_service"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreateTemplate(t *testing.T) {
+	_, router := test_service.SetupTestEnvironment(t)
+	templateApi := TemplateApi{}
+	router.POST("/api/templates", templateApi.CreateTemplate)
+
+	send := func(body TemplateReq) map[string]any {
+		jsonBody, _ := json.Marshal(body)
+		req, _ := http.NewRequest("POST", "/api/templates", bytes.NewBuffer(jsonBody))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		var response map[string]any
+		json.Unmarshal(w.Body.Bytes(), &response)
+		return response
+	}
+
+	t.Run("返回模板需要的变量", func(t *testing.T) {
+		response := send(TemplateReq{Name: "翻译", Steps: []string{"把{{text}}翻译成{{lang}}", "润色：{{previous}}"}})
+		assert.Equal(t, float64(0), response["code"])
+		data := response["data"].(map[string]any)
+		assert.Equal(t, []any{"text", "lang"}, data["variables"])
+		assert.Len(t, data["steps"], 2)
+	})
+
+	t.Run("名称重复或步骤为空", func(t *testing.T) {
+		response := send(TemplateReq{Name: "翻译", Steps: []string{"x"}})
+		assert.NotEqual(t, float64(0), response["code"])
+		response = send(TemplateReq{Name: "空步骤", Steps: []string{" "}})
+		assert.Equal(t, "模板步骤不能为空", response["msg"])
+	})
+}
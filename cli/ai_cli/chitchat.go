@@ -3,6 +3,7 @@
 package ai_cli
 
 import (
+	"context"
 	"dialogTree/common/cres"
 	"dialogTree/service/ai_service"
 	"dialogTree/service/redis_service"
@@ -48,7 +49,7 @@ func chat(input, key string) error {
 		return err
 	}
 	provider := ai_service.GetDefaultProvider()
-	mChan, sChan, err := ai_service.ChatStreamSum(msg, provider)
+	mChan, sChan, err := ai_service.ChatStreamSum(context.Background(), msg, provider, ai_service.ChatOptions{})
 	if err != nil {
 		return err
 	}
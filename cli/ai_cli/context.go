@@ -0,0 +1,74 @@
+// Path: ./cli/ai_cli/context.go
+
+package ai_cli
+
+import (
+	"context"
+	"dialogTree/core"
+	"dialogTree/service/ai_service"
+	"dialogTree/service/dialog_service"
+	"fmt"
+	"strings"
+
+	"github.com/urfave/cli/v3"
+)
+
+// PreviewContext 打印发起对话时会拼装的上下文，不调用模型
+func PreviewContext(ctx context.Context, c *cli.Command) error {
+	core.InitWithVector()
+
+	sessionID := c.Int64("session")
+	if sessionID == 0 {
+		session, err := dialog_service.CliDialogServiceInstance.GetRecentSession()
+		if err != nil {
+			return fmt.Errorf("暂无会话，请用 --session 指定: %v", err)
+		}
+		sessionID = session.ID
+	}
+
+	var parentID *int64
+	if id := c.Int64("parent"); id != 0 {
+		parentID = &id
+	}
+
+	provider, err := ai_service.ResolveProvider(c.String("provider"))
+	if err != nil {
+		return err
+	}
+	model := ai_service.ModelOf(provider, ai_service.ChatOptions{Model: c.String("model")})
+
+	_, report, err := dialog_service.PreviewContext(sessionID, parentID, c.String("text"), model)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("会话 %d，模型 %s，预算 %d tokens，已用 %d（当前问题 %d）\n",
+		sessionID, report.Model, report.Budget, report.Used, report.CurrentTokens)
+	if len(report.Items) == 0 {
+		fmt.Println("没有可用的上下文")
+		return nil
+	}
+
+	for _, item := range report.Items {
+		source := item.Source
+		if item.Source == dialog_service.SourceVector {
+			source = fmt.Sprintf("%s %.3f", item.Source, item.Score)
+		}
+		fmt.Printf("[%-7s] #%-6d %-16s %-7s %5d tokens", item.Section, item.ConversationID, source, item.Level, item.Tokens)
+		if item.Level != dialog_service.LevelDropped {
+			fmt.Printf("  Q: %s", preview(item.Q, 40))
+		}
+		fmt.Println()
+	}
+	return nil
+}
+
+// preview 截取单行预览
+func preview(text string, limit int) string {
+	text = strings.Join(strings.Fields(text), " ")
+	runes := []rune(text)
+	if len(runes) <= limit {
+		return text
+	}
+	return string(runes[:limit]) + "..."
+}
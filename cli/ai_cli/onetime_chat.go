@@ -48,7 +48,7 @@ func OneTimeChat(ctx context.Context, cmd *cli.Command) error {
 
 	cres.AvatarOnly()
 	provider := ai_service.GetDefaultProvider()
-	msgChan, err := ai_service.ChatStream(input, provider)
+	msgChan, err := ai_service.ChatStream(ctx, input, provider, ai_service.ChatOptions{})
 	if err != nil {
 		return err
 	}
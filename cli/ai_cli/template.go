@@ -0,0 +1,121 @@
+// Path: ./cli/ai_cli/template.go
+
+package ai_cli
+
+import (
+	"context"
+	"dialogTree/core"
+	"dialogTree/global"
+	"dialogTree/models"
+	"dialogTree/service/ai_service"
+	"dialogTree/service/dialog_service"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/urfave/cli/v3"
+)
+
+// ListTemplates 列出所有提示词模板及其变量
+func ListTemplates(ctx context.Context, c *cli.Command) error {
+	core.Init()
+
+	var templates []models.PromptTemplateModel
+	if err := global.DB.Order("id ASC").Find(&templates).Error; err != nil {
+		return err
+	}
+	if len(templates) == 0 {
+		fmt.Println("暂无模板")
+		return nil
+	}
+	for _, tmpl := range templates {
+		fmt.Printf("%-20s %d 步  变量: %s  %s\n", tmpl.Name, len(tmpl.Steps),
+			strings.Join(dialog_service.TemplateVariables(tmpl.Steps), ","), tmpl.Description)
+	}
+	return nil
+}
+
+// RunTemplate 执行模板，各步骤在同一分支中依次执行，上一步的回答作为 {{previous}}
+func RunTemplate(ctx context.Context, c *cli.Command) error {
+	name := c.Args().First()
+	if name == "" {
+		return fmt.Errorf("请指定模板名称：dialogtree template run <name> --var k=v")
+	}
+	vars, err := parseTemplateVars(c.StringSlice("var"))
+	if err != nil {
+		return err
+	}
+
+	core.InitWithVector()
+
+	tmpl, err := dialog_service.GetTemplateByName(name)
+	if err != nil {
+		return err
+	}
+	if err := dialog_service.CheckTemplateVars(tmpl.Steps, vars); err != nil {
+		return err
+	}
+
+	session, err := templateSession(c.Int64("session"), tmpl.Name)
+	if err != nil {
+		return err
+	}
+	var parentID *int64
+	if id := c.Int64("parent"); id != 0 {
+		parentID = &id
+	}
+
+	persona, err := dialog_service.GetSessionPersona(*session)
+	if err != nil {
+		return fmt.Errorf("获取会话角色失败: %v", err)
+	}
+	providerName, opts := dialog_service.ApplyPersona(persona, c.String("provider"), ai_service.ChatOptions{Model: c.String("model")})
+	provider, err := ai_service.ResolveProvider(providerName)
+	if err != nil {
+		return err
+	}
+
+	var previous string
+	for i, step := range tmpl.Steps {
+		prompt := dialog_service.RenderTemplateStep(step, vars, previous)
+		fmt.Printf("\n[%d/%d] 你: %s\nAI: ", i+1, len(tmpl.Steps), prompt)
+
+		conv, err := dialog_service.CliDialogServiceInstance.ChatOnce(ctx, session.ID, parentID, prompt, provider, opts, os.Stdout)
+		if err != nil {
+			return fmt.Errorf("第 %d 步执行失败: %v", i+1, err)
+		}
+		parentID = &conv.ID
+		previous = conv.Answer
+	}
+
+	fmt.Printf("\n模板 %s 执行完成，会话 %d，最后一条对话 #%d\n", tmpl.Name, session.ID, *parentID)
+	return nil
+}
+
+// parseTemplateVars 解析 key=value 形式的变量
+func parseTemplateVars(pairs []string) (map[string]string, error) {
+	vars := map[string]string{}
+	for _, pair := range pairs {
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok || strings.TrimSpace(key) == "" {
+			return nil, fmt.Errorf("变量格式应为 key=value: %s", pair)
+		}
+		vars[strings.TrimSpace(key)] = value
+	}
+	return vars, nil
+}
+
+// templateSession 获取执行模板的会话，未指定时使用最近的会话，没有会话时新建
+func templateSession(sessionID int64, title string) (*models.SessionModel, error) {
+	if sessionID != 0 {
+		var session models.SessionModel
+		if err := global.DB.First(&session, sessionID).Error; err != nil {
+			return nil, fmt.Errorf("会话不存在: %v", err)
+		}
+		return &session, nil
+	}
+	if session, err := dialog_service.CliDialogServiceInstance.GetRecentSession(); err == nil {
+		return session, nil
+	}
+	return dialog_service.CliDialogServiceInstance.CreateQuickSession(title)
+}
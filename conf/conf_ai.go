@@ -3,17 +3,91 @@
 package conf
 
 type Ai struct {
-	Enable            bool         `yaml:"enable"`
-	Nickname          string       `yaml:"nickname"`
-	Avatar            string       `yaml:"avatar"`
-	Abstract          string       `yaml:"abstract"`
-	ContextLayers     int          `yaml:"contextLayers"`
-	EmbeddingModel    string       `yaml:"embeddingModel"`
-	EmbeddingProvider string       `yaml:"embeddingProvider"`
-	ChatAnywhere      ChatAnywhere `yaml:"chatAnywhere"`
-	BackendAi         BackendAi    `yaml:"backendAi"`
-	OpenAI            OpenAI       `yaml:"openai"`
-	DeepSeek          DeepSeek     `yaml:"deepseek"`
+	Enable            bool                  `yaml:"enable"`
+	Nickname          string                `yaml:"nickname"`
+	Avatar            string                `yaml:"avatar"`
+	Abstract          string                `yaml:"abstract"`
+	ContextLayers     int                   `yaml:"contextLayers"`
+	EmbeddingModel    string                `yaml:"embeddingModel"`
+	EmbeddingProvider string                `yaml:"embeddingProvider"`
+	DefaultProvider   string                `yaml:"defaultProvider"` // 默认提供商名称，为空时按优先级自动选择
+	ChatAnywhere      ChatAnywhere          `yaml:"chatAnywhere"`
+	BackendAi         BackendAi             `yaml:"backendAi"`
+	OpenAI            OpenAI                `yaml:"openai"`
+	DeepSeek          DeepSeek              `yaml:"deepseek"`
+	Providers         []Provider            `yaml:"providers"` // 自定义提供商列表，与同名的内置配置合并
+	Retry             Retry                 `yaml:"retry"`
+	SummaryStrategy   string                `yaml:"summaryStrategy"` // 摘要策略：marker（回答内分隔符，默认）或 separate（单独请求生成 JSON）
+	Prices            map[string]ModelPrice `yaml:"prices"`          // 按模型名配置单价，未配置的模型费用记为 0
+	ContextBudget     ContextBudget         `yaml:"contextBudget"`
+}
+
+// ContextBudget 上下文 token 预算，不含 system prompt
+type ContextBudget struct {
+	Default     int            `yaml:"default"`     // 默认预算，默认 4000
+	Models      map[string]int `yaml:"models"`      // 按模型名覆盖，按模型实际上下文窗口配置
+	RecentRatio float64        `yaml:"recentRatio"` // 扣除当前问题后分给 recent 的比例，其余给 history，默认 0.7
+}
+
+// For 返回指定模型的上下文预算
+func (b ContextBudget) For(model string) int {
+	if n, ok := b.Models[model]; ok && n > 0 {
+		return n
+	}
+	if b.Default > 0 {
+		return b.Default
+	}
+	return 4000
+}
+
+// Ratio 返回 recent 所占比例
+func (b ContextBudget) Ratio() float64 {
+	if b.RecentRatio <= 0 || b.RecentRatio > 1 {
+		return 0.7
+	}
+	return b.RecentRatio
+}
+
+// ModelPrice 模型单价，货币单位由使用者自定
+type ModelPrice struct {
+	Input  float64 `yaml:"input"`  // 每百万输入 token 的价格
+	Output float64 `yaml:"output"` // 每百万输出 token 的价格
+}
+
+// Cost 按配置的单价计算一次请求的费用
+func (a Ai) Cost(model string, promptTokens, completionTokens int) float64 {
+	price, ok := a.Prices[model]
+	if !ok {
+		return 0
+	}
+	return (float64(promptTokens)*price.Input + float64(completionTokens)*price.Output) / 1e6
+}
+
+// Retry 请求重试与故障转移配置
+type Retry struct {
+	MaxRetries      int  `yaml:"maxRetries"`      // 429/5xx 时的最大重试次数，默认 2，负数表示不重试
+	BaseDelay       int  `yaml:"baseDelay"`       // 首次重试等待毫秒数，之后指数增长，默认 500
+	DisableFailover bool `yaml:"disableFailover"` // 关闭重试耗尽后切换到下一个提供商
+}
+
+// Attempts 返回包含首次请求在内的总尝试次数
+func (r Retry) Attempts() int {
+	if r.MaxRetries < 0 {
+		return 1
+	}
+	if r.MaxRetries == 0 {
+		return 3
+	}
+	return r.MaxRetries + 1
+}
+
+// Delay 返回第 n 次重试（从 0 开始）前的等待时间（毫秒）
+func (r Retry) Delay(n int) int {
+	base := r.BaseDelay
+	if base <= 0 {
+		base = 500
+	}
+	return base << n
 }
 
 type ChatAnywhere struct {
@@ -24,6 +98,7 @@ type ChatAnywhere struct {
 type BackendAi struct {
 	Model     string `yaml:"model"`
 	SecretKey string `yaml:"secretKey"`
+	BaseURL   string `yaml:"baseURL"` // 兼容 OpenAI 协议的接口地址，为空时沿用 ChatAnywhere 的接口地址
 }
 
 type OpenAI struct {
@@ -35,3 +110,70 @@ type DeepSeek struct {
 	Model     string `yaml:"model"`
 	SecretKey string `yaml:"secretKey"`
 }
+
+// Provider 一个可按名称选择的聊天提供商
+type Provider struct {
+	Name      string `yaml:"name"`      // 唯一名称，请求时按名称选择
+	Type      string `yaml:"type"`      // 实现类型：chatanywhere/deepseek/openai/compatible，默认 compatible
+	BaseURL   string `yaml:"baseURL"`   // 接口地址，compatible 类型必填，其他类型可覆盖默认地址
+	SecretKey string `yaml:"secretKey"` // 本地模型等无需鉴权的接口可留空
+	Model     string `yaml:"model"`
+	// DisableUsage 上游不支持 stream_options.include_usage 时开启，改为本地估算用量
+	DisableUsage bool `yaml:"disableUsage"`
+}
+
+// ProviderList 汇总内置配置段与 providers 列表，顺序即默认优先级
+// 优先级：ChatAnywhere > DeepSeek > BackendAI > OpenAI > providers 列表
+func (a Ai) ProviderList() []Provider {
+	var list []Provider
+	if a.ChatAnywhere.SecretKey != "" {
+		list = append(list, Provider{Name: "chatanywhere", Type: "chatanywhere", SecretKey: a.ChatAnywhere.SecretKey, Model: a.ChatAnywhere.Model})
+	}
+	if a.DeepSeek.SecretKey != "" {
+		list = append(list, Provider{Name: "deepseek", Type: "deepseek", SecretKey: a.DeepSeek.SecretKey, Model: a.DeepSeek.Model})
+	}
+	if a.BackendAi.SecretKey != "" {
+		// 未配置 baseURL 的旧配置与之前一样通过 ChatAnywhere 的接口访问
+		backendType := "compatible"
+		if a.BackendAi.BaseURL == "" {
+			backendType = "chatanywhere"
+		}
+		list = append(list, Provider{Name: "backendai", Type: backendType, BaseURL: a.BackendAi.BaseURL, SecretKey: a.BackendAi.SecretKey, Model: a.BackendAi.Model})
+	}
+	if a.OpenAI.SecretKey != "" {
+		list = append(list, Provider{Name: "openai", Type: "openai", SecretKey: a.OpenAI.SecretKey, Model: a.OpenAI.Model})
+	}
+
+	// providers 列表中与内置配置同名的条目原位合并（非空字段覆盖），其余按顺序追加
+	for _, p := range a.Providers {
+		merged := false
+		for i := range list {
+			if list[i].Name == p.Name {
+				list[i] = list[i].merge(p)
+				merged = true
+				break
+			}
+		}
+		if !merged {
+			list = append(list, p)
+		}
+	}
+	return list
+}
+
+func (p Provider) merge(o Provider) Provider {
+	if o.Type != "" {
+		p.Type = o.Type
+	}
+	if o.BaseURL != "" {
+		p.BaseURL = o.BaseURL
+	}
+	if o.SecretKey != "" {
+		p.SecretKey = o.SecretKey
+	}
+	if o.Model != "" {
+		p.Model = o.Model
+	}
+	p.DisableUsage = p.DisableUsage || o.DisableUsage
+	return p
+}
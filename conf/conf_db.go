@@ -4,6 +4,7 @@ package conf
 
 import (
 	"fmt"
+	"strings"
 )
 
 type DB struct {
@@ -24,7 +25,11 @@ func dsn(db DB, dbName string) string {
 	case "pgsql", "postgres":
 		return fmt.Sprintf("user=%s password=%s host=%s port=%d dbname=%s sslmode=disable", db.User, db.Password, db.Host, db.Port, dbName)
 	case "sqlite":
-		return dbName // SQLite只需要文件路径
+		// SQLite只需要文件路径；事务开始即获取写锁并等待其他连接，避免并发写入时直接报 database is locked
+		if dbName == "" || strings.Contains(dbName, "?") {
+			return dbName
+		}
+		return dbName + "?_busy_timeout=5000&_txlock=immediate"
 	default:
 		return "unsupported db source"
 	}
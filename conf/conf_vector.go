@@ -8,6 +8,8 @@ type Vector struct {
 	Qdrant              Qdrant  `yaml:"qdrant"`
 	TopK                int     `yaml:"topK"`
 	SimilarityThreshold float64 `yaml:"similarityThreshold"`
+	Dimension           int     `yaml:"dimension"` // 向量维度，为 0 时按 embedding 模型推断，未知模型实际请求一次探测
+	Keyword             bool    `yaml:"keyword"`   // 同时按关键词（BM25）检索问题和回答，与向量结果按排名融合
 }
 
 type Qdrant struct {
@@ -15,4 +17,4 @@ type Qdrant struct {
 	Port       int    `yaml:"port"`
 	Collection string `yaml:"collection"`
 	ApiKey     string `yaml:"apiKey"`
-}
\ No newline at end of file
+}
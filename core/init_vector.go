@@ -13,15 +13,15 @@ func InitVector() error {
 	if global.Config.Vector.Enable == false {
 		return nil
 	}
+	// 初始化 embedding 服务，创建集合时需要用它确定向量维度
+	embedding_service.InitEmbeddingService()
+
 	// 初始化向量数据库服务
 	err := vector_service.InitVectorService()
 	if err != nil {
 		return fmt.Errorf("初始化向量数据库失败: %v", err)
 	}
 
-	// 初始化 embedding 服务
-	embedding_service.InitEmbeddingService()
-
 	fmt.Println("向量服务初始化完成")
 	return nil
 }
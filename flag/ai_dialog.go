@@ -16,3 +16,30 @@ var DialogFlag = []cli.Flag{
 		Usage:   "List of all dialogs",
 	},
 }
+
+var ContextFlag = []cli.Flag{
+	&cli.StringFlag{
+		Name:     "text",
+		Aliases:  []string{"t"},
+		Usage:    "Question to build context for",
+		Required: true,
+	},
+	&cli.Int64Flag{
+		Name:    "session",
+		Aliases: []string{"s"},
+		Usage:   "Session ID, defaults to the most recent session",
+	},
+	&cli.Int64Flag{
+		Name:    "parent",
+		Aliases: []string{"p"},
+		Usage:   "Parent conversation ID to continue from",
+	},
+	&cli.StringFlag{
+		Name:  "provider",
+		Usage: "Provider name, decides the token budget",
+	},
+	&cli.StringFlag{
+		Name:  "model",
+		Usage: "Model override, decides the token budget",
+	},
+}
@@ -0,0 +1,30 @@
+// Path: ./flag/template.go
+
+package flag
+
+import "github.com/urfave/cli/v3"
+
+var TemplateRunFlag = []cli.Flag{
+	&cli.StringSliceFlag{
+		Name:  "var",
+		Usage: "Template variable as key=value, repeatable",
+	},
+	&cli.Int64Flag{
+		Name:    "session",
+		Aliases: []string{"s"},
+		Usage:   "Session ID, defaults to the most recent session",
+	},
+	&cli.Int64Flag{
+		Name:    "parent",
+		Aliases: []string{"p"},
+		Usage:   "Parent conversation ID to continue from",
+	},
+	&cli.StringFlag{
+		Name:  "provider",
+		Usage: "Provider name, defaults to the session persona or the default provider",
+	},
+	&cli.StringFlag{
+		Name:  "model",
+		Usage: "Model override",
+	},
+}
@@ -15,6 +15,11 @@ func FlagDB() {
 		&models.DialogModel{},
 		&models.ConversationModel{},
 		&models.ImageModel{},
+		&models.PersonaModel{},
+		&models.PromptTemplateModel{},
+		&models.ConversationParentModel{},
+		&models.VectorPointModel{},
+		&models.MigrationModel{},
 	)
 	if err != nil {
 		logrus.Errorf("failed to migrate DB: %s\n", err)
@@ -2,6 +2,8 @@
 
 package models
 
+import "time"
+
 type ConversationModel struct {
 	Model
 	Prompt    string `json:"prompt"`
@@ -9,9 +11,24 @@ type ConversationModel struct {
 	SessionID int64  `gorm:"index" json:"sessionID"`
 	DialogID  int64  `gorm:"index" json:"dialogID"`
 	IsStarred bool   `gorm:"default:false" json:"isStarred"` // 标星
+	IsPinned  bool   `gorm:"default:false" json:"isPinned"`  // 固定到会话上下文，每次提问都会带上
 	Comment   string `json:"comment"`                        // 评论
 	Title     string `gorm:"size:64" json:"title"`           // ai 归纳，给用户看
 	Summary   string `json:"summary"`                        // ai 归纳，维护上下文
+	Provider  string `gorm:"size:32" json:"provider"`        // 回答所用的提供商
+	ModelName string `gorm:"size:64" json:"model"`           // 回答所用的模型
+
+	ParentConversationID *int64 `gorm:"index" json:"parentConversationId"`     // 父对话，会话根部的对话为空；旧数据由 backfill-parents 命令回填
+	RegeneratedFromID    *int64 `gorm:"index" json:"regeneratedFromId"`        // 重新生成时指向最初的那条对话，同一问题的各个回答互为备选
+	IsArchived           bool   `gorm:"default:false;index" json:"isArchived"` // 归档后在对话树中隐藏，也不参与向量召回
+
+	SummaryAttemptAt *time.Time `gorm:"index" json:"-"` // 最近一次补全标题和摘要的时间，后台补全跳过近期尝试过的对话
+
+	// 用量统计，上游不返回 usage 时为本地估算值
+	PromptTokens     int     `json:"promptTokens"`
+	CompletionTokens int     `json:"completionTokens"`
+	Cost             float64 `json:"cost"`                                // 按配置的模型单价计算
+	UsageEstimated   bool    `gorm:"default:false" json:"usageEstimated"` // 用量是否为本地估算
 
 	// fk
 	SessionModel SessionModel `gorm:"foreignKey:SessionID;references:ID;constraint:OnDelete:CASCADE" json:"-"`
@@ -0,0 +1,17 @@
+// Path: ./models/conversation_parent_model.go
+
+package models
+
+// ConversationParentModel 对话的父对话，合并分支产生的对话有多个父对话，对话树因此成为有向无环图
+// 这张表只记录合并对话的父对话（两个父对话都写入，第一个同时记在 ConversationModel.ParentConversationID）
+// 普通对话只有一个父对话，记在 ConversationModel.ParentConversationID，不写入这张表
+type ConversationParentModel struct {
+	Model
+	SessionID            int64 `gorm:"index" json:"sessionID"`
+	ConversationID       int64 `gorm:"uniqueIndex:idx_uniq_conversation_parent" json:"conversationID"`
+	ParentConversationID int64 `gorm:"uniqueIndex:idx_uniq_conversation_parent;index" json:"parentConversationID"`
+
+	// fk
+	ConversationModel       ConversationModel `gorm:"foreignKey:ConversationID;references:ID;constraint:OnDelete:CASCADE" json:"-"`
+	ParentConversationModel ConversationModel `gorm:"foreignKey:ParentConversationID;references:ID;constraint:OnDelete:CASCADE" json:"-"`
+}
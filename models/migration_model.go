@@ -0,0 +1,12 @@
+// Path: ./models/migration_model.go
+
+package models
+
+import "time"
+
+// MigrationModel 已完成的数据迁移，Name 为迁移名称
+// 用于判断旧数据是否已经处理过，例如 backfill-parents 回填父对话之后不再推断父对话
+type MigrationModel struct {
+	Name      string    `gorm:"primaryKey;size:64" json:"name"`
+	CreatedAt time.Time `json:"createdAt"`
+}
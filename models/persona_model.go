@@ -0,0 +1,16 @@
+// Path: ./models/persona_model.go
+
+package models
+
+// PersonaModel 角色设定，会话引用后作为 system prompt 中的角色说明
+type PersonaModel struct {
+	Model
+	Name         string   `gorm:"not null;uniqueIndex:idx_uniq_persona_name;size:32" json:"name"`
+	SystemPrompt string   `gorm:"type:text" json:"systemPrompt"`
+	Provider     string   `gorm:"size:32" json:"provider"` // 默认提供商，为空使用全局默认
+	ModelName    string   `gorm:"size:64" json:"model"`    // 默认模型，为空使用提供商配置
+	Temperature  *float64 `json:"temperature"`             // 默认采样温度，为空使用模型默认
+
+	// FK
+	Sessions []SessionModel `gorm:"foreignKey:PersonaID;references:ID" json:"-"`
+}
@@ -8,8 +8,10 @@ type SessionModel struct {
 	Summary      string `gorm:"size:256" json:"summary"`
 	CategoryID   int64  `json:"categoryID"`
 	RootDialogID *int64 `json:"rootDialogID"`
+	PersonaID    *int64 `json:"personaID"` // 使用的角色，为空时使用默认的对话助手
 
 	// fk
 	RootDialogModel *DialogModel   `gorm:"foreignKey:RootDialogID;references:ID" json:"-"`
 	CategoryModel   *CategoryModel `gorm:"foreignKey:CategoryID;references:ID" json:"-"`
+	PersonaModel    *PersonaModel  `gorm:"foreignKey:PersonaID;references:ID" json:"-"`
 }
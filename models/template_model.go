@@ -0,0 +1,12 @@
+// Path: ./models/template_model.go
+
+package models
+
+// PromptTemplateModel 提示词模板，内容中的 {{变量}} 在调用时替换
+// 多个步骤在同一对话分支中依次执行，{{previous}} 替换为上一步的回答
+type PromptTemplateModel struct {
+	Model
+	Name        string   `gorm:"not null;uniqueIndex:idx_uniq_template_name;size:32" json:"name"`
+	Description string   `gorm:"size:256" json:"description"`
+	Steps       []string `gorm:"serializer:json;type:text" json:"steps"`
+}
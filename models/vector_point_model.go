@@ -0,0 +1,14 @@
+// Path: ./models/vector_point_model.go
+
+package models
+
+import "time"
+
+// VectorPointModel 本地向量库中的一个点，ID 与 Qdrant 中的点ID一致，即对话ID
+type VectorPointModel struct {
+	ID        uint64                 `gorm:"primaryKey;autoIncrement:false" json:"id"`
+	CreatedAt time.Time              `json:"createdAt"`
+	UpdatedAt time.Time              `json:"updatedAt"`
+	Vector    []byte                 `json:"-"` // float32 按小端序依次存放
+	Payload   map[string]interface{} `gorm:"serializer:json" json:"payload"`
+}
@@ -57,6 +57,13 @@ var DialogCommand = &cli.Command{
 			Flags:   flag.DialogFlag,
 			Action:  ai_cli.EnterRecent,
 		},
+		{
+			Name:    "context",
+			Aliases: []string{"ctx"},
+			Usage:   "Preview the context that would be sent for a question",
+			Flags:   flag.ContextFlag,
+			Action:  ai_cli.PreviewContext,
+		},
 	},
 	Action: func(ctx context.Context, c *cli.Command) error {
 		cres.Debug("=== 进入 dialog 模式 ===")
@@ -67,3 +74,25 @@ var DialogCommand = &cli.Command{
 		return cli.ShowSubcommandHelp(c)
 	},
 }
+
+var TemplateCommand = &cli.Command{
+	Name:    "template",
+	Aliases: []string{"tpl"},
+	Usage:   "Run stored prompt templates",
+	Commands: []*cli.Command{
+		{
+			Name:    "list",
+			Aliases: []string{"l", "ls"},
+			Usage:   "Show all the templates and their variables",
+			Action:  ai_cli.ListTemplates,
+		},
+		{
+			Name:      "run",
+			Aliases:   []string{"r"},
+			Usage:     "Run a template step by step in one dialog branch",
+			ArgsUsage: "<name>",
+			Flags:     flag.TemplateRunFlag,
+			Action:    ai_cli.RunTemplate,
+		},
+	},
+}
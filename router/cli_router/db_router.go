@@ -8,6 +8,8 @@ import (
 	"dialogTree/global"
 	"dialogTree/middleware"
 	"dialogTree/service/db_service"
+	"dialogTree/service/dialog_service"
+	"fmt"
 	"github.com/urfave/cli/v3"
 )
 
@@ -22,6 +24,50 @@ var MigrateDBCommand = &cli.Command{
 	},
 }
 
+var BackfillParentsCommand = &cli.Command{
+	Name:  "backfill-parents",
+	Usage: "Migrate database and backfill conversation parent pointers inferred from the dialog tree",
+	Action: func(ctx context.Context, c *cli.Command) error {
+		core.Init()
+		db_service.MigrateDB()
+		_, err := dialog_service.BackfillParentConversations()
+		return err
+	},
+}
+
+var DoctorCommand = &cli.Command{
+	Name:  "doctor",
+	Usage: "Check dialog trees and vectors for corruption",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "fix",
+			Usage: "Repair the problems that can be fixed automatically",
+		},
+	},
+	Action: func(ctx context.Context, c *cli.Command) error {
+		core.InitWithVector()
+		report, err := dialog_service.CheckTrees(c.Bool("fix"))
+		if err != nil {
+			return err
+		}
+
+		fixed := 0
+		for _, issue := range report.Issues {
+			status := "[未修复]"
+			if issue.Fixed {
+				status = "[已修复]"
+				fixed++
+			}
+			fmt.Printf("%s %-14s %s\n", status, issue.Kind, issue.Message)
+		}
+		fmt.Printf("检查了 %d 个会话，发现 %d 个问题，修复 %d 个\n", report.Sessions, len(report.Issues), fixed)
+		if fixed < len(report.Issues) && !c.Bool("fix") {
+			fmt.Println("使用 --fix 修复")
+		}
+		return nil
+	},
+}
+
 var ResetDBCommand = &cli.Command{
 	Name:    "reset",
 	Aliases: []string{"r", "init"},
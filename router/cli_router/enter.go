@@ -23,7 +23,11 @@ var App = &cli.Command{
 	Commands: []*cli.Command{
 		ChitchatCommand,
 		DialogCommand,
+		TemplateCommand,
 		MigrateDBCommand,
+		BackfillParentsCommand,
+		DoctorCommand,
+		VectorCommand,
 		WebUICommand,
 		ResetDBCommand,
 		NukeDBCommand,
@@ -0,0 +1,80 @@
+// Path: ./router/cli_router/vector_router.go
+
+package cli_router
+
+import (
+	"context"
+	"dialogTree/core"
+	"dialogTree/global"
+	"dialogTree/service/dialog_service"
+	"dialogTree/service/vector_service"
+	"fmt"
+
+	"github.com/urfave/cli/v3"
+)
+
+var VectorCommand = &cli.Command{
+	Name:  "vector",
+	Usage: "Manage the vector index",
+	Commands: []*cli.Command{
+		{
+			Name:  "reindex",
+			Usage: "Re-embed every conversation into a new collection and switch to it",
+			Flags: []cli.Flag{
+				&cli.IntFlag{
+					Name:  "batch",
+					Value: 50,
+					Usage: "Conversations embedded and written per batch",
+				},
+				&cli.BoolFlag{
+					Name:  "restart",
+					Usage: "Discard the saved progress and start over",
+				},
+				&cli.StringFlag{
+					Name:  "state",
+					Value: dialog_service.DefaultReindexStateFile,
+					Usage: "Progress file used to resume an interrupted reindex",
+				},
+			},
+			Action: func(ctx context.Context, c *cli.Command) error {
+				core.InitWithVector()
+				if !global.Config.Vector.Enable {
+					return fmt.Errorf("向量服务未启用")
+				}
+				if _, ok := vector_service.VectorServiceInstance.(vector_service.AliasedVectorService); ok {
+					fmt.Printf("注意：%s 还是集合而不是别名时（首次重建），切换时需要先删除它再创建同名别名，期间检索和保存向量会失败；"+
+						"切换失败时请不带 --restart 再次执行以完成切换\n", global.Config.Vector.Qdrant.Collection)
+				}
+				if _, ok := vector_service.VectorServiceInstance.(vector_service.ResizableVectorService); ok {
+					fmt.Println("注意：向量维度变化时会先清空已有向量，重建完成前检索不到尚未处理的对话")
+				}
+
+				state, err := dialog_service.ReindexVectors(dialog_service.ReindexOptions{
+					StateFile: c.String("state"),
+					BatchSize: int(c.Int("batch")),
+					Restart:   c.Bool("restart"),
+					Progress: func(s dialog_service.ReindexState) {
+						fmt.Printf("\r已处理 %d/%d（对话ID %d）", s.Done, s.Total, s.LastID)
+					},
+				})
+				fmt.Println()
+				if err != nil {
+					return fmt.Errorf("重建中断，再次执行将从对话 %d 之后继续: %v", lastID(state), err)
+				}
+				if state.Collection != "" {
+					fmt.Printf("重建完成，%s 已切换到 %s（%d 维）\n", global.Config.Vector.Qdrant.Collection, state.Collection, state.Dimension)
+				} else {
+					fmt.Printf("重建完成，共 %d 条对话（%d 维）\n", state.Done, state.Dimension)
+				}
+				return nil
+			},
+		},
+	},
+}
+
+func lastID(state *dialog_service.ReindexState) int64 {
+	if state == nil {
+		return 0
+	}
+	return state.LastID
+}
@@ -12,27 +12,43 @@ func AiRouter(rg *gin.RouterGroup) {
 	sessionApi := api.App.SessionApi
 	dialogApi := api.App.DialogApi
 	categoryApi := api.App.CategoryApi
+	statsApi := api.App.StatsApi
+	personaApi := api.App.PersonaApi
+	templateApi := api.App.TemplateApi
 
 	// 会话管理相关路由
 	sessionGroup := rg.Group("/sessions")
 	{
-		sessionGroup.GET("", sessionApi.GetSessionList)                                         // 获取会话列表
-		sessionGroup.POST("", middleware.DemoMiddleware, sessionApi.CreateSession)              // 创建新会话
-		sessionGroup.GET("/:sessionId/tree", sessionApi.GetSessionTree)                         // 获取会话对话树
-		sessionGroup.PUT("/:sessionId", middleware.DemoMiddleware, sessionApi.UpdateSession)    // 更新会话信息
-		sessionGroup.DELETE("/:sessionId", middleware.DemoMiddleware, sessionApi.DeleteSession) // 删除会话
+		sessionGroup.GET("", sessionApi.GetSessionList)                                                // 获取会话列表
+		sessionGroup.POST("", middleware.DemoMiddleware, sessionApi.CreateSession)                     // 创建新会话
+		sessionGroup.GET("/:sessionId/tree", sessionApi.GetSessionTree)                                // 获取会话对话树
+		sessionGroup.GET("/:sessionId/pins", sessionApi.GetSessionPins)                                // 获取会话中固定的对话
+		sessionGroup.PUT("/:sessionId", middleware.DemoMiddleware, sessionApi.UpdateSession)           // 更新会话信息
+		sessionGroup.DELETE("/:sessionId", middleware.DemoMiddleware, sessionApi.DeleteSession)        // 删除会话
+		sessionGroup.POST("/:sessionId/retitle", middleware.DemoMiddleware, sessionApi.RetitleSession) // 重新生成标题
 	}
 
 	// 对话相关路由
 	dialogGroup := rg.Group("/dialog")
 	{
-		dialogGroup.POST("/chat", middleware.DemoMiddleware, dialogApi.NewChat)                                              // 发起新对话（流式）
-		dialogGroup.POST("/chat/sync", middleware.DemoMiddleware, dialogApi.NewChatSync)                                     // 发起新对话（同步）
-		dialogGroup.GET("/conversations/:conversationId/ancestors", dialogApi.GetAncestors)                                  // 获取祖先对话
-		dialogGroup.PUT("/conversations/:conversationId/star", middleware.DemoMiddleware, dialogApi.StarConversation)        // 标星/取消标星
-		dialogGroup.PUT("/conversations/comment", middleware.DemoMiddleware, dialogApi.UpdateConversationComment)            // 更新评论
-		dialogGroup.PUT("/conversations/title", middleware.DemoMiddleware, dialogApi.UpdateConversationTitle)                // 更新标题
-		dialogGroup.DELETE("/conversations/:conversationId", middleware.DemoMiddleware, dialogApi.DeleteConversationComment) // 删除评论
+		dialogGroup.POST("/chat", middleware.DemoMiddleware, dialogApi.NewChat)                                                      // 发起新对话（流式）
+		dialogGroup.POST("/chat/sync", middleware.DemoMiddleware, dialogApi.NewChatSync)                                             // 发起新对话（同步）
+		dialogGroup.POST("/chat/:streamId/stop", dialogApi.StopChat)                                                                 // 停止生成中的回答
+		dialogGroup.POST("/context/preview", dialogApi.PreviewContext)                                                               // 预览上下文
+		dialogGroup.GET("/conversations/:conversationId/ancestors", dialogApi.GetAncestors)                                          // 获取祖先对话
+		dialogGroup.PUT("/conversations/:conversationId/star", middleware.DemoMiddleware, dialogApi.StarConversation)                // 标星/取消标星
+		dialogGroup.PUT("/conversations/:conversationId/pin", middleware.DemoMiddleware, dialogApi.PinConversation)                  // 固定/取消固定到上下文
+		dialogGroup.POST("/conversations/:conversationId/regenerate", middleware.DemoMiddleware, dialogApi.RegenerateConversation)   // 重新生成回答（兄弟分支）
+		dialogGroup.POST("/conversations/:conversationId/edit-and-resend", middleware.DemoMiddleware, dialogApi.EditAndResend)       // 修改问题并从其父对话分叉
+		dialogGroup.POST("/merge", middleware.DemoMiddleware, dialogApi.MergeConversations)                                          // 合并两个分支末端
+		dialogGroup.POST("/dialogs/:dialogId/move", middleware.DemoMiddleware, dialogApi.MoveSubtree)                                // 移动子树到其他对话之后
+		dialogGroup.POST("/dialogs/:dialogId/copy", middleware.DemoMiddleware, dialogApi.CopySubtree)                                // 复制子树到其他对话之后
+		dialogGroup.PUT("/conversations/comment", middleware.DemoMiddleware, dialogApi.UpdateConversationComment)                    // 更新评论
+		dialogGroup.PUT("/conversations/title", middleware.DemoMiddleware, dialogApi.UpdateConversationTitle)                        // 更新标题
+		dialogGroup.DELETE("/conversations/:conversationId", middleware.DemoMiddleware, dialogApi.DeleteConversationComment)         // 删除评论
+		dialogGroup.PUT("/conversations/:conversationId/archive", middleware.DemoMiddleware, dialogApi.ArchiveConversation)          // 归档/恢复分支
+		dialogGroup.DELETE("/conversations/:conversationId/node", middleware.DemoMiddleware, dialogApi.DeleteConversation)           // 删除单条对话，子分支接到其父对话
+		dialogGroup.DELETE("/conversations/:conversationId/subtree", middleware.DemoMiddleware, dialogApi.DeleteConversationSubtree) // 删除对话及其后续分支
 	}
 
 	categoryGroup := rg.Group("/categories")
@@ -43,4 +59,27 @@ func AiRouter(rg *gin.RouterGroup) {
 		categoryGroup.DELETE("/:categoryId", middleware.DemoMiddleware, categoryApi.DeleteCategory) // 删除分类
 		categoryGroup.GET("/:categoryId/sessions", sessionApi.GetSessionsByCategory)                // 获取分类下的所有会话
 	}
+
+	personaGroup := rg.Group("/personas")
+	{
+		personaGroup.GET("", personaApi.GetPersonaList)                                         // 角色列表
+		personaGroup.GET("/:personaId", personaApi.GetPersona)                                  // 角色详情
+		personaGroup.POST("", middleware.DemoMiddleware, personaApi.CreatePersona)              // 创建角色
+		personaGroup.PUT("/:personaId", middleware.DemoMiddleware, personaApi.UpdatePersona)    // 修改角色
+		personaGroup.DELETE("/:personaId", middleware.DemoMiddleware, personaApi.DeletePersona) // 删除角色
+	}
+
+	templateGroup := rg.Group("/templates")
+	{
+		templateGroup.GET("", templateApi.GetTemplateList)                                          // 模板列表
+		templateGroup.GET("/:templateId", templateApi.GetTemplate)                                  // 模板详情
+		templateGroup.POST("", middleware.DemoMiddleware, templateApi.CreateTemplate)               // 创建模板
+		templateGroup.PUT("/:templateId", middleware.DemoMiddleware, templateApi.UpdateTemplate)    // 修改模板
+		templateGroup.DELETE("/:templateId", middleware.DemoMiddleware, templateApi.DeleteTemplate) // 删除模板
+	}
+
+	statsGroup := rg.Group("/stats")
+	{
+		statsGroup.GET("/usage", statsApi.GetUsageStats) // 用量与费用统计
+	}
 }
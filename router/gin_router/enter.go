@@ -6,6 +6,7 @@ import (
 	"dialogTree/core"
 	"dialogTree/global"
 	"dialogTree/middleware"
+	"dialogTree/service/dialog_service"
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
 	"os"
@@ -14,6 +15,7 @@ import (
 
 func Run() {
 	core.InitWithVector()
+	dialog_service.StartSummaryWorker()
 	gin.SetMode(global.Config.System.GinMode) // 设置 gin 模式，对应 settings.yaml 中的 gin_mode
 
 	router := gin.Default()
@@ -40,6 +42,7 @@ func Run() {
 
 func RunWithWeb() {
 	core.InitWithVector()
+	dialog_service.StartSummaryWorker()
 	gin.SetMode(global.Config.System.GinMode)
 
 	router := gin.Default()
@@ -2,10 +2,7 @@
 
 package chat_anywhere
 
-import (
-	"dialogTree/global"
-	"dialogTree/service/ai_service/common"
-)
+import "dialogTree/service/ai_service/common"
 
 const baseURL = "https://api.chatanywhere.tech/v1/chat/completions"
 
@@ -21,11 +18,9 @@ const (
 	CA_CLA_S4      ModelType = "claude-sonnet-4-20250514"
 )
 
-// getConfig 获取ChatAnywhere配置
-func getConfig() common.AIProviderConfig {
-	return common.AIProviderConfig{
-		BaseURL: baseURL,
-		APIKey:  global.Config.Ai.ChatAnywhere.SecretKey,
-		Model:   global.Config.Ai.ChatAnywhere.Model,
-	}
+// ProviderType config.yaml 中对应的 type
+const ProviderType = "chatanywhere"
+
+func init() {
+	common.RegisterProviderType(ProviderType, common.CompatibleFactory(baseURL))
 }
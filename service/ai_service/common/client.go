@@ -3,6 +3,7 @@
 package common
 
 import (
+	"context"
 	"dialogTree/service/ai_service/prompts"
 	"encoding/json"
 	"github.com/sirupsen/logrus"
@@ -12,9 +13,17 @@ import (
 
 // UniversalChatRequest 通用的聊天请求结构
 type UniversalChatRequest struct {
-	Model    string    `json:"model"`
-	Messages []Message `json:"messages"`
-	Stream   bool      `json:"stream"`
+	Model         string         `json:"model"`
+	Messages      []Message      `json:"messages"`
+	Stream        bool           `json:"stream"`
+	StreamOptions *StreamOptions `json:"stream_options,omitempty"`
+	Temperature   *float64       `json:"temperature,omitempty"`
+	MaxTokens     *int           `json:"max_tokens,omitempty"`
+}
+
+// StreamOptions 流式请求选项，include_usage 让上游在最后一个数据块返回用量
+type StreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
 }
 
 // Message 消息结构
@@ -25,36 +34,83 @@ type Message struct {
 
 // AIProviderConfig AI提供商配置
 type AIProviderConfig struct {
-	BaseURL   string
-	APIKey    string
-	Model     string
+	BaseURL      string
+	APIKey       string
+	Model        string
+	DisableUsage bool // 上游不支持 stream_options 时关闭，改为本地估算用量
 }
 
-// MakeRequest 通用的HTTP请求函数
-func MakeRequest(config AIProviderConfig, msg string, summarize bool) (res *http.Response, err error) {
-	method := "POST"
+// ChatOptions 单次请求的可选参数，零值表示使用提供商配置
+type ChatOptions struct {
+	Model       string   // 覆盖配置中的模型
+	Temperature *float64 // 采样温度
+	MaxTokens   *int     // 最大生成 token 数
+	Usage       *Usage   // 非空时，本次请求的用量在返回的通道全部关闭前累加到这里
+	Persona     string   // 角色说明，非空时替代默认的对话助手设定
+	Instruction string   // 非空时替代上下文结构说明，用于上下文结构不同的请求（如分支合并）
+}
+
+// ModelFor 返回本次请求实际使用的模型
+func (o ChatOptions) ModelFor(config AIProviderConfig) string {
+	if o.Model != "" {
+		return o.Model
+	}
+	return config.Model
+}
+
+// chatSystemPrompt 选择聊天请求的 system prompt，summarize 时要求回答末尾附带摘要
+// 指定角色或说明时，由角色说明、JSON 上下文结构说明和摘要格式要求组合而成
+func chatSystemPrompt(summarize bool, opts ChatOptions) string {
+	persona := strings.TrimSpace(opts.Persona)
+	if persona == "" && opts.Instruction == "" {
+		if summarize {
+			return prompts.SummarizePrompt
+		}
+		return prompts.ChatPrompt
+	}
 
-	// 选择prompt
-	var prompt = prompts.ChatPrompt
+	var parts []string
+	if persona != "" {
+		parts = append(parts, persona)
+	}
+	if opts.Instruction != "" {
+		parts = append(parts, opts.Instruction)
+	} else {
+		parts = append(parts, prompts.ContextPrompt)
+	}
 	if summarize {
-		prompt = prompts.SummarizePrompt
+		parts = append(parts, prompts.SummaryFormatPrompt)
 	}
+	return strings.Join(parts, "\n\n")
+}
 
-	// 构建请求体
-	requestBody := UniversalChatRequest{
-		Model: config.Model,
+// NewChatRequest 构建一次 system + user 的请求体
+func NewChatRequest(config AIProviderConfig, systemPrompt, msg string, stream bool, opts ChatOptions) UniversalChatRequest {
+	body := UniversalChatRequest{
+		Model: opts.ModelFor(config),
 		Messages: []Message{
 			{
 				Role:    "system",
-				Content: prompt,
+				Content: systemPrompt,
 			},
 			{
 				Role:    "user",
 				Content: msg,
 			},
 		},
-		Stream: true,
+		Stream:      stream,
+		Temperature: opts.Temperature,
+		MaxTokens:   opts.MaxTokens,
 	}
+	if stream && !config.DisableUsage {
+		body.StreamOptions = &StreamOptions{IncludeUsage: true}
+	}
+	return body
+}
+
+// MakeRequest 通用的HTTP请求函数
+func MakeRequest(ctx context.Context, config AIProviderConfig, requestBody UniversalChatRequest) (res *http.Response, err error) {
+	method := "POST"
 
 	// 序列化请求体
 	bd, err := json.Marshal(requestBody)
@@ -64,18 +120,20 @@ func MakeRequest(config AIProviderConfig, msg string, summarize bool) (res *http
 	}
 	payload := strings.NewReader(string(bd))
 
-	// 创建HTTP请求
-	req, err := http.NewRequest(method, config.BaseURL, payload)
+	// 创建HTTP请求，ctx 取消时中断上游连接
+	req, err := http.NewRequestWithContext(ctx, method, config.BaseURL, payload)
 	if err != nil {
 		logrus.Errorf("请求解析失败 %s", err)
 		return
 	}
 
 	// 设置请求头
-	req.Header.Add("Authorization", "Bearer "+config.APIKey)
+	if config.APIKey != "" {
+		req.Header.Add("Authorization", "Bearer "+config.APIKey)
+	}
 	req.Header.Add("Content-Type", "application/json")
 
 	// 发送请求
 	res, err = http.DefaultClient.Do(req)
 	return
-}
\ No newline at end of file
+}
@@ -0,0 +1,81 @@
+// Path: ./service/ai_service/common/mock.go
+
+package common
+
+import (
+	"context"
+	"github.com/sirupsen/logrus"
+)
+
+// MockProviderName 未配置任何提供商时使用的模拟提供商
+const MockProviderName = "mock"
+
+// mockAnswer 模拟的AI回答
+const mockAnswer = "这是一个模拟的AI回答，用于测试分叉功能。"
+
+// mockSummary 模拟的标题和摘要
+var mockSummary = SummaryResult{Title: "测试标题", Summary: "这是一个测试摘要"}
+
+// MockProvider 返回固定内容的模拟提供商，用于测试和未配置密钥的环境
+type MockProvider struct{}
+
+func (MockProvider) Name() string {
+	return MockProviderName
+}
+
+func (MockProvider) Model() string {
+	return MockProviderName
+}
+
+func (MockProvider) ChatStream(ctx context.Context, msg string, opts ChatOptions) (msgChan chan string, err error) {
+	logrus.Info("AI密钥为空，返回模拟响应用于测试")
+
+	msgChan = make(chan string)
+	usage := newUsageTracker(opts.Usage, []Message{{Role: "user", Content: msg}})
+	go func() {
+		defer close(msgChan)
+		defer usage.finish()
+		for _, char := range mockAnswer {
+			if !sendContent(ctx, msgChan, string(char)) {
+				return
+			}
+			usage.write(string(char))
+		}
+	}()
+	return
+}
+
+func (MockProvider) ChatStreamSum(ctx context.Context, msg string, opts ChatOptions) (msgChan, sumChan chan string, err error) {
+	logrus.Info("AI密钥为空，返回模拟响应用于测试")
+
+	msgChan = make(chan string)
+	sumChan = make(chan string)
+
+	usage := newUsageTracker(opts.Usage, []Message{{Role: "user", Content: msg}})
+
+	// 启动goroutine发送模拟响应
+	go func() {
+		defer close(sumChan)
+		defer usage.finish()
+
+		// 模拟AI回答
+		for _, char := range mockAnswer {
+			if !sendContent(ctx, msgChan, string(char)) {
+				close(msgChan)
+				return
+			}
+			usage.write(string(char))
+		}
+
+		// 关闭msgChan，模拟消息结束
+		close(msgChan)
+
+		// 模拟摘要
+		sendContent(ctx, sumChan, mockSummary.String())
+	}()
+	return
+}
+
+func (MockProvider) Summarize(ctx context.Context, question, answer string, opts ChatOptions) (SummaryResult, error) {
+	return mockSummary, nil
+}
@@ -0,0 +1,112 @@
+// Path: ./service/ai_service/common/provider.go
+
+package common
+
+import (
+	"context"
+	"dialogTree/conf"
+	"fmt"
+	"sync"
+)
+
+// ChatProvider 聊天提供商接口，所有 AI 后端都通过它接入
+type ChatProvider interface {
+	// Name 配置中的提供商名称
+	Name() string
+	// Model 配置中的默认模型
+	Model() string
+	// ChatStream 流式聊天
+	// ctx 取消时中断上游请求并关闭返回的通道
+	ChatStream(ctx context.Context, msg string, opts ChatOptions) (msgChan chan string, err error)
+	// ChatStreamSum 流式聊天 + 摘要
+	ChatStreamSum(ctx context.Context, msg string, opts ChatOptions) (msgChan, sumChan chan string, err error)
+	// Summarize 非流式请求，为一轮问答生成标题和摘要
+	Summarize(ctx context.Context, question, answer string, opts ChatOptions) (SummaryResult, error)
+}
+
+// ProviderFactory 根据配置构建提供商实例
+type ProviderFactory func(cfg conf.Provider) (ChatProvider, error)
+
+// DefaultProviderType 未指定 type 时使用的实现类型
+const DefaultProviderType = "compatible"
+
+var (
+	factoriesMu sync.RWMutex
+	factories   = map[string]ProviderFactory{}
+)
+
+// RegisterProviderType 注册一种提供商实现，供 config.yaml 中的 type 字段引用
+// 一般在实现包的 init 中调用，新增提供商无需改动 ai_service
+func RegisterProviderType(typ string, factory ProviderFactory) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+	factories[typ] = factory
+}
+
+// NewProvider 按配置的 type 构建提供商
+func NewProvider(cfg conf.Provider) (ChatProvider, error) {
+	typ := cfg.Type
+	if typ == "" {
+		typ = DefaultProviderType
+	}
+
+	factoriesMu.RLock()
+	factory, ok := factories[typ]
+	factoriesMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("未知的提供商类型: %s", typ)
+	}
+	return factory(cfg)
+}
+
+func init() {
+	RegisterProviderType(DefaultProviderType, CompatibleFactory(""))
+}
+
+// CompatibleProvider 兼容 OpenAI chat/completions 协议的提供商
+type CompatibleProvider struct {
+	name   string
+	config AIProviderConfig
+}
+
+// CompatibleFactory 返回兼容协议提供商的工厂，defaultBaseURL 在配置未给出 baseURL 时使用
+func CompatibleFactory(defaultBaseURL string) ProviderFactory {
+	return func(cfg conf.Provider) (ChatProvider, error) {
+		baseURL := cfg.BaseURL
+		if baseURL == "" {
+			baseURL = defaultBaseURL
+		}
+		if baseURL == "" {
+			return nil, fmt.Errorf("提供商 %s 未配置 baseURL", cfg.Name)
+		}
+		return &CompatibleProvider{
+			name: cfg.Name,
+			config: AIProviderConfig{
+				BaseURL:      baseURL,
+				APIKey:       cfg.SecretKey,
+				Model:        cfg.Model,
+				DisableUsage: cfg.DisableUsage,
+			},
+		}, nil
+	}
+}
+
+func (p *CompatibleProvider) Name() string {
+	return p.name
+}
+
+func (p *CompatibleProvider) Model() string {
+	return p.config.Model
+}
+
+func (p *CompatibleProvider) ChatStream(ctx context.Context, msg string, opts ChatOptions) (msgChan chan string, err error) {
+	return CreateChatStream(ctx, p.config, msg, opts)
+}
+
+func (p *CompatibleProvider) ChatStreamSum(ctx context.Context, msg string, opts ChatOptions) (msgChan, sumChan chan string, err error) {
+	return CreateChatStreamWithSummary(ctx, p.config, msg, opts)
+}
+
+func (p *CompatibleProvider) Summarize(ctx context.Context, question, answer string, opts ChatOptions) (SummaryResult, error) {
+	return Summarize(ctx, p.config, question, answer, opts)
+}
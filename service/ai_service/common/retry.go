@@ -0,0 +1,93 @@
+// Path: ./service/ai_service/common/retry.go
+
+package common
+
+import (
+	"context"
+	"dialogTree/global"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"time"
+)
+
+// StatusError 提供商返回了非 200 状态码
+type StatusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	if e.StatusCode == http.StatusTooManyRequests {
+		return "请求过于频繁，请稍后重试"
+	}
+	return fmt.Sprintf("服务器响应错误 %d %s", e.StatusCode, e.Body)
+}
+
+// Retryable 429 和 5xx 值得重试或切换提供商
+func (e *StatusError) Retryable() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+// IsRetryable 判断错误是否可通过重试或切换提供商恢复
+// 网络错误和 429/5xx 可恢复，其余状态码（如 401、400）说明请求本身有问题，取消则无需再试
+func IsRetryable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var se *StatusError
+	if errors.As(err, &se) {
+		return se.Retryable()
+	}
+	return err != nil
+}
+
+// OpenChatStream 发送请求体并在 429/5xx 或网络错误时按指数退避重试
+// 成功时返回状态码为 200 的响应，调用方负责关闭 Body
+func OpenChatStream(ctx context.Context, config AIProviderConfig, body UniversalChatRequest) (res *http.Response, err error) {
+	return withRetry(ctx, config, body.Model, func() (*http.Response, error) {
+		return MakeRequest(ctx, config, body)
+	})
+}
+
+// withRetry 按重试配置反复调用 send，直到拿到 200 响应或遇到不可恢复的错误
+func withRetry(ctx context.Context, config AIProviderConfig, model string, send func() (*http.Response, error)) (res *http.Response, err error) {
+	retry := global.Config.Ai.Retry
+	attempts := retry.Attempts()
+
+	for i := 0; i < attempts; i++ {
+		if i > 0 {
+			delay := time.Duration(retry.Delay(i-1)) * time.Millisecond
+			logrus.Warnf("第 %d 次请求 %s 失败: %v，%v 后重试", i, config.BaseURL, err, delay)
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+
+		logrus.Debugf("请求 %s（模型 %s），第 %d/%d 次尝试", config.BaseURL, model, i+1, attempts)
+		res, err = send()
+		if err != nil {
+			if !IsRetryable(err) {
+				break
+			}
+			continue
+		}
+		if res.StatusCode == http.StatusOK {
+			return res, nil
+		}
+
+		body, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+		res.Body.Close()
+		err = &StatusError{StatusCode: res.StatusCode, Body: string(body)}
+		if !IsRetryable(err) {
+			break
+		}
+	}
+
+	logrus.Errorf("请求 %s 失败: %v", config.BaseURL, err)
+	return nil, err
+}
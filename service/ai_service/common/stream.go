@@ -4,9 +4,8 @@ package common
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
-	"errors"
-	"fmt"
 	"github.com/sirupsen/logrus"
 	"net/http"
 	"strings"
@@ -27,6 +26,7 @@ type UniversalChatResponse struct {
 	Created int    `json:"created"`
 	Model   string `json:"model"`
 	Object  string `json:"object"`
+	Usage   *Usage `json:"usage"`
 }
 
 // UniversalChatStreamResponse 通用流式聊天响应结构
@@ -45,11 +45,24 @@ type UniversalChatStreamResponse struct {
 	Created           int64  `json:"created"`
 	Model             string `json:"model"`
 	SystemFingerprint string `json:"system_fingerprint"`
+	Usage             *Usage `json:"usage"` // 开启 include_usage 时仅最后一个数据块携带
+}
+
+// sendContent 向 msgChan 发送内容，ctx 取消时放弃发送并返回 false
+func sendContent(ctx context.Context, ch chan string, content string) bool {
+	select {
+	case ch <- content:
+		return true
+	case <-ctx.Done():
+		return false
+	}
 }
 
 // StreamProcessor 通用流处理器
-func StreamProcessor(scanner *bufio.Scanner, res *http.Response, msgChan chan string) {
+// ctx 取消时停止读取，关闭响应体以中断上游请求
+func StreamProcessor(ctx context.Context, scanner *bufio.Scanner, res *http.Response, msgChan chan string, usage *usageTracker) {
 	defer close(msgChan)
+	defer usage.finish()
 	defer res.Body.Close()
 
 	for scanner.Scan() {
@@ -81,6 +94,7 @@ func StreamProcessor(scanner *bufio.Scanner, res *http.Response, msgChan chan st
 			continue
 		}
 
+		usage.observe(aiRes.Usage)
 		if len(aiRes.Choices) == 0 {
 			continue
 		}
@@ -90,15 +104,31 @@ func StreamProcessor(scanner *bufio.Scanner, res *http.Response, msgChan chan st
 			continue
 		}
 
-		msgChan <- content
+		usage.write(content)
+		if !sendContent(ctx, msgChan, content) {
+			logrus.Info("生成已取消，停止读取上游响应")
+			return
+		}
 	}
+	logScanError(ctx, scanner)
 }
 
 // StreamSplitter 通用流分割器（用于摘要功能）
-func StreamSplitter(scanner *bufio.Scanner, res *http.Response, msgChan, sumChan chan string) {
+// 无论正常结束、缺少摘要还是 ctx 取消，msgChan 和 sumChan 都会被关闭
+func StreamSplitter(ctx context.Context, scanner *bufio.Scanner, res *http.Response, msgChan, sumChan chan string, usage *usageTracker) {
 	defer close(sumChan)
+	defer usage.finish() // 用量在摘要之后才返回，须在 sumChan 关闭前写入
 	defer res.Body.Close()
 
+	msgClosed := false
+	closeMsg := func() {
+		if !msgClosed {
+			close(msgChan)
+			msgClosed = true
+		}
+	}
+	defer closeMsg()
+
 	var slidingBuffer strings.Builder // 缓冲所有 token
 	const marker = "^¥&"
 
@@ -123,22 +153,15 @@ func StreamSplitter(scanner *bufio.Scanner, res *http.Response, msgChan, sumChan
 		// 检查是否是结束标记
 		if jsonData == "[DONE]" {
 			wholeMsg := slidingBuffer.String()
+			logrus.Debugf("本次收到的完整消息：%s", wholeMsg)
 			msgs := strings.SplitN(wholeMsg, marker, 2)
+			closeMsg()
 
-			if len(msgs) == 2 {
-				summary := msgs[1]
-				sumChan <- summary // ✅ 会阻塞等待消费
-			} else {
+			if len(msgs) != 2 {
 				logrus.Warn("\n未能正确提取摘要")
-				close(msgChan)
 				return
 			}
-			logrus.Debugf("本次收到的完整消息：" + wholeMsg)
-
-			_, ok := <-msgChan
-			if ok {
-				close(msgChan)
-			}
+			sendContent(ctx, sumChan, msgs[1]) // ✅ 会阻塞等待消费
 			return
 		}
 
@@ -150,6 +173,7 @@ func StreamSplitter(scanner *bufio.Scanner, res *http.Response, msgChan, sumChan
 			continue
 		}
 
+		usage.observe(aiRes.Usage)
 		if len(aiRes.Choices) == 0 {
 			continue
 		}
@@ -161,7 +185,9 @@ func StreamSplitter(scanner *bufio.Scanner, res *http.Response, msgChan, sumChan
 
 		// 组装缓冲内容
 		slidingBuffer.WriteString(content)
+		usage.write(content)
 
+		var forward bool
 		switch state {
 		case 0:
 			if content == "^" || strings.HasSuffix(slidingBuffer.String(), "^") {
@@ -170,39 +196,45 @@ func StreamSplitter(scanner *bufio.Scanner, res *http.Response, msgChan, sumChan
 				state = 2
 			} else if content == marker || strings.Contains(slidingBuffer.String(), marker) {
 				state = 3
+				closeMsg()
 			} else {
-				msgChan <- content
+				forward = true
 			}
 		case 1:
 			if content == "¥" || strings.HasSuffix(slidingBuffer.String(), "^¥") {
 				state = 2
 			} else {
-				msgChan <- content
+				forward = true
 			}
 		case 2:
 			if content == "&" || strings.Contains(slidingBuffer.String(), marker) {
 				state = 3
-				close(msgChan)
+				closeMsg()
 			} else {
-				msgChan <- content
+				forward = true
 			}
 		}
+
+		if forward && !sendContent(ctx, msgChan, content) {
+			logrus.Info("生成已取消，停止读取上游响应")
+			return
+		}
 	}
+	logScanError(ctx, scanner)
 }
 
-// CreateChatStream 创建聊天流
-func CreateChatStream(config AIProviderConfig, msg string) (msgChan chan string, err error) {
-	res, err := MakeRequest(config, msg, false)
-	if err != nil {
-		return
+// logScanError 记录非取消导致的读取错误
+func logScanError(ctx context.Context, scanner *bufio.Scanner) {
+	if err := scanner.Err(); err != nil && ctx.Err() == nil {
+		logrus.Errorf("读取流式响应失败: %v", err)
 	}
+}
 
-	if res.StatusCode != 200 {
-		if res.StatusCode == 429 {
-			err = errors.New("请求过于频繁，请稍后重试")
-		} else {
-			err = errors.New(fmt.Sprintf("服务器响应错误 %d", res.StatusCode))
-		}
+// CreateChatStream 创建聊天流
+func CreateChatStream(ctx context.Context, config AIProviderConfig, msg string, opts ChatOptions) (msgChan chan string, err error) {
+	body := NewChatRequest(config, chatSystemPrompt(false, opts), msg, true, opts)
+	res, err := OpenChatStream(ctx, config, body)
+	if err != nil {
 		return
 	}
 
@@ -211,14 +243,27 @@ func CreateChatStream(config AIProviderConfig, msg string) (msgChan chan string,
 	scanner := bufio.NewScanner(res.Body)
 	scanner.Split(bufio.ScanLines)
 
-	go StreamProcessor(scanner, res, msgChan)
+	go StreamProcessor(ctx, scanner, res, msgChan, newUsageTracker(opts.Usage, body.Messages))
 
 	return
 }
 
 // CreateChatStreamWithSummary 创建带摘要的聊天流
-func CreateChatStreamWithSummary(config AIProviderConfig, msg string) (msgChan, sumChan chan string, err error) {
-	res, err := MakeRequest(config, msg, true)
+// separate 策略下回答不带分隔符，结束后单独请求生成 JSON 格式的标题和摘要
+func CreateChatStreamWithSummary(ctx context.Context, config AIProviderConfig, msg string, opts ChatOptions) (msgChan, sumChan chan string, err error) {
+	if SummaryStrategy() == SummaryStrategySeparate {
+		src, err := CreateChatStream(ctx, config, msg, opts)
+		if err != nil {
+			return nil, nil, err
+		}
+		msgChan, sumChan = SummarizeAfterStream(ctx, src, func(answer string) (SummaryResult, error) {
+			return Summarize(ctx, config, QuestionOf(msg), answer, opts)
+		})
+		return msgChan, sumChan, nil
+	}
+
+	body := NewChatRequest(config, chatSystemPrompt(true, opts), msg, true, opts)
+	res, err := OpenChatStream(ctx, config, body)
 	if err != nil {
 		return
 	}
@@ -229,7 +274,7 @@ func CreateChatStreamWithSummary(config AIProviderConfig, msg string) (msgChan,
 	scanner := bufio.NewScanner(res.Body)
 	scanner.Split(bufio.ScanLines)
 
-	go StreamSplitter(scanner, res, msgChan, sumChan)
+	go StreamSplitter(ctx, scanner, res, msgChan, sumChan, newUsageTracker(opts.Usage, body.Messages))
 
 	return
 }
@@ -0,0 +1,158 @@
+// Path: ./service/ai_service/common/summary.go
+
+package common
+
+import (
+	"context"
+	"dialogTree/global"
+	"dialogTree/service/ai_service/prompts"
+	"encoding/json"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"strings"
+)
+
+// 摘要生成策略
+const (
+	SummaryStrategyMarker   = "marker"   // 回答末尾用 ^¥& 分隔附带 JSON 格式的标题和摘要，一次请求完成
+	SummaryStrategySeparate = "separate" // 回答结束后单独发起一次非流式请求，输出 JSON 格式的标题和摘要
+)
+
+const (
+	summaryAnswerLimit = 4000 // 单独摘要时回答内容的最大字符数，避免长回答浪费 token
+	titleLimit         = 20   // 标题最大字符数，模型不遵守字数要求时截断
+)
+
+// SummaryStrategy 返回配置的摘要策略，未配置或无法识别时使用 marker
+func SummaryStrategy() string {
+	if global.Config != nil && global.Config.Ai.SummaryStrategy == SummaryStrategySeparate {
+		return SummaryStrategySeparate
+	}
+	return SummaryStrategyMarker
+}
+
+// SummaryResult 模型输出的标题和摘要
+type SummaryResult struct {
+	Title   string `json:"title"`
+	Summary string `json:"summary"`
+}
+
+// String 序列化为 JSON，通过 sumChan 传递给调用方
+func (r SummaryResult) String() string {
+	byteData, _ := json.Marshal(r)
+	return string(byteData)
+}
+
+// ParseSummaryResult 解析模型输出的 JSON，兼容 ```json 代码块和前后多余文字
+func ParseSummaryResult(raw string) (r SummaryResult, ok bool) {
+	start := strings.Index(raw, "{")
+	end := strings.LastIndex(raw, "}")
+	if start < 0 || end <= start {
+		return r, false
+	}
+	if err := json.Unmarshal([]byte(raw[start:end+1]), &r); err != nil {
+		return r, false
+	}
+	r.Title = truncateRunes(strings.TrimSpace(r.Title), titleLimit)
+	r.Summary = strings.TrimSpace(r.Summary)
+	return r, r.Summary != "" || r.Title != ""
+}
+
+// Summarize 非流式调用，为一轮问答生成标题和摘要
+func Summarize(ctx context.Context, config AIProviderConfig, question, answer string, opts ChatOptions) (r SummaryResult, err error) {
+	input, _ := json.Marshal(map[string]string{
+		"question": question,
+		"answer":   truncateRunes(answer, summaryAnswerLimit),
+	})
+	body := NewChatRequest(config, prompts.SummaryJSONPrompt, string(input), false, opts)
+
+	res, err := withRetry(ctx, config, body.Model, func() (*http.Response, error) {
+		return MakeRequest(ctx, config, body)
+	})
+	if err != nil {
+		return
+	}
+	defer res.Body.Close()
+
+	var aiRes UniversalChatResponse
+	if err = json.NewDecoder(res.Body).Decode(&aiRes); err != nil {
+		return r, fmt.Errorf("摘要响应解析失败: %w", err)
+	}
+	if len(aiRes.Choices) == 0 {
+		return r, fmt.Errorf("摘要响应为空")
+	}
+
+	content := aiRes.Choices[0].Message.Content
+	if opts.Usage != nil {
+		if aiRes.Usage != nil {
+			opts.Usage.Add(*aiRes.Usage)
+		} else {
+			opts.Usage.Add(Usage{
+				PromptTokens:     EstimateMessagesTokens(body.Messages),
+				CompletionTokens: EstimateTokens(content),
+				Estimated:        true,
+			})
+		}
+	}
+	r, ok := ParseSummaryResult(content)
+	if !ok {
+		return r, fmt.Errorf("摘要不是有效的JSON: %s", content)
+	}
+	return r, nil
+}
+
+// SummarizeAfterStream 转发 src 中的回答，结束后调用 summarize 生成摘要
+// 返回的 msgChan 在回答结束时关闭，sumChan 发送 SummaryResult 的 JSON 后关闭；ctx 取消时不再生成摘要
+func SummarizeAfterStream(ctx context.Context, src chan string, summarize func(answer string) (SummaryResult, error)) (msgChan, sumChan chan string) {
+	msgChan = make(chan string)
+	sumChan = make(chan string)
+
+	go func() {
+		defer close(sumChan)
+
+		var answer strings.Builder
+		for content := range src {
+			answer.WriteString(content)
+			if !sendContent(ctx, msgChan, content) {
+				break
+			}
+		}
+		close(msgChan)
+
+		if ctx.Err() != nil {
+			// 让上游 goroutine 结束
+			for range src {
+			}
+			return
+		}
+
+		r, err := summarize(answer.String())
+		if err != nil {
+			logrus.Warnf("单独生成摘要失败: %v", err)
+			return
+		}
+		sendContent(ctx, sumChan, r.String())
+	}()
+	return
+}
+
+// QuestionOf 从 JSON 上下文消息中取出当前问题，非 JSON 消息原样返回
+func QuestionOf(msg string) string {
+	var data struct {
+		Current string `json:"current"`
+	}
+	if err := json.Unmarshal([]byte(msg), &data); err == nil && data.Current != "" {
+		return data.Current
+	}
+	return msg
+}
+
+// truncateRunes 按字符截断，避免截断 UTF-8 字符
+func truncateRunes(s string, limit int) string {
+	runes := []rune(s)
+	if len(runes) <= limit {
+		return s
+	}
+	return string(runes[:limit]) + "..."
+}
@@ -0,0 +1,94 @@
+// Path: ./service/ai_service/common/usage.go
+
+package common
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Usage 一次或多次请求的 token 用量
+type Usage struct {
+	PromptTokens     int  `json:"prompt_tokens"`
+	CompletionTokens int  `json:"completion_tokens"`
+	TotalTokens      int  `json:"total_tokens"`
+	Estimated        bool `json:"-"` // 是否包含本地估算的用量
+}
+
+// Add 累加另一次请求的用量
+func (u *Usage) Add(o Usage) {
+	u.PromptTokens += o.PromptTokens
+	u.CompletionTokens += o.CompletionTokens
+	u.TotalTokens += o.PromptTokens + o.CompletionTokens
+	u.Estimated = u.Estimated || o.Estimated
+}
+
+// messageOverhead 每条消息的格式开销（role、分隔符等）
+const messageOverhead = 4
+
+// EstimateTokens 本地估算文本的 token 数，用于上游不返回 usage 的情况
+// 中日韩字符按每字 1 token，其余按约 4 字节 1 token 计算
+func EstimateTokens(text string) int {
+	var cjk, other int
+	for _, r := range text {
+		if r > unicode.MaxLatin1 && (unicode.Is(unicode.Han, r) || unicode.Is(unicode.Hiragana, r) ||
+			unicode.Is(unicode.Katakana, r) || unicode.Is(unicode.Hangul, r) || unicode.IsPunct(r)) {
+			cjk++
+			continue
+		}
+		other += utf8.RuneLen(r)
+	}
+	return cjk + (other+3)/4
+}
+
+// EstimateMessagesTokens 估算一组消息作为输入时的 token 数
+func EstimateMessagesTokens(messages []Message) int {
+	total := 0
+	for _, m := range messages {
+		total += EstimateTokens(m.Content) + messageOverhead
+	}
+	return total
+}
+
+// usageTracker 记录一次流式请求的用量，上游未返回 usage 时按本地估算
+type usageTracker struct {
+	target   *Usage
+	messages []Message
+	reported *Usage
+	output   strings.Builder
+}
+
+func newUsageTracker(target *Usage, messages []Message) *usageTracker {
+	return &usageTracker{target: target, messages: messages}
+}
+
+// observe 记录上游返回的 usage，开启 include_usage 时出现在最后一个数据块
+func (t *usageTracker) observe(u *Usage) {
+	if t != nil && u != nil {
+		t.reported = u
+	}
+}
+
+// write 记录输出内容，用于估算
+func (t *usageTracker) write(content string) {
+	if t != nil && t.target != nil {
+		t.output.WriteString(content)
+	}
+}
+
+// finish 将本次用量累加到调用方提供的 Usage，须在关闭通道前调用
+func (t *usageTracker) finish() {
+	if t == nil || t.target == nil {
+		return
+	}
+	if t.reported != nil {
+		t.target.Add(*t.reported)
+		return
+	}
+	t.target.Add(Usage{
+		PromptTokens:     EstimateMessagesTokens(t.messages),
+		CompletionTokens: EstimateTokens(t.output.String()),
+		Estimated:        true,
+	})
+}
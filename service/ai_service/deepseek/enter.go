@@ -2,10 +2,7 @@
 
 package deepseek
 
-import (
-	"dialogTree/global"
-	"dialogTree/service/ai_service/common"
-)
+import "dialogTree/service/ai_service/common"
 
 const baseURL = "https://api.deepseek.com/v1/chat/completions"
 
@@ -19,11 +16,9 @@ const (
 	DeepSeekR1Distill ModelType = "deepseek-r1-distill-llama-70b"
 )
 
-// getConfig 获取DeepSeek配置
-func getConfig() common.AIProviderConfig {
-	return common.AIProviderConfig{
-		BaseURL: baseURL,
-		APIKey:  global.Config.Ai.DeepSeek.SecretKey,
-		Model:   global.Config.Ai.DeepSeek.Model,
-	}
-}
\ No newline at end of file
+// ProviderType config.yaml 中对应的 type
+const ProviderType = "deepseek"
+
+func init() {
+	common.RegisterProviderType(ProviderType, common.CompatibleFactory(baseURL))
+}
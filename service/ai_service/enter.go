@@ -3,13 +3,13 @@
 package ai_service
 
 import (
+	"context"
 	"dialogTree/common/cres"
 	"dialogTree/global"
-	"dialogTree/service/ai_service/chat_anywhere"
-	"dialogTree/service/ai_service/deepseek"
-	"dialogTree/service/ai_service/openai"
+	"dialogTree/service/ai_service/common"
 	"dialogTree/service/redis_service"
 	"fmt"
+	"github.com/sirupsen/logrus"
 	"sort"
 )
 
@@ -27,58 +27,51 @@ const (
 	OpenAIProvider       AIProvider = "openai"
 	DeepSeekProvider     AIProvider = "deepseek"
 	BackendAIProvider    AIProvider = "backendai"
+	MockProvider         AIProvider = common.MockProviderName
 )
 
+// ChatOptions 单次请求的可选参数（模型、温度、最大 token）
+type ChatOptions = common.ChatOptions
+
+// ChatProvider 已配置的 AI 提供商
+type ChatProvider = common.ChatProvider
+
 // ChatStreamSum 统一的流式聊天+摘要接口
-func ChatStreamSum(msg string, provider AIProvider) (msgChan, sumChan chan string, err error) {
-	switch provider {
-	case ChatAnywhereProvider:
-		return chat_anywhere.ChatStreamSum(msg)
-	case DeepSeekProvider:
-		return deepseek.ChatStreamSum(msg)
-	case OpenAIProvider:
-		return openai.ChatStreamSum(msg)
-	case BackendAIProvider:
-		// BackendAI使用ChatAnywhere的实现，但使用不同的配置
-		return chat_anywhere.ChatStreamSum(msg)
-	default:
-		// 默认使用ChatAnywhere
-		return chat_anywhere.ChatStreamSum(msg)
+func ChatStreamSum(ctx context.Context, msg string, provider AIProvider, opts ChatOptions) (msgChan, sumChan chan string, err error) {
+	p, err := GetProvider(provider)
+	if err != nil {
+		return
 	}
+	msgChan, sumChan, _, err = ChatStreamSumWithFailover(ctx, msg, p, opts)
+	return
 }
 
 // ChatStream 统一的流式聊天接口
-func ChatStream(msg string, provider AIProvider) (msgChan chan string, err error) {
-	switch provider {
-	case ChatAnywhereProvider:
-		return chat_anywhere.ChatStream(msg)
-	case DeepSeekProvider:
-		return deepseek.ChatStream(msg)
-	case OpenAIProvider:
-		return openai.ChatStream(msg)
-	case BackendAIProvider:
-		return chat_anywhere.ChatStream(msg)
-	default:
-		return chat_anywhere.ChatStream(msg)
+func ChatStream(ctx context.Context, msg string, provider AIProvider, opts ChatOptions) (msgChan chan string, err error) {
+	p, err := GetProvider(provider)
+	if err != nil {
+		return
 	}
+	msgChan, _, err = ChatStreamWithFailover(ctx, msg, p, opts)
+	return
 }
 
 // GetDefaultProvider 根据配置获取默认的AI提供商
 func GetDefaultProvider() AIProvider {
-	// 优先级：ChatAnywhere > DeepSeek > BackendAI > OpenAI
-	if global.Config.Ai.ChatAnywhere.SecretKey != "" {
-		return ChatAnywhereProvider
+	// 显式指定的默认提供商优先
+	if name := global.Config.Ai.DefaultProvider; name != "" {
+		if _, err := GetProvider(AIProvider(name)); err == nil {
+			return AIProvider(name)
+		}
+		logrus.Warnf("默认提供商 %s 不可用，按优先级自动选择", name)
 	}
-	if global.Config.Ai.DeepSeek.SecretKey != "" {
-		return DeepSeekProvider
-	}
-	if global.Config.Ai.BackendAi.SecretKey != "" {
-		return BackendAIProvider
-	}
-	if global.Config.Ai.OpenAI.SecretKey != "" {
-		return OpenAIProvider
+
+	// 优先级：ChatAnywhere > DeepSeek > BackendAI > OpenAI > providers 列表
+	providers := Providers()
+	if len(providers) == 0 {
+		return MockProvider
 	}
-	return ChatAnywhereProvider
+	return AIProvider(providers[0].Name())
 }
 
 // PreprocessFromRedis 从Redis预处理消息（通用函数）
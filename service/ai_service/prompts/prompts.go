@@ -8,4 +8,20 @@ import _ "embed"
 var ChatPrompt string
 
 //go:embed summarize.prompt
-var SummarizePrompt string
\ No newline at end of file
+var SummarizePrompt string
+
+//go:embed summary_json.prompt
+var SummaryJSONPrompt string
+
+// 以下两段在使用角色设定时与角色说明组合，替代 ChatPrompt/SummarizePrompt 中的默认角色
+
+//go:embed context.prompt
+var ContextPrompt string
+
+//go:embed summary_format.prompt
+var SummaryFormatPrompt string
+
+// 合并两个分支时替代上下文结构说明
+
+//go:embed merge.prompt
+var MergePrompt string
@@ -0,0 +1,125 @@
+// Path: ./service/ai_service/registry.go
+
+package ai_service
+
+import (
+	"context"
+	"dialogTree/global"
+	"dialogTree/service/ai_service/common"
+	"fmt"
+	"github.com/sirupsen/logrus"
+
+	// 内置提供商类型，通过 init 注册到 common
+	_ "dialogTree/service/ai_service/chat_anywhere"
+	_ "dialogTree/service/ai_service/deepseek"
+	_ "dialogTree/service/ai_service/openai"
+)
+
+// Providers 按优先级返回所有已配置且可用的提供商
+func Providers() []common.ChatProvider {
+	var providers []common.ChatProvider
+	for _, cfg := range global.Config.Ai.ProviderList() {
+		p, err := common.NewProvider(cfg)
+		if err != nil {
+			logrus.Warnf("跳过提供商 %s: %v", cfg.Name, err)
+			continue
+		}
+		providers = append(providers, p)
+	}
+	return providers
+}
+
+// GetProvider 按名称获取提供商，未配置任何提供商时 mock 可用于测试
+// 配置了提供商后不再接受 mock，避免客户端指定 mock 把固定回答保存为真实对话
+func GetProvider(name AIProvider) (common.ChatProvider, error) {
+	if name == MockProvider && len(global.Config.Ai.ProviderList()) == 0 {
+		return common.MockProvider{}, nil
+	}
+	for _, p := range Providers() {
+		if p.Name() == string(name) {
+			return p, nil
+		}
+	}
+	return nil, fmt.Errorf("未知或未配置的AI提供商: %s", name)
+}
+
+// ResolveProvider 按名称获取提供商，名称为空时使用默认提供商
+func ResolveProvider(name string) (common.ChatProvider, error) {
+	if name == "" {
+		return GetProvider(GetDefaultProvider())
+	}
+	return GetProvider(AIProvider(name))
+}
+
+// ModelOf 返回提供商在本次请求中实际使用的模型
+func ModelOf(p common.ChatProvider, opts ChatOptions) string {
+	if opts.Model != "" {
+		return opts.Model
+	}
+	return p.Model()
+}
+
+// Answerer 实际回答本次请求的提供商和模型
+type Answerer struct {
+	Provider string `json:"provider"`
+	Model    string `json:"model"`
+	Fallback bool   `json:"fallback"` // 是否由备用提供商接管
+}
+
+// failoverChain 返回故障转移顺序：首选提供商在前，其余按 GetDefaultProvider 的优先级排列
+func failoverChain(primary common.ChatProvider) []common.ChatProvider {
+	chain := []common.ChatProvider{primary}
+	if global.Config.Ai.Retry.DisableFailover || primary.Name() == string(MockProvider) {
+		return chain
+	}
+	for _, p := range Providers() {
+		if p.Name() != primary.Name() {
+			chain = append(chain, p)
+		}
+	}
+	return chain
+}
+
+// withFailover 依次尝试故障转移链上的提供商，直到 open 成功或遇到不可恢复的错误
+// 备用提供商不沿用请求指定的模型，使用各自配置的模型
+func withFailover(primary common.ChatProvider, opts ChatOptions, open func(p common.ChatProvider, opts ChatOptions) error) (answerer Answerer, err error) {
+	for i, p := range failoverChain(primary) {
+		attemptOpts := opts
+		if i > 0 {
+			attemptOpts.Model = ""
+			logrus.Warnf("提供商 %s 不可用，切换到 %s", primary.Name(), p.Name())
+		}
+
+		err = open(p, attemptOpts)
+		if err == nil {
+			if i > 0 {
+				logrus.Infof("已由备用提供商 %s 接管请求", p.Name())
+			}
+			return Answerer{Provider: p.Name(), Model: ModelOf(p, attemptOpts), Fallback: i > 0}, nil
+		}
+
+		logrus.Errorf("提供商 %s 调用失败: %v", p.Name(), err)
+		if !common.IsRetryable(err) {
+			break
+		}
+	}
+	return answerer, err
+}
+
+// ChatStreamSumWithFailover 流式聊天+摘要，首选提供商重试耗尽后按优先级切换
+func ChatStreamSumWithFailover(ctx context.Context, msg string, primary common.ChatProvider, opts ChatOptions) (msgChan, sumChan chan string, answerer Answerer, err error) {
+	answerer, err = withFailover(primary, opts, func(p common.ChatProvider, opts ChatOptions) (err error) {
+		msgChan, sumChan, err = p.ChatStreamSum(ctx, msg, opts)
+		return
+	})
+	return
+}
+
+// ChatStreamWithFailover 流式聊天，首选提供商重试耗尽后按优先级切换
+func ChatStreamWithFailover(ctx context.Context, msg string, primary common.ChatProvider, opts ChatOptions) (msgChan chan string, answerer Answerer, err error) {
+	answerer, err = withFailover(primary, opts, func(p common.ChatProvider, opts ChatOptions) (err error) {
+		msgChan, err = p.ChatStream(ctx, msg, opts)
+		return
+	})
+	return
+}
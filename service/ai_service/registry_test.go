@@ -0,0 +1,330 @@
+package ai_service
+
+import (
+	"context"
+	"dialogTree/conf"
+	"dialogTree/global"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newStreamServer 返回一个按 OpenAI 流式协议输出固定回答的测试服务
+func newStreamServer(answer string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, char := range answer {
+			fmt.Fprintf(w, "data: {\"choices\":[{\"delta\":{\"content\":%q}}]}\n\n", string(char))
+		}
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+}
+
+// TestFailoverAfterRetries 首选提供商持续 503 时，重试耗尽后切换到下一个提供商
+func TestFailoverAfterRetries(t *testing.T) {
+	var primaryCalls int32
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&primaryCalls, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer primary.Close()
+
+	backup := newStreamServer("备用回答")
+	defer backup.Close()
+
+	global.Config = &conf.Config{
+		Ai: conf.Ai{
+			Providers: []conf.Provider{
+				{Name: "primary", BaseURL: primary.URL, Model: "primary-model"},
+				{Name: "backup", BaseURL: backup.URL, Model: "backup-model"},
+			},
+			Retry: conf.Retry{MaxRetries: 2, BaseDelay: 1},
+		},
+	}
+
+	p, err := ResolveProvider("primary")
+	if err != nil {
+		t.Fatalf("获取提供商失败: %v", err)
+	}
+
+	msgChan, answerer, err := ChatStreamWithFailover(context.Background(), "你好", p, ChatOptions{Model: "primary-only-model"})
+	if err != nil {
+		t.Fatalf("故障转移失败: %v", err)
+	}
+
+	var answer strings.Builder
+	for chunk := range msgChan {
+		answer.WriteString(chunk)
+	}
+
+	if got := atomic.LoadInt32(&primaryCalls); got != 3 {
+		t.Errorf("首选提供商应该被请求3次（1次+2次重试），实际：%d", got)
+	}
+	if !answerer.Fallback || answerer.Provider != "backup" || answerer.Model != "backup-model" {
+		t.Errorf("应该由备用提供商使用自身模型接管，实际：%+v", answerer)
+	}
+	if answer.String() != "备用回答" {
+		t.Errorf("回答内容不正确：%s", answer.String())
+	}
+}
+
+// TestNoFailoverOnClientError 401 等请求错误不重试也不切换
+func TestNoFailoverOnClientError(t *testing.T) {
+	var calls int32
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer primary.Close()
+
+	backup := newStreamServer("备用回答")
+	defer backup.Close()
+
+	global.Config = &conf.Config{
+		Ai: conf.Ai{
+			Providers: []conf.Provider{
+				{Name: "primary", BaseURL: primary.URL},
+				{Name: "backup", BaseURL: backup.URL},
+			},
+			Retry: conf.Retry{BaseDelay: 1},
+		},
+	}
+
+	p, _ := ResolveProvider("primary")
+	_, _, err := ChatStreamWithFailover(context.Background(), "你好", p, ChatOptions{})
+	if err == nil {
+		t.Fatal("401 应该直接返回错误")
+	}
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Errorf("401 不应该重试，实际请求次数：%d", got)
+	}
+}
+
+// TestCancelAbortsUpstream 取消 ctx 后通道关闭，上游连接被中断
+func TestCancelAbortsUpstream(t *testing.T) {
+	upstreamDone := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: {\"choices\":[{\"delta\":{\"content\":\"部分\"}}]}\n\n")
+		w.(http.Flusher).Flush()
+		// 模拟一个迟迟不结束的回答，直到客户端断开
+		<-r.Context().Done()
+		close(upstreamDone)
+	}))
+	defer server.Close()
+
+	global.Config = &conf.Config{
+		Ai: conf.Ai{
+			Providers: []conf.Provider{{Name: "slow", BaseURL: server.URL}},
+		},
+	}
+
+	p, _ := ResolveProvider("slow")
+	ctx, cancel := context.WithCancel(context.Background())
+	msgChan, sumChan, _, err := ChatStreamSumWithFailover(ctx, "你好", p, ChatOptions{})
+	if err != nil {
+		t.Fatalf("创建流失败: %v", err)
+	}
+
+	if chunk := <-msgChan; chunk != "部分" {
+		t.Fatalf("应该先收到部分回答，实际：%s", chunk)
+	}
+	cancel()
+
+	for range msgChan {
+	}
+	for range sumChan {
+	}
+
+	select {
+	case <-upstreamDone:
+	case <-time.After(2 * time.Second):
+		t.Fatal("取消后上游请求应该被中断")
+	}
+}
+
+// TestSeparateSummaryStrategy separate 策略下回答不含分隔符，摘要由单独的非流式请求生成
+func TestSeparateSummaryStrategy(t *testing.T) {
+	var summaryCalls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Stream bool `json:"stream"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		if !req.Stream {
+			atomic.AddInt32(&summaryCalls, 1)
+			fmt.Fprint(w, `{"choices":[{"message":{"role":"assistant","content":"{\"title\": \"问候\", \"summary\": \"用户打招呼\"}"}}]}`)
+			return
+		}
+		for _, char := range "你好呀" {
+			fmt.Fprintf(w, "data: {\"choices\":[{\"delta\":{\"content\":%q}}]}\n\n", string(char))
+		}
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer server.Close()
+
+	global.Config = &conf.Config{
+		Ai: conf.Ai{
+			Providers:       []conf.Provider{{Name: "local", BaseURL: server.URL}},
+			SummaryStrategy: "separate",
+		},
+	}
+
+	p, err := ResolveProvider("local")
+	if err != nil {
+		t.Fatalf("获取提供商失败: %v", err)
+	}
+
+	msgChan, sumChan, _, err := ChatStreamSumWithFailover(context.Background(), `{"current":"你好"}`, p, ChatOptions{})
+	if err != nil {
+		t.Fatalf("请求失败: %v", err)
+	}
+
+	var answer strings.Builder
+	for chunk := range msgChan {
+		answer.WriteString(chunk)
+	}
+	var raw string
+	for s := range sumChan {
+		raw += s
+	}
+
+	if answer.String() != "你好呀" {
+		t.Errorf("回答内容不正确：%s", answer.String())
+	}
+	if got := atomic.LoadInt32(&summaryCalls); got != 1 {
+		t.Errorf("应该单独请求1次摘要，实际：%d", got)
+	}
+	title, summary := ParseSummary(raw)
+	if title != "问候" || summary != "用户打招呼" {
+		t.Errorf("标题或摘要解析错误：%q %q", title, summary)
+	}
+}
+
+// TestStreamUsage 上游返回 usage 时直接记录，关闭 include_usage 时按本地估算
+func TestStreamUsage(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			StreamOptions *struct {
+				IncludeUsage bool `json:"include_usage"`
+			} `json:"stream_options"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		fmt.Fprint(w, "data: {\"choices\":[{\"delta\":{\"content\":\"你好\"}}]}\n\n")
+		if req.StreamOptions != nil && req.StreamOptions.IncludeUsage {
+			fmt.Fprint(w, "data: {\"choices\":[],\"usage\":{\"prompt_tokens\":30,\"completion_tokens\":2,\"total_tokens\":32}}\n\n")
+		}
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer server.Close()
+
+	global.Config = &conf.Config{
+		Ai: conf.Ai{
+			Providers: []conf.Provider{
+				{Name: "usage", BaseURL: server.URL},
+				{Name: "nousage", BaseURL: server.URL, DisableUsage: true},
+			},
+		},
+	}
+
+	run := func(name string) Usage {
+		p, err := ResolveProvider(name)
+		if err != nil {
+			t.Fatalf("获取提供商失败: %v", err)
+		}
+		var usage Usage
+		msgChan, err := p.ChatStream(context.Background(), "你好", ChatOptions{Usage: &usage})
+		if err != nil {
+			t.Fatalf("请求失败: %v", err)
+		}
+		for range msgChan {
+		}
+		return usage
+	}
+
+	if u := run("usage"); u.PromptTokens != 30 || u.CompletionTokens != 2 || u.Estimated {
+		t.Errorf("应该记录上游返回的用量，实际：%+v", u)
+	}
+	if u := run("nousage"); !u.Estimated || u.PromptTokens == 0 || u.CompletionTokens != 2 {
+		t.Errorf("应该按本地估算用量，实际：%+v", u)
+	}
+}
+
+// TestPersonaSystemPrompt 角色说明与上下文结构说明组合为 system prompt
+func TestPersonaSystemPrompt(t *testing.T) {
+	systemPrompts := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Messages []struct {
+				Content string `json:"content"`
+			} `json:"messages"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		systemPrompts <- req.Messages[0].Content
+		fmt.Fprint(w, "data: {\"choices\":[{\"delta\":{\"content\":\"ok\"}}]}\n\n")
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer server.Close()
+
+	global.Config = &conf.Config{
+		Ai: conf.Ai{Providers: []conf.Provider{{Name: "local", BaseURL: server.URL}}},
+	}
+	p, err := ResolveProvider("local")
+	if err != nil {
+		t.Fatalf("获取提供商失败: %v", err)
+	}
+
+	msgChan, sumChan, _, err := ChatStreamSumWithFailover(context.Background(), `{"current":"你好"}`, p, ChatOptions{Persona: "你是一名Go代码审查员。"})
+	if err != nil {
+		t.Fatalf("请求失败: %v", err)
+	}
+	for range msgChan {
+	}
+	for range sumChan {
+	}
+
+	got := <-systemPrompts
+	if !strings.HasPrefix(got, "你是一名Go代码审查员。") {
+		t.Errorf("system prompt 应以角色说明开头：%s", got)
+	}
+	if !strings.Contains(got, "**current**") || !strings.Contains(got, "^¥&") {
+		t.Errorf("system prompt 应包含上下文结构说明和摘要格式：%s", got)
+	}
+	if strings.Contains(got, "智能对话助手") {
+		t.Errorf("使用角色时不应包含默认的助手设定：%s", got)
+	}
+}
+
+// TestMockOnlyWithoutProviders 配置了提供商后不能再选择 mock
+func TestMockOnlyWithoutProviders(t *testing.T) {
+	global.Config = &conf.Config{}
+	if _, err := ResolveProvider("mock"); err != nil {
+		t.Fatalf("未配置提供商时 mock 应可用: %v", err)
+	}
+
+	global.Config = &conf.Config{
+		Ai: conf.Ai{Providers: []conf.Provider{{Name: "local", BaseURL: "http://localhost:1", Model: "local-model"}}},
+	}
+	if _, err := ResolveProvider("mock"); err == nil {
+		t.Error("配置了提供商后不应接受 mock")
+	}
+}
+
+// TestBackendAiWithoutBaseURL 只配置密钥和模型的 backendAi 仍然可用
+func TestBackendAiWithoutBaseURL(t *testing.T) {
+	global.Config = &conf.Config{
+		Ai: conf.Ai{BackendAi: conf.BackendAi{SecretKey: "sk-test", Model: "gpt-4o-mini"}},
+	}
+	p, err := ResolveProvider("backendai")
+	if err != nil {
+		t.Fatalf("未配置 baseURL 的 backendAi 应可用: %v", err)
+	}
+	if p.Model() != "gpt-4o-mini" {
+		t.Errorf("模型不正确: %s", p.Model())
+	}
+}
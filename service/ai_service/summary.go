@@ -0,0 +1,29 @@
+// Path: ./service/ai_service/summary.go
+
+package ai_service
+
+import (
+	"context"
+	"dialogTree/service/ai_service/common"
+	"strings"
+)
+
+type SummaryResult = common.SummaryResult
+
+// ParseSummary 解析 sumChan 收到的标题和摘要
+// 两种策略都输出 JSON，模型未遵守格式时整段作为摘要，标题为空
+func ParseSummary(raw string) (title, summary string) {
+	if r, ok := common.ParseSummaryResult(raw); ok {
+		return r.Title, r.Summary
+	}
+	return "", strings.TrimSpace(raw)
+}
+
+// SummarizeWithFailover 单独为一轮问答生成标题和摘要，首选提供商不可用时按优先级切换
+func SummarizeWithFailover(ctx context.Context, question, answer string, primary common.ChatProvider) (result SummaryResult, answerer Answerer, err error) {
+	answerer, err = withFailover(primary, ChatOptions{}, func(p common.ChatProvider, opts ChatOptions) (err error) {
+		result, err = p.Summarize(ctx, question, answer, opts)
+		return
+	})
+	return
+}
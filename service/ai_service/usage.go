@@ -0,0 +1,21 @@
+// Path: ./service/ai_service/usage.go
+
+package ai_service
+
+import (
+	"dialogTree/global"
+	"dialogTree/service/ai_service/common"
+)
+
+// Usage 一次回答的 token 用量
+type Usage = common.Usage
+
+// EstimateTokens 本地估算文本的 token 数
+func EstimateTokens(text string) int {
+	return common.EstimateTokens(text)
+}
+
+// CostOf 按配置的模型单价计算费用
+func CostOf(model string, usage Usage) float64 {
+	return global.Config.Ai.Cost(model, usage.PromptTokens, usage.CompletionTokens)
+}
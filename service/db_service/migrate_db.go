@@ -5,6 +5,7 @@ package db_service
 import (
 	"dialogTree/global"
 	"dialogTree/models"
+	"dialogTree/service/dialog_service"
 	"github.com/sirupsen/logrus"
 )
 
@@ -16,6 +17,11 @@ func MigrateDB() {
 		&models.DialogModel{},
 		&models.ConversationModel{},
 		&models.ImageModel{},
+		&models.PersonaModel{},
+		&models.PromptTemplateModel{},
+		&models.ConversationParentModel{},
+		&models.VectorPointModel{},
+		&models.MigrationModel{},
 	)
 	if err != nil {
 		logrus.Errorf("failed to migrate DB: %s\n", err)
@@ -25,6 +31,20 @@ func MigrateDB() {
 
 	// 检查并创建默认分类
 	createDefaultCategory()
+	markFreshParentsBackfilled()
+}
+
+// markFreshParentsBackfilled 新建的数据库没有需要回填父对话的旧数据，直接记录 backfill-parents 已完成
+func markFreshParentsBackfilled() {
+	var count int64
+	if err := global.DB.Model(&models.ConversationModel{}).Count(&count).Error; err != nil || count > 0 {
+		return
+	}
+	err := global.DB.Where(models.MigrationModel{Name: dialog_service.MigrationBackfillParents}).
+		FirstOrCreate(&models.MigrationModel{}).Error
+	if err != nil {
+		logrus.Errorf("failed to mark parents backfilled: %s\n", err)
+	}
 }
 
 func createDefaultCategory() {
@@ -49,6 +49,8 @@ func setupTestDB(t *testing.T) *gorm.DB {
 		&models.DialogModel{},
 		&models.ConversationModel{},
 		&models.CategoryModel{},
+		&models.ConversationParentModel{},
+		&models.MigrationModel{},
 	)
 	if err != nil {
 		t.Fatalf("数据库迁移失败: %v", err)
@@ -148,6 +150,24 @@ func TestCheckIfBranchingByConversation(t *testing.T) {
 			t.Error("不存在的conversation应该报错")
 		}
 	})
+
+	// 测试用例4：后写入的conversation创建时间更早（时钟回拨），仍按写入顺序判断
+	t.Run("按写入顺序判断最新conversation", func(t *testing.T) {
+		later := models.ConversationModel{
+			Model:     models.Model{CreatedAt: time.Now().Add(-time.Hour)},
+			Prompt:    "问题6",
+			SessionID: sessionID,
+			DialogID:  dialogID,
+		}
+		db.Create(&later)
+		needsBranching, err := CheckIfBranchingByConversation(conversationIDs[len(conversationIDs)-1])
+		if err != nil {
+			t.Errorf("检查分叉失败: %v", err)
+		}
+		if !needsBranching {
+			t.Error("之后写入过对话，原来的最新conversation应该需要分叉")
+		}
+	})
 }
 
 // TestCreateBranchingDialogs 测试分叉创建逻辑
@@ -0,0 +1,167 @@
+package dialog_service
+
+import (
+	"dialogTree/global"
+	"dialogTree/models"
+	"fmt"
+	"math/rand"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// setupFileTestDB 并发测试使用文件数据库，:memory: 的每个连接都是独立的空库
+func setupFileTestDB(t *testing.T) *gorm.DB {
+	dsn := filepath.Join(t.TempDir(), "stress.db") + "?_busy_timeout=10000&_txlock=immediate"
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("创建测试数据库失败: %v", err)
+	}
+	err = db.AutoMigrate(
+		&models.SessionModel{},
+		&models.DialogModel{},
+		&models.ConversationModel{},
+		&models.CategoryModel{},
+		&models.ConversationParentModel{},
+		&models.MigrationModel{},
+	)
+	if err != nil {
+		t.Fatalf("数据库迁移失败: %v", err)
+	}
+	return db
+}
+
+// TestConcurrentBranching 并发地从随机对话继续提问，检查对话树结构仍然正确
+func TestConcurrentBranching(t *testing.T) {
+	setupTestConfig()
+	global.DB = setupFileTestDB(t)
+
+	session := models.SessionModel{Tittle: "并发测试"}
+	if err := global.DB.Create(&session).Error; err != nil {
+		t.Fatalf("创建会话失败: %v", err)
+	}
+
+	save := func(parentID *int64, prompt string) (*models.ConversationModel, error) {
+		return SaveConversation(ConversationRecord{
+			SessionID:            session.ID,
+			ParentConversationID: parentID,
+			Prompt:               prompt,
+			Answer:               "回答" + prompt,
+			SummaryRaw:           `{"title":"t","summary":"s"}`,
+		})
+	}
+
+	// 先建一条对话链作为分叉点
+	var ids []int64
+	var parent *int64
+	for i := 0; i < 5; i++ {
+		conv, err := save(parent, fmt.Sprintf("种子%d", i))
+		if err != nil {
+			t.Fatalf("创建种子对话失败: %v", err)
+		}
+		ids = append(ids, conv.ID)
+		parent = &conv.ID
+	}
+
+	const workers, rounds = 8, 10
+	var mu sync.Mutex
+	expected := map[int64]int64{} // 对话ID -> 请求时指定的父对话
+	var wg sync.WaitGroup
+	errs := make(chan error, workers*rounds)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			r := rand.New(rand.NewSource(int64(w)))
+			for i := 0; i < rounds; i++ {
+				mu.Lock()
+				parentID := ids[r.Intn(len(ids))]
+				mu.Unlock()
+
+				conv, err := save(&parentID, fmt.Sprintf("w%d-%d", w, i))
+				if err != nil {
+					errs <- err
+					continue
+				}
+				mu.Lock()
+				ids = append(ids, conv.ID)
+				expected[conv.ID] = parentID
+				mu.Unlock()
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("并发保存失败: %v", err)
+	}
+
+	checkTreeInvariants(t, session.ID, expected)
+}
+
+// checkTreeInvariants 检查对话树的结构约束
+func checkTreeInvariants(t *testing.T, sessionID int64, expected map[int64]int64) {
+	t.Helper()
+
+	var dialogs []models.DialogModel
+	global.DB.Where("session_id = ?", sessionID).Find(&dialogs)
+	var convs []models.ConversationModel
+	global.DB.Where("session_id = ?", sessionID).Order("id ASC").Find(&convs)
+
+	dialogByID := map[int64]models.DialogModel{}
+	for _, d := range dialogs {
+		dialogByID[d.ID] = d
+	}
+	convByID := map[int64]models.ConversationModel{}
+	count := map[int64]int{}
+	for _, c := range convs {
+		convByID[c.ID] = c
+		count[c.DialogID]++
+		if _, ok := dialogByID[c.DialogID]; !ok {
+			t.Errorf("对话 %d 所在的dialog %d 不存在或不属于该会话", c.ID, c.DialogID)
+		}
+	}
+
+	roots := 0
+	for _, d := range dialogs {
+		if count[d.ID] == 0 {
+			t.Errorf("dialog %d 没有对话", d.ID)
+		}
+		if d.ParentID == nil {
+			roots++
+			continue
+		}
+		if _, ok := dialogByID[*d.ParentID]; !ok {
+			t.Errorf("dialog %d 的父dialog %d 不存在", d.ID, *d.ParentID)
+		}
+		if d.BranchFromConversationID != nil && convByID[*d.BranchFromConversationID].DialogID != *d.ParentID {
+			t.Errorf("dialog %d 的分叉点 %d 不在父dialog %d 中", d.ID, *d.BranchFromConversationID, *d.ParentID)
+		}
+	}
+	if roots != 1 {
+		t.Errorf("会话应只有一个根dialog，实际 %d 个", roots)
+	}
+
+	var session models.SessionModel
+	global.DB.First(&session, sessionID)
+	if session.RootDialogID == nil || dialogByID[*session.RootDialogID].ParentID != nil {
+		t.Errorf("会话的根dialog %v 不正确", session.RootDialogID)
+	}
+
+	for id, parentID := range expected {
+		conv := convByID[id]
+		if conv.ParentConversationID == nil || *conv.ParentConversationID != parentID {
+			t.Errorf("对话 %d 的父对话应为 %d，实际 %v", id, parentID, conv.ParentConversationID)
+			continue
+		}
+		// 树结构推断出的父对话也应一致
+		inferred, err := inferParentConversation(global.DB, conv)
+		if err != nil || inferred.ID != parentID {
+			t.Errorf("对话 %d 在树中的位置与父对话 %d 不符", id, parentID)
+		}
+	}
+}
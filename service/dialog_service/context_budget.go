@@ -0,0 +1,328 @@
+// Path: ./service/dialog_service/context_budget.go
+
+package dialog_service
+
+import (
+	"dialogTree/global"
+	"dialogTree/models"
+	"dialogTree/service/ai_service"
+	"fmt"
+)
+
+// 上下文条目的保留程度，预算不足时依次降级
+const (
+	LevelFull    = "full"    // 问题 + 完整回答
+	LevelSummary = "summary" // 问题 + 摘要
+	LevelDropped = "dropped" // 超出预算被省略
+)
+
+// 上下文所属部分
+const (
+	SectionRecent  = "recent"
+	SectionPinned  = "pinned"
+	SectionHistory = "history"
+	SectionCommon  = "common" // 合并时两个分支共同的前序对话
+	SectionBranch  = "branch" // 合并时某个分支独有的对话
+)
+
+// 上下文来源
+const (
+	SourceAncestor = "ancestor" // 沿祖先链追溯
+	SourceLatest   = "latest"   // 未指定父对话时取会话中最新的对话
+	SourceVector   = "vector"   // 向量检索召回
+	SourceKeyword  = "keyword"  // 关键词检索召回
+	SourceHybrid   = "hybrid"   // 向量和关键词检索都召回
+	SourcePinned   = "pinned"   // 用户固定的对话
+)
+
+const (
+	pairOverhead          = 8   // 每个 QAPair 的 JSON 结构开销
+	contextOverhead       = 16  // 整个上下文 JSON 的结构开销
+	summaryPromptTokens   = 200 // 降级为摘要时问题保留的最大 token 数
+	summaryFallbackTokens = 150 // 对话没有摘要时，截取回答作为摘要的 token 数
+)
+
+// ContextItem 一条候选对话在上下文中的取舍结果
+type ContextItem struct {
+	ConversationID int64   `json:"conversationId"`
+	Section        string  `json:"section"`         // recent/pinned/history
+	Source         string  `json:"source"`          // ancestor/latest/pinned/vector/keyword/hybrid
+	Score          float64 `json:"score,omitempty"` // 向量召回的相似度，仅由关键词召回时为 0
+	Level          string  `json:"level"`           // full/summary/dropped
+	Tokens         int     `json:"tokens"`          // 实际占用的 token 数，被省略时为 0
+
+	// 预览时填充实际放入上下文的内容
+	Q string `json:"Q,omitempty"`
+	A string `json:"A,omitempty"`
+}
+
+// ContextReport 上下文拼装结果，记录预算和每条对话是否被完整保留、降级或省略
+type ContextReport struct {
+	Model         string        `json:"model"`
+	Budget        int           `json:"budget"`
+	Used          int           `json:"used"`
+	CurrentTokens int           `json:"currentTokens"`
+	Items         []ContextItem `json:"items"`
+}
+
+// Elided 返回被降级或省略的条目
+func (r ContextReport) Elided() []ContextItem {
+	var items []ContextItem
+	for _, item := range r.Items {
+		if item.Level != LevelFull {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// contextCandidate 一条候选上下文
+type contextCandidate struct {
+	conv          models.ConversationModel
+	section       string
+	source        string
+	score         float64
+	maxLevel      string // recent 最多保留完整回答，history 最多保留摘要
+	full          QAPair
+	summary       QAPair
+	fullTokens    int
+	summaryTokens int
+	level         string
+}
+
+func newContextCandidate(conv models.ConversationModel, section, source string) *contextCandidate {
+	c := &contextCandidate{
+		conv:     conv,
+		section:  section,
+		source:   source,
+		maxLevel: LevelFull,
+		level:    LevelDropped,
+	}
+	if section == SectionHistory {
+		c.maxLevel = LevelSummary
+	}
+
+	summary := conv.Summary
+	if summary == "" {
+		summary = truncateTokens(conv.Answer, summaryFallbackTokens, "答案中间部分")
+	}
+	c.full = QAPair{Q: conv.Prompt, A: conv.Answer}
+	c.summary = QAPair{Q: truncateTokens(conv.Prompt, summaryPromptTokens, "提问中间部分"), A: summary}
+	c.fullTokens = pairTokens(c.full)
+	c.summaryTokens = pairTokens(c.summary)
+	return c
+}
+
+// pair 返回按当前保留程度输出的问答对
+func (c *contextCandidate) pair() QAPair {
+	if c.level == LevelFull {
+		return c.full
+	}
+	return c.summary
+}
+
+// tokens 返回按当前保留程度占用的 token 数
+func (c *contextCandidate) tokens() int {
+	switch c.level {
+	case LevelFull:
+		return c.fullTokens
+	case LevelSummary:
+		return c.summaryTokens
+	}
+	return 0
+}
+
+func pairTokens(p QAPair) int {
+	return ai_service.EstimateTokens(p.Q) + ai_service.EstimateTokens(p.A) + pairOverhead
+}
+
+// chained recent、common、branch 是连续的祖先链
+func (c *contextCandidate) chained() bool {
+	return c.section == SectionRecent || c.section == SectionCommon || c.section == SectionBranch
+}
+
+// allocate 按优先级为候选分配预算，返回占用的 token 数
+// 先让尽可能多的条目以摘要形式保留，再用剩余预算把条目升级为完整回答
+// 祖先链上某条放不下时更早的条目一并省略，避免上下文出现断层
+func allocate(cands []*contextCandidate, budget int) int {
+	used := 0
+	chainBroken := false
+	for _, c := range cands {
+		if chainBroken || used+c.summaryTokens > budget {
+			c.level = LevelDropped
+			chainBroken = c.chained()
+			continue
+		}
+		c.level = LevelSummary
+		used += c.summaryTokens
+	}
+	return used + upgrade(cands, budget-used)
+}
+
+// upgrade 用剩余预算按优先级把摘要升级为完整回答，返回额外占用的 token 数
+func upgrade(cands []*contextCandidate, remaining int) int {
+	extra := 0
+	for _, c := range cands {
+		if c.level != LevelSummary || c.maxLevel != LevelFull {
+			continue
+		}
+		delta := c.fullTokens - c.summaryTokens
+		if delta <= remaining-extra {
+			c.level = LevelFull
+			extra += delta
+		}
+	}
+	return extra
+}
+
+// ForkContext 从过去的对话分出新分支（重新生成、修改后重新发送）时的上下文范围
+// 短期记忆只沿父对话的祖先链追溯，父对话为空（会话根部）时没有短期记忆
+// 被替换的对话及其后续不应作为已有的对话进入上下文，固定和召回的对话中排除 Excluded
+type ForkContext struct {
+	Excluded map[int64]bool
+}
+
+// excludes 对话是否不应进入分叉的上下文
+func (f *ForkContext) excludes(conversationID int64) bool {
+	return f != nil && f.Excluded[conversationID]
+}
+
+// AssembleContext 在模型的 token 预算内拼装上下文
+// 当前问题总是完整保留，其次是用户固定的对话，剩余预算按比例分给 recent 和 history，一方用不完的预算留给另一方
+func AssembleContext(sessionID int64, parentConversationID *int64, currentQuestion, model string) (ContextData, ContextReport, error) {
+	return assembleContext(sessionID, parentConversationID, currentQuestion, model, false, nil)
+}
+
+// AssembleForkContext 按分叉的上下文范围拼装上下文，fork 为空时与 AssembleContext 相同
+func AssembleForkContext(sessionID int64, parentConversationID *int64, currentQuestion, model string, fork *ForkContext) (ContextData, ContextReport, error) {
+	return assembleContext(sessionID, parentConversationID, currentQuestion, model, false, fork)
+}
+
+// PreviewContext 拼装上下文但不发送，报告中附带每条对话实际放入的内容
+func PreviewContext(sessionID int64, parentConversationID *int64, currentQuestion, model string) (ContextData, ContextReport, error) {
+	return assembleContext(sessionID, parentConversationID, currentQuestion, model, true, nil)
+}
+
+func assembleContext(sessionID int64, parentConversationID *int64, currentQuestion, model string, withContent bool, fork *ForkContext) (ContextData, ContextReport, error) {
+	contextData := ContextData{
+		Recent:  []QAPair{},
+		Pinned:  []QAPair{},
+		History: []QAPair{},
+		Current: currentQuestion,
+	}
+	budgetConf := global.Config.Ai.ContextBudget
+	report := ContextReport{
+		Model:         model,
+		Budget:        budgetConf.For(model),
+		CurrentTokens: ai_service.EstimateTokens(currentQuestion),
+	}
+
+	// 1. 短期记忆：从指定conversation往上追溯，最近的优先
+	// 分叉时只沿父对话的祖先链追溯，从会话根部分叉没有短期记忆
+	var recentConversations []models.ConversationModel
+	if fork == nil || parentConversationID != nil {
+		var err error
+		recentConversations, err = getRecentConversationsFromConversation(sessionID, parentConversationID)
+		if err != nil {
+			return contextData, report, fmt.Errorf("构建短期上下文失败: %v", err)
+		}
+	}
+	recentSource := SourceAncestor
+	if parentConversationID == nil {
+		recentSource = SourceLatest
+	}
+	var recent []*contextCandidate
+	included := map[int64]bool{}
+	for _, conv := range recentConversations {
+		recent = append(recent, newContextCandidate(conv, SectionRecent, recentSource))
+		included[conv.ID] = true
+	}
+
+	// 2. 固定的对话：可以来自任意分支，已在 recent 中的不再重复
+	pinnedConversations, err := GetPinnedConversations(sessionID)
+	if err != nil {
+		return contextData, report, fmt.Errorf("获取固定对话失败: %v", err)
+	}
+	var pinned []*contextCandidate
+	for _, conv := range pinnedConversations {
+		if !included[conv.ID] && !fork.excludes(conv.ID) {
+			pinned = append(pinned, newContextCandidate(conv, SectionPinned, SourcePinned))
+			included[conv.ID] = true
+		}
+	}
+
+	// 3. 长期记忆：向量和关键词检索相关历史，已在上面出现的不再重复
+	var history []*contextCandidate
+	historyConversations, err := getLongTermContextConversations(sessionID, currentQuestion)
+	if err != nil {
+		// 长期记忆检索失败不应该影响整个对话流程，只记录错误
+		fmt.Printf("长期记忆检索失败: %v\n", err)
+	}
+	for _, recalled := range historyConversations {
+		if !included[recalled.ID] && !fork.excludes(recalled.ID) {
+			c := newContextCandidate(recalled.ConversationModel, SectionHistory, recalled.Source)
+			c.score = recalled.Score
+			history = append(history, c)
+		}
+	}
+
+	// 4. 分配预算
+	remaining := report.Budget - report.CurrentTokens - contextOverhead
+	if remaining < 0 {
+		remaining = 0
+	}
+	usedPinned := allocate(pinned, remaining)
+	remaining -= usedPinned
+	recentBudget := int(float64(remaining) * budgetConf.Ratio())
+	usedRecent := allocate(recent, recentBudget)
+	historyBudget := remaining - usedRecent
+	usedHistory := allocate(history, historyBudget)
+	usedRecent += upgrade(recent, historyBudget-usedHistory)
+	report.Used = report.CurrentTokens + contextOverhead + usedPinned + usedRecent + usedHistory
+
+	// 5. 输出：recent 按时间正序，pinned 按固定顺序，history 按召回排名
+	for i := len(recent) - 1; i >= 0; i-- {
+		if recent[i].level != LevelDropped {
+			contextData.Recent = append(contextData.Recent, recent[i].pair())
+		}
+	}
+	for _, c := range pinned {
+		if c.level != LevelDropped {
+			contextData.Pinned = append(contextData.Pinned, c.pair())
+		}
+	}
+	for _, c := range history {
+		if c.level != LevelDropped {
+			contextData.History = append(contextData.History, c.pair())
+		}
+	}
+	all := append(append(recent, pinned...), history...)
+	for _, c := range all {
+		item := ContextItem{
+			ConversationID: c.conv.ID,
+			Section:        c.section,
+			Source:         c.source,
+			Score:          c.score,
+			Level:          c.level,
+			Tokens:         c.tokens(),
+		}
+		if withContent && c.level != LevelDropped {
+			pair := c.pair()
+			item.Q, item.A = pair.Q, pair.A
+		}
+		report.Items = append(report.Items, item)
+	}
+
+	return contextData, report, nil
+}
+
+// truncateTokens 按 token 预算保留首尾、省略中间，按字符截断不会切坏多字节字符
+func truncateTokens(text string, limit int, note string) string {
+	total := ai_service.EstimateTokens(text)
+	if total <= limit {
+		return text
+	}
+	runes := []rune(text)
+	keep := len(runes) * limit / total / 2
+	return fmt.Sprintf("%s...(%s已省略)...%s", string(runes[:keep]), note, string(runes[len(runes)-keep:]))
+}
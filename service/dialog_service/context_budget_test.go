@@ -0,0 +1,237 @@
+package dialog_service
+
+import (
+	"dialogTree/conf"
+	"dialogTree/global"
+	"dialogTree/models"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+// createLongConversations 在一个dialog中创建 n 条回答很长的连续对话，返回按时间正序的ID
+func createLongConversations(t *testing.T, n int) (int64, []int64) {
+	session := models.SessionModel{Tittle: "预算测试"}
+	global.DB.Create(&session)
+	dialog := models.DialogModel{SessionID: session.ID}
+	global.DB.Create(&dialog)
+
+	var ids []int64
+	base := time.Now().Add(-time.Hour)
+	for i := 0; i < n; i++ {
+		conv := models.ConversationModel{
+			Model:     models.Model{CreatedAt: base.Add(time.Duration(i) * time.Minute)},
+			SessionID: session.ID,
+			DialogID:  dialog.ID,
+			Prompt:    "问题",
+			Answer:    strings.Repeat("很长的中文回答", 300),
+			Summary:   "简短摘要",
+		}
+		if err := global.DB.Create(&conv).Error; err != nil {
+			t.Fatalf("创建对话失败: %v", err)
+		}
+		ids = append(ids, conv.ID)
+	}
+	return session.ID, ids
+}
+
+// TestAssembleContextBudget 预算不足时从旧到新依次降级为摘要，直至省略
+func TestAssembleContextBudget(t *testing.T) {
+	oldConfig := global.Config
+	defer func() { global.Config = oldConfig }()
+	global.DB = setupTestDB(t)
+
+	sessionID, ids := createLongConversations(t, 3)
+	latest := ids[2]
+
+	assemble := func(budget int) (ContextData, ContextReport) {
+		global.Config = &conf.Config{Ai: conf.Ai{
+			ContextLayers: 10,
+			ContextBudget: conf.ContextBudget{Models: map[string]int{"test-model": budget}},
+		}}
+		data, report, err := AssembleContext(sessionID, &latest, "新问题", "test-model")
+		if err != nil {
+			t.Fatalf("拼装上下文失败: %v", err)
+		}
+		return data, report
+	}
+
+	levels := func(report ContextReport) map[int64]string {
+		m := map[int64]string{}
+		for _, item := range report.Items {
+			m[item.ConversationID] = item.Level
+		}
+		return m
+	}
+
+	t.Run("预算充足时保留完整回答", func(t *testing.T) {
+		data, report := assemble(100000)
+		if len(data.Recent) != 3 || len(report.Elided()) != 0 {
+			t.Errorf("应该完整保留3条，实际 recent=%d elided=%v", len(data.Recent), report.Elided())
+		}
+	})
+
+	t.Run("预算有限时旧对话降级为摘要", func(t *testing.T) {
+		data, report := assemble(3000)
+		l := levels(report)
+		if l[ids[2]] != LevelFull || l[ids[0]] != LevelSummary {
+			t.Errorf("最新对话应完整保留、最早对话应降级为摘要，实际：%v", l)
+		}
+		if report.Used > report.Budget {
+			t.Errorf("占用 %d 超出预算 %d", report.Used, report.Budget)
+		}
+		if len(data.Recent) != 3 || data.Recent[0].A != "简短摘要" {
+			t.Errorf("recent 应按时间正序且最早一条为摘要：%+v", data.Recent[0])
+		}
+	})
+
+	t.Run("预算极小时全部省略", func(t *testing.T) {
+		data, report := assemble(30)
+		if len(data.Recent) != 0 || len(report.Elided()) != 3 {
+			t.Errorf("应全部省略，实际 recent=%d", len(data.Recent))
+		}
+		if data.Current != "新问题" {
+			t.Error("当前问题必须保留")
+		}
+	})
+}
+
+// TestAssembleContextPinned 固定的对话可以来自其他分支，单独放在 pinned 中，已在 recent 中的不重复
+func TestAssembleContextPinned(t *testing.T) {
+	oldConfig := global.Config
+	defer func() { global.Config = oldConfig }()
+	global.Config = &conf.Config{Ai: conf.Ai{ContextLayers: 10}}
+	global.DB = setupTestDB(t)
+
+	sessionID, ids := createLongConversations(t, 2)
+	latest := ids[1]
+
+	// 另一个分支中的对话
+	branch := models.DialogModel{SessionID: sessionID}
+	global.DB.Create(&branch)
+	other := models.ConversationModel{SessionID: sessionID, DialogID: branch.ID, Prompt: "约定", Answer: "以后都用英文回答", IsPinned: true}
+	global.DB.Create(&other)
+	global.DB.Model(&models.ConversationModel{}).Where("id = ?", ids[0]).Update("is_pinned", true)
+
+	data, report, err := AssembleContext(sessionID, &latest, "新问题", "test-model")
+	if err != nil {
+		t.Fatalf("拼装上下文失败: %v", err)
+	}
+	if len(data.Pinned) != 1 || data.Pinned[0].A != "以后都用英文回答" {
+		t.Errorf("pinned 应只包含其他分支的固定对话：%+v", data.Pinned)
+	}
+	if len(data.Recent) != 2 {
+		t.Errorf("recent 应保留祖先链上的2条，实际 %d", len(data.Recent))
+	}
+	for _, item := range report.Items {
+		if item.ConversationID == other.ID && (item.Section != SectionPinned || item.Source != SourcePinned) {
+			t.Errorf("固定对话的报告条目不正确：%+v", item)
+		}
+	}
+}
+
+// TestTruncateTokensKeepsRunes 截断不会切坏多字节字符
+func TestTruncateTokensKeepsRunes(t *testing.T) {
+	text := strings.Repeat("中文English混合", 200)
+	got := truncateTokens(text, 50, "中间部分")
+	if !utf8.ValidString(got) {
+		t.Error("截断结果不是合法的UTF-8")
+	}
+	if !strings.Contains(got, "中间部分已省略") {
+		t.Errorf("应该标注省略：%s", got)
+	}
+}
+
+// createForkScenario 会话根部的对话、它的后续对话和一次重新生成的回答，根部对话被固定且能被关键词召回
+func createForkScenario(t *testing.T) (sessionID int64, root models.ConversationModel) {
+	global.Config = &conf.Config{Ai: conf.Ai{ContextLayers: 10}, Vector: conf.Vector{Keyword: true, TopK: 3}}
+	global.DB = setupTestDB(t)
+
+	session := models.SessionModel{Tittle: "分叉测试"}
+	global.DB.Create(&session)
+	dialog := models.DialogModel{SessionID: session.ID}
+	global.DB.Create(&dialog)
+	root = models.ConversationModel{SessionID: session.ID, DialogID: dialog.ID, Prompt: "介绍一下向量检索", Answer: "原来的回答", IsPinned: true}
+	global.DB.Create(&root)
+	next := models.ConversationModel{SessionID: session.ID, DialogID: dialog.ID, Prompt: "继续介绍向量检索", Answer: "原分支的后续回答", ParentConversationID: &root.ID}
+	global.DB.Create(&next)
+	altDialog := models.DialogModel{SessionID: session.ID}
+	global.DB.Create(&altDialog)
+	alt := models.ConversationModel{SessionID: session.ID, DialogID: altDialog.ID, Prompt: root.Prompt, Answer: "上次重新生成的回答", RegeneratedFromID: &root.ID}
+	global.DB.Create(&alt)
+	return session.ID, root
+}
+
+// assertForkContextExcludes 上下文中不应出现被替换的对话及其后续
+func assertForkContextExcludes(t *testing.T, data ContextData) {
+	t.Helper()
+	for _, pairs := range [][]QAPair{data.Recent, data.Pinned, data.History} {
+		for _, pair := range pairs {
+			switch pair.A {
+			case "原来的回答", "原分支的后续回答", "上次重新生成的回答":
+				t.Errorf("上下文不应包含被替换的对话：%+v", data)
+			}
+		}
+	}
+}
+
+// TestForkContextRegenerateRoot 重新生成会话根部的对话时没有短期记忆，原回答及其后续不会作为固定或召回的对话出现
+func TestForkContextRegenerateRoot(t *testing.T) {
+	oldConfig := global.Config
+	defer func() { global.Config = oldConfig }()
+	sessionID, root := createForkScenario(t)
+
+	// 不排除时原回答会被召回
+	data, _, err := AssembleContext(sessionID, nil, root.Prompt, "test-model")
+	if err != nil {
+		t.Fatalf("拼装上下文失败: %v", err)
+	}
+	if len(data.Recent) == 0 {
+		t.Fatal("普通对话没有父对话时应取会话中最新的对话")
+	}
+
+	target, err := GetRegenerateTarget(root.ID)
+	if err != nil {
+		t.Fatalf("获取重新生成目标失败: %v", err)
+	}
+	if target.ParentConversationID != nil {
+		t.Fatalf("根部对话不应有父对话：%v", *target.ParentConversationID)
+	}
+	fork, err := NewForkContext(root.ID)
+	if err != nil {
+		t.Fatalf("获取上下文范围失败: %v", err)
+	}
+	data, _, err = AssembleForkContext(sessionID, target.ParentConversationID, root.Prompt, "test-model", fork)
+	if err != nil {
+		t.Fatalf("拼装上下文失败: %v", err)
+	}
+	if len(data.Recent) != 0 {
+		t.Errorf("从会话根部分叉不应有短期记忆：%+v", data.Recent)
+	}
+	assertForkContextExcludes(t, data)
+}
+
+// TestForkContextEditRoot 修改第一个问题后重新发送时，原分支不会进入新分支的上下文
+func TestForkContextEditRoot(t *testing.T) {
+	oldConfig := global.Config
+	defer func() { global.Config = oldConfig }()
+	sessionID, root := createForkScenario(t)
+
+	conv, parentID, err := GetForkPoint(root.ID)
+	if err != nil {
+		t.Fatalf("获取分叉点失败: %v", err)
+	}
+	fork, err := NewForkContext(conv.ID)
+	if err != nil {
+		t.Fatalf("获取上下文范围失败: %v", err)
+	}
+	data, _, err := AssembleForkContext(sessionID, parentID, "重新介绍一下向量检索", "test-model", fork)
+	if err != nil {
+		t.Fatalf("拼装上下文失败: %v", err)
+	}
+	if len(data.Recent) != 0 {
+		t.Errorf("从会话根部分叉不应有短期记忆：%+v", data.Recent)
+	}
+	assertForkContextExcludes(t, data)
+}
@@ -0,0 +1,259 @@
+// Path: ./service/dialog_service/conversation_service.go
+
+package dialog_service
+
+import (
+	"dialogTree/global"
+	"dialogTree/models"
+	"dialogTree/service/ai_service"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// ConversationRecord 一轮问答的保存参数
+type ConversationRecord struct {
+	SessionID            int64
+	ParentConversationID *int64 // 为空时在会话根部创建新的对话分支
+	Prompt               string
+	Answer               string
+	SummaryRaw           string // 模型输出的标题和摘要，解析失败时在后台补全
+	Provider             string
+	Model                string
+	Usage                ai_service.Usage
+	RegeneratedFromID    *int64 // 重新生成的回答指向最初的那条对话
+}
+
+// ResolveDialogForConversation 确定新对话应写入的dialog，必要时创建分叉
+// 返回的 isRoot 表示在会话根部新建了dialog
+func ResolveDialogForConversation(sessionID int64, parentConversationID *int64) (dialogID int64, isRoot bool, err error) {
+	err = WithSessionLock(func(tx *gorm.DB) error {
+		dialogID, isRoot, err = resolveDialog(tx, sessionID, parentConversationID)
+		return err
+	}, sessionID)
+	return
+}
+
+// resolveDialog 在会话锁和事务内确定新对话应写入的dialog
+func resolveDialog(tx *gorm.DB, sessionID int64, parentConversationID *int64) (dialogID int64, isRoot bool, err error) {
+	if parentConversationID == nil {
+		// 没有指定父conversation，在会话根部创建新的对话分支
+		dialog := models.DialogModel{
+			SessionID: sessionID,
+			ParentID:  nil,
+		}
+		if err := tx.Create(&dialog).Error; err != nil {
+			return 0, false, fmt.Errorf("创建对话节点失败: %v", err)
+		}
+		return dialog.ID, true, nil
+	}
+
+	// 指定了父conversation，需要检查是否分叉
+	parentConv := &models.ConversationModel{}
+	if err := tx.First(parentConv, *parentConversationID).Error; err != nil {
+		return 0, false, fmt.Errorf("找不到父conversation: %v", err)
+	}
+
+	needsBranching, err := checkIfBranching(tx, *parentConversationID)
+	if err != nil {
+		return 0, false, fmt.Errorf("检查分叉失败: %v", err)
+	}
+	if needsBranching {
+		// 需要分叉：创建分叉dialogs
+		newDialogID, _, err := createBranchingDialogs(tx, sessionID, *parentConversationID, parentConv.DialogID)
+		if err != nil {
+			return 0, false, fmt.Errorf("创建分叉失败: %v", err)
+		}
+		return newDialogID, false, nil
+	}
+
+	// 找一下父 dialog 是否有子 dialog
+	var childCount int64
+	err = tx.Model(&models.DialogModel{}).Where("parent_id = ?", parentConv.DialogID).Count(&childCount).Error
+	if err != nil {
+		return 0, false, fmt.Errorf("数据库查询失败: %v", err)
+	}
+	if childCount == 0 {
+		// 如果没有别的子节点，则不需要分叉：直接在当前dialog中添加conversation
+		return parentConv.DialogID, false, nil
+	}
+
+	// 找到了父 dialog 的其他子节点，则需要新建一个 dialog
+	newDialog := models.DialogModel{
+		SessionID:                parentConv.SessionID,
+		ParentID:                 &parentConv.DialogID,
+		BranchFromConversationID: &parentConv.ID,
+	}
+	if err := tx.Create(&newDialog).Error; err != nil {
+		return 0, false, fmt.Errorf("创建dialog失败: %v", err)
+	}
+	return newDialog.ID, false, nil
+}
+
+// SaveConversation 保存一轮问答：确定所在dialog、写入记录、更新会话信息并异步向量化
+func SaveConversation(rec ConversationRecord) (*models.ConversationModel, error) {
+	// marker 策略为纯文本摘要，separate 策略为 JSON 格式的标题和摘要
+	title, summary := ai_service.ParseSummary(rec.SummaryRaw)
+
+	// 向量与对话在同一个数据库时，向量随对话一起提交
+	pending := prepareTxVector(rec.Prompt, rec.Answer, summary)
+
+	var conversation models.ConversationModel
+	var isNewSession bool
+	// 确定dialog和写入记录在同一个会话锁和事务内完成，避免并发保存时分叉错乱
+	err := WithSessionLock(func(tx *gorm.DB) error {
+		dialogID, isRoot, err := resolveDialog(tx, rec.SessionID, rec.ParentConversationID)
+		if err != nil {
+			return err
+		}
+		isNewSession = isRoot
+
+		conversation = models.ConversationModel{
+			Prompt:    rec.Prompt,
+			Answer:    rec.Answer,
+			SessionID: rec.SessionID,
+			DialogID:  dialogID,
+			Title:     title,
+			Summary:   summary,
+			Provider:  rec.Provider,
+			ModelName: rec.Model,
+			IsStarred: false,
+			Comment:   "",
+
+			PromptTokens:     rec.Usage.PromptTokens,
+			CompletionTokens: rec.Usage.CompletionTokens,
+			Cost:             ai_service.CostOf(rec.Model, rec.Usage),
+			UsageEstimated:   rec.Usage.Estimated,
+
+			ParentConversationID: rec.ParentConversationID,
+			RegeneratedFromID:    rec.RegeneratedFromID,
+		}
+		if err := tx.Create(&conversation).Error; err != nil {
+			return fmt.Errorf("创建对话记录失败: %v", err)
+		}
+		if pending != nil {
+			return pending.store(tx, conversation)
+		}
+		return nil
+	}, rec.SessionID)
+	if err != nil {
+		return nil, err
+	}
+	dialogID := conversation.DialogID
+
+	// 未能提取标题或摘要（格式不符、单独摘要失败或回答被停止）时在后台补全
+	if (title == "" || summary == "") && rec.Answer != "" {
+		ResummarizeAsync(conversation.ID)
+	}
+
+	// 如果是新会话的第一条对话，更新会话信息
+	// 重新生成或修改会话根部的对话时会话已有根dialog，保持原来的根dialog和摘要
+	if isNewSession {
+		var currentSession models.SessionModel
+		if err := global.DB.First(&currentSession, rec.SessionID).Error; err != nil {
+			logrus.Errorf("查询会话信息失败: %v", err)
+		} else {
+			updates := map[string]interface{}{}
+			if currentSession.RootDialogID == nil {
+				updates["summary"] = summary
+				updates["root_dialog_id"] = &dialogID
+			}
+			// 仅当现有标题为空时才更新标题
+			if currentSession.Tittle == "" && title != "" {
+				updates["tittle"] = title
+			}
+			if len(updates) > 0 {
+				if err := global.DB.Model(&models.SessionModel{}).Where("id = ?", rec.SessionID).Updates(updates).Error; err != nil {
+					logrus.Errorf("更新会话信息失败: %v", err)
+				}
+			}
+		}
+	}
+
+	// 异步处理向量化存储
+	if global.Config.Vector.Enable && pending == nil {
+		go func() {
+			if err := StoreConversationVector(conversation.ID, rec.Prompt, rec.Answer, summary); err != nil {
+				logrus.Errorf("向量化存储失败: %v", err)
+			}
+		}()
+	}
+
+	// 更新 session 时间
+	err = global.DB.Model(&models.SessionModel{}).
+		Where("id = ?", rec.SessionID).
+		Update("updated_at", time.Now()).Error
+	if err != nil {
+		logrus.Errorf("更新session时间失败: %v", err)
+	}
+
+	return &conversation, nil
+}
+
+// RegenerateTarget 重新生成一条对话的回答所需的信息
+type RegenerateTarget struct {
+	Conversation         models.ConversationModel
+	ParentConversationID *int64 // 原对话的父对话，为空表示原对话位于会话根部
+	OriginalID           int64  // 最初的那条对话，多次重新生成时都指向它
+}
+
+// GetRegenerateTarget 找到原对话的父对话，新回答作为原对话的兄弟分支保存
+func GetRegenerateTarget(conversationID int64) (*RegenerateTarget, error) {
+	conv, parentID, err := GetForkPoint(conversationID)
+	if err != nil {
+		return nil, err
+	}
+
+	target := &RegenerateTarget{Conversation: conv, ParentConversationID: parentID, OriginalID: conv.ID}
+	if conv.RegeneratedFromID != nil {
+		target.OriginalID = *conv.RegeneratedFromID
+	}
+	return target, nil
+}
+
+// NewForkContext 从指定对话的父对话分出新分支时的上下文范围
+// 排除被替换的对话所在的重新生成组（最初的对话和它的各个重新生成的回答），以及它们之后的全部对话和分支
+func NewForkContext(conversationID int64) (*ForkContext, error) {
+	var conv models.ConversationModel
+	if err := global.DB.First(&conv, conversationID).Error; err != nil {
+		return nil, fmt.Errorf("对话不存在: %v", err)
+	}
+	originalID := conv.ID
+	if conv.RegeneratedFromID != nil {
+		originalID = *conv.RegeneratedFromID
+	}
+
+	var group []models.ConversationModel
+	if err := global.DB.Where("id = ? OR regenerated_from_id = ?", originalID, originalID).
+		Order("id ASC").Find(&group).Error; err != nil {
+		return nil, err
+	}
+	fork := &ForkContext{Excluded: map[int64]bool{conv.ID: true}}
+	for _, c := range group {
+		d, err := collectDescendants(global.DB, c)
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range d.convIDs {
+			fork.Excluded[id] = true
+		}
+	}
+	return fork, nil
+}
+
+// GetForkPoint 返回对话及其父对话ID，用于从该对话的父对话分出新分支
+// 父对话ID为空表示原对话位于会话根部，新分支从会话根部开始
+func GetForkPoint(conversationID int64) (models.ConversationModel, *int64, error) {
+	var conv models.ConversationModel
+	if err := global.DB.First(&conv, conversationID).Error; err != nil {
+		return conv, nil, fmt.Errorf("对话不存在: %v", err)
+	}
+
+	// 找不到父对话说明原对话位于会话根部
+	if parent, err := findParentConversation(conv); err == nil {
+		return conv, &parent.ID, nil
+	}
+	return conv, nil, nil
+}
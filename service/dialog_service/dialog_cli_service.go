@@ -3,11 +3,15 @@
 package dialog_service
 
 import (
+	"context"
 	"dialogTree/global"
 	"dialogTree/models"
-	"dialogTree/service/ai_service/chat_anywhere"
+	"dialogTree/service/ai_service"
 	"fmt"
+	"io"
 	"strings"
+
+	"gorm.io/gorm"
 )
 
 // CliDialogService CLI 对话服务
@@ -98,7 +102,24 @@ func (s *CliDialogService) ProcessDialogMessage(sessionID int64, parentDialogID
 		parentConversationID = &parentConv.ID
 	}
 
-	contextJSON, err := BuildDialogContextFromConversation(sessionID, parentConversationID, content)
+	// 使用会话角色的默认提供商、模型和角色说明
+	var session models.SessionModel
+	if err := global.DB.First(&session, sessionID).Error; err != nil {
+		return fmt.Errorf("会话不存在: %v", err)
+	}
+	persona, err := GetSessionPersona(session)
+	if err != nil {
+		return fmt.Errorf("获取会话角色失败: %v", err)
+	}
+	var usage ai_service.Usage
+	providerName, opts := ApplyPersona(persona, "", ai_service.ChatOptions{Usage: &usage})
+
+	provider, err := ai_service.ResolveProvider(providerName)
+	if err != nil {
+		return fmt.Errorf("AI提供商不可用: %v", err)
+	}
+
+	contextJSON, _, err := BuildDialogContextForModel(sessionID, parentConversationID, content, ai_service.ModelOf(provider, opts))
 	if err != nil {
 		return fmt.Errorf("构建上下文失败: %v", err)
 	}
@@ -107,7 +128,7 @@ func (s *CliDialogService) ProcessDialogMessage(sessionID int64, parentDialogID
 	fullMessage := contextJSON
 
 	// 调用AI
-	msgChan, sumChan, err := chat_anywhere.ChatStreamSum(fullMessage)
+	msgChan, sumChan, answerer, err := ai_service.ChatStreamSumWithFailover(context.Background(), fullMessage, provider, opts)
 	if err != nil {
 		return fmt.Errorf("AI服务调用失败: %v", err)
 	}
@@ -128,7 +149,7 @@ func (s *CliDialogService) ProcessDialogMessage(sessionID int64, parentDialogID
 	}
 
 	// 保存对话记录
-	err = s.SaveDialogRecord(sessionID, parentDialogID, content, fullAnswer.String(), summary)
+	err = s.SaveDialogRecord(sessionID, parentDialogID, content, fullAnswer.String(), summary, answerer, usage)
 	if err != nil {
 		fmt.Printf("保存对话失败: %v\n", err)
 	}
@@ -136,63 +157,125 @@ func (s *CliDialogService) ProcessDialogMessage(sessionID int64, parentDialogID
 	return nil
 }
 
+// ChatOnce 基于指定的父对话完成一轮问答，回答实时写入 out，保存后返回对话记录
+// 与 Web 端使用相同的分叉逻辑，用于 CLI 执行模板等需要接续对话的场景
+func (s *CliDialogService) ChatOnce(ctx context.Context, sessionID int64, parentConversationID *int64, prompt string, provider ai_service.ChatProvider, opts ai_service.ChatOptions, out io.Writer) (*models.ConversationModel, error) {
+	contextJSON, _, err := BuildDialogContextForModel(sessionID, parentConversationID, prompt, ai_service.ModelOf(provider, opts))
+	if err != nil {
+		return nil, fmt.Errorf("构建上下文失败: %v", err)
+	}
+
+	var usage ai_service.Usage
+	opts.Usage = &usage
+	msgChan, sumChan, answerer, err := ai_service.ChatStreamSumWithFailover(ctx, contextJSON, provider, opts)
+	if err != nil {
+		return nil, fmt.Errorf("AI服务调用失败: %v", err)
+	}
+
+	var answer strings.Builder
+	for chunk := range msgChan {
+		fmt.Fprint(out, chunk)
+		answer.WriteString(chunk)
+	}
+	fmt.Fprintln(out)
+
+	var summary string
+	for s := range sumChan {
+		summary += s
+	}
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	return SaveConversation(ConversationRecord{
+		SessionID:            sessionID,
+		ParentConversationID: parentConversationID,
+		Prompt:               prompt,
+		Answer:               answer.String(),
+		SummaryRaw:           summary,
+		Provider:             answerer.Provider,
+		Model:                answerer.Model,
+		Usage:                usage,
+	})
+}
+
 // SaveDialogRecord 保存对话记录
-func (s *CliDialogService) SaveDialogRecord(sessionID int64, parentDialogID *int64, prompt, answer, summaryRaw string) error {
-	// 简化的摘要处理
-	var title, summary string
-	if summaryRaw != "" {
-		// 这里可以添加JSON解析逻辑，简化版本直接使用
-		title = "CLI对话"
-		summary = prompt[:min(100, len(prompt))]
-	} else {
-		title = "对话"
+func (s *CliDialogService) SaveDialogRecord(sessionID int64, parentDialogID *int64, prompt, answer, summaryRaw string, answerer ai_service.Answerer, usage ai_service.Usage) error {
+	// 优先使用 AI 生成的标题和摘要，缺失时用问题截取
+	title, summary := ai_service.ParseSummary(summaryRaw)
+	if summary == "" {
 		summary = prompt[:min(50, len(prompt))]
 	}
+	if title == "" {
+		title = "CLI对话"
+	}
 
-	// 创建或获取 Dialog
+	// 创建 Dialog 和会话记录，与其他对话树修改互斥
 	var dialogID int64
 	var isNewSession bool
+	var conversation models.ConversationModel
+	pending := prepareTxVector(prompt, answer, summary)
+
+	err := WithSessionLock(func(tx *gorm.DB) error {
+		var parentConversationID *int64
+		if parentDialogID == nil {
+			// 创建新的根对话
+			dialog := models.DialogModel{
+				SessionID: sessionID,
+				ParentID:  nil,
+			}
+			if err := tx.Create(&dialog).Error; err != nil {
+				return fmt.Errorf("创建对话节点失败: %v", err)
+			}
+			dialogID = dialog.ID
+			isNewSession = true
+		} else {
+			// 在指定节点创建子对话，接在该节点最新的对话之后
+			var latest models.ConversationModel
+			if err := tx.Where("dialog_id = ?", *parentDialogID).Order("id DESC").Take(&latest).Error; err == nil {
+				parentConversationID = &latest.ID
+			}
+			dialog := models.DialogModel{
+				SessionID:                sessionID,
+				ParentID:                 parentDialogID,
+				BranchFromConversationID: parentConversationID,
+			}
+			if err := tx.Create(&dialog).Error; err != nil {
+				return fmt.Errorf("创建对话节点失败: %v", err)
+			}
+			dialogID = dialog.ID
+		}
 
-	if parentDialogID == nil {
-		// 创建新的根对话
-		dialog := models.DialogModel{
+		// 创建会话记录
+		conversation = models.ConversationModel{
+			Prompt:    prompt,
+			Answer:    answer,
 			SessionID: sessionID,
-			ParentID:  nil,
+			DialogID:  dialogID,
+			Title:     title,
+			Summary:   summary,
+			Provider:  answerer.Provider,
+			ModelName: answerer.Model,
+			IsStarred: false,
+			Comment:   "",
+
+			PromptTokens:     usage.PromptTokens,
+			CompletionTokens: usage.CompletionTokens,
+			Cost:             ai_service.CostOf(answerer.Model, usage),
+			UsageEstimated:   usage.Estimated,
+
+			ParentConversationID: parentConversationID,
 		}
-		err := global.DB.Create(&dialog).Error
-		if err != nil {
-			return fmt.Errorf("创建对话节点失败: %v", err)
-		}
-		dialogID = dialog.ID
-		isNewSession = true
-	} else {
-		// 在指定节点创建子对话
-		dialog := models.DialogModel{
-			SessionID: sessionID,
-			ParentID:  parentDialogID,
+		if err := tx.Create(&conversation).Error; err != nil {
+			return fmt.Errorf("创建会话记录失败: %v", err)
 		}
-		err := global.DB.Create(&dialog).Error
-		if err != nil {
-			return fmt.Errorf("创建对话节点失败: %v", err)
+		if pending != nil {
+			return pending.store(tx, conversation)
 		}
-		dialogID = dialog.ID
-	}
-
-	// 创建会话记录
-	conversation := models.ConversationModel{
-		Prompt:    prompt,
-		Answer:    answer,
-		SessionID: sessionID,
-		DialogID:  dialogID,
-		Title:     title,
-		Summary:   summary,
-		IsStarred: false,
-		Comment:   "",
-	}
-
-	err := global.DB.Create(&conversation).Error
+		return nil
+	}, sessionID)
 	if err != nil {
-		return fmt.Errorf("创建会话记录失败: %v", err)
+		return err
 	}
 
 	// 如果是新会话的第一条对话，更新会话信息
@@ -209,7 +292,7 @@ func (s *CliDialogService) SaveDialogRecord(sessionID int64, parentDialogID *int
 	}
 
 	// 异步处理向量化存储
-	if global.Config.Vector.Enable {
+	if global.Config.Vector.Enable && pending == nil {
 		go func() {
 			err := StoreConversationVector(conversation.ID, prompt, answer, summary)
 			if err != nil {
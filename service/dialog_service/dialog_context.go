@@ -8,15 +8,14 @@ import (
 	"dialogTree/service/embedding_service"
 	"dialogTree/service/vector_service"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"sort"
 	"strings"
 )
 
-// 为了控制每个上下文的最长长度
-const maxLength = 1000
-const headTailLength = 400
-
 // QAPair 问答对结构
 type QAPair struct {
 	Q string `json:"Q"`
@@ -26,6 +25,7 @@ type QAPair struct {
 // ContextData 上下文数据结构
 type ContextData struct {
 	Recent  []QAPair `json:"recent"`
+	Pinned  []QAPair `json:"pinned"`
 	History []QAPair `json:"history"`
 	Current string   `json:"current"`
 }
@@ -144,8 +144,8 @@ func buildShortTermContextFromConversation(sessionID int64, parentConversationID
 // getRecentConversationsAcrossDialogs 跨Dialog获取最近的conversations
 func getRecentConversationsAcrossDialogs(sessionID int64, limit int) ([]models.ConversationModel, error) {
 	var conversations []models.ConversationModel
-	err := global.DB.Where("session_id = ?", sessionID).
-		Order("created_at DESC").
+	err := global.DB.Where("session_id = ? AND is_archived = ?", sessionID, false).
+		Order("id DESC").
 		Limit(limit).
 		Find(&conversations).Error
 	return conversations, err
@@ -156,7 +156,7 @@ func traceParentConversations(dialogID int64, maxLayers int) ([]models.Conversat
 	// 保持向后兼容：从dialog的最新conversation开始追溯
 	var latestConv models.ConversationModel
 	err := global.DB.Where("dialog_id = ?", dialogID).
-		Order("created_at DESC").
+		Order("id DESC").
 		First(&latestConv).Error
 	if err != nil {
 		return nil, err
@@ -198,12 +198,14 @@ func traceParentConversationsFromConversation(conversationID int64, maxLayers in
 	return conversations, nil
 }
 
-// findParentConversation 找到指定conversation的父conversation
-func findParentConversation(conv models.ConversationModel) (*models.ConversationModel, error) {
+// inferParentConversation 按dialog内的对话顺序和分叉点推断父conversation
+// 对话ID自增，按ID排序不受时钟回拨和同一秒内写入的影响
+// 仅用于没有记录 ParentConversationID 的旧数据，以及 backfill-parents 回填
+func inferParentConversation(db *gorm.DB, conv models.ConversationModel) (*models.ConversationModel, error) {
 	// 首先在同一dialog内查找前一个conversation
 	var prevConversation models.ConversationModel
-	err := global.DB.Where("dialog_id = ? AND created_at < ?", conv.DialogID, conv.CreatedAt).
-		Order("created_at DESC").
+	err := db.Where("dialog_id = ? AND id < ?", conv.DialogID, conv.ID).
+		Order("id DESC").
 		First(&prevConversation).Error
 
 	if err == nil {
@@ -214,7 +216,7 @@ func findParentConversation(conv models.ConversationModel) (*models.Conversation
 	// 如果同一dialog内没有更早的conversation，则查找跨dialog的父conversation
 	// 获取当前conversation所在的dialog
 	var currentDialog models.DialogModel
-	err = global.DB.First(&currentDialog, conv.DialogID).Error
+	err = db.First(&currentDialog, conv.DialogID).Error
 	if err != nil {
 		return nil, fmt.Errorf("获取dialog失败: %v", err)
 	}
@@ -227,7 +229,7 @@ func findParentConversation(conv models.ConversationModel) (*models.Conversation
 	// 如果当前dialog有分叉点信息，直接使用分叉点conversation
 	if currentDialog.BranchFromConversationID != nil {
 		var parentConversation models.ConversationModel
-		err = global.DB.First(&parentConversation, *currentDialog.BranchFromConversationID).Error
+		err = db.First(&parentConversation, *currentDialog.BranchFromConversationID).Error
 		if err != nil {
 			return nil, fmt.Errorf("查询分叉点conversation失败: %v", err)
 		}
@@ -237,21 +239,21 @@ func findParentConversation(conv models.ConversationModel) (*models.Conversation
 	// 兼容旧数据：如果没有分叉点信息，使用原来的逻辑
 	// 找到父dialog中的分叉点conversation
 	// 在分叉场景下，我们需要找到分叉发生时的那个conversation
-	// 策略：查找父dialog中在当前dialog创建之前就存在的最新conversation
+	// 策略：查找父dialog中在当前conversation之前就存在的最新conversation
 	var parentConversation models.ConversationModel
 
-	// 首先尝试找到父dialog中在当前dialog创建时间之前的最新conversation
-	err = global.DB.Where("dialog_id = ? AND created_at < ?",
-		*currentDialog.ParentID, currentDialog.CreatedAt).
-		Order("created_at DESC").
+	// 首先尝试找到父dialog中ID小于当前conversation的最新conversation
+	err = db.Where("dialog_id = ? AND id < ?",
+		*currentDialog.ParentID, conv.ID).
+		Order("id DESC").
 		First(&parentConversation).Error
 
 	if err != nil {
 		if err.Error() == "record not found" {
 			// 如果找不到时间在前的conversation，说明当前dialog是从父dialog的最新conversation分叉的
 			// 这种情况下，直接找父dialog的最新conversation
-			err = global.DB.Where("dialog_id = ?", *currentDialog.ParentID).
-				Order("created_at DESC").
+			err = db.Where("dialog_id = ?", *currentDialog.ParentID).
+				Order("id DESC").
 				First(&parentConversation).Error
 			if err != nil {
 				return nil, fmt.Errorf("找不到父conversation: %v", err)
@@ -266,49 +268,13 @@ func findParentConversation(conv models.ConversationModel) (*models.Conversation
 
 // buildLongTermContext 构建长期记忆上下文（向量检索）
 func buildLongTermContext(sessionID int64, currentQuestion string) (string, error) {
-	if !global.Config.Vector.Enable {
-		return "", nil
-	}
-
-	// 1. 对当前问题进行向量化
-	questionVector, err := embedding_service.GetEmbedding(currentQuestion)
+	conversations, err := getLongTermContextConversations(sessionID, currentQuestion)
 	if err != nil {
-		return "", fmt.Errorf("问题向量化失败: %v", err)
-	}
-
-	// 2. 在向量数据库中检索相似的历史对话
-	filter := map[string]interface{}{
-		"session_id": sessionID,
-	}
-
-	results, err := vector_service.VectorServiceInstance.Search(
-		questionVector,
-		global.Config.Vector.TopK,
-		filter,
-	)
-	if err != nil {
-		return "", fmt.Errorf("向量检索失败: %v", err)
-	}
-
-	if len(results) == 0 {
-		return "", nil
+		return "", err
 	}
 
-	// 3. 构建长期记忆上下文
 	var contextLines []string
-	for _, result := range results {
-		// 从向量数据库元数据中获取 conversation_id
-		conversationID := result.ID // result.ID 已经是 uint64 类型的 conversation_id
-
-		// 从主数据库中查询对应的 ConversationModel
-		var conversation models.ConversationModel
-		err := global.DB.First(&conversation, conversationID).Error
-		if err != nil {
-			// 如果找不到对应的 conversation，记录错误并跳过
-			fmt.Printf("Warning: Conversation with ID %d not found in DB: %v\n", conversationID, err)
-			continue
-		}
-
+	for _, conversation := range conversations {
 		// 使用从数据库中获取的 prompt 和 summary
 		contextLines = append(contextLines, fmt.Sprintf("历史相关问题: %s", conversation.Prompt))
 		contextLines = append(contextLines, fmt.Sprintf("回答要点: %s", conversation.Summary))
@@ -335,25 +301,78 @@ func StoreConversationVector(conversationID int64, prompt, answer, summary strin
 		return fmt.Errorf("获取对话信息失败: %v", err)
 	}
 
-	// 2. 对问题和回答进行向量化（这里选择对问题进行向量化，因为检索时主要是基于问题的相似性）
-	questionVector, err := embedding_service.GetEmbedding(prompt)
+	// 2. 分别对问题、摘要和分段的回答进行向量化，答案中的知识也能被召回
+	parts, err := embedConversation(prompt, answer, summary)
+	if err != nil {
+		return err
+	}
+
+	// 3. 每段存为一个点，点ID由对话ID和槽位组成
+	for _, point := range conversationPoints(conversation, parts) {
+		if err := vector_service.VectorServiceInstance.Store(point.ID, point.Vector, point.Payload); err != nil {
+			return fmt.Errorf("向量存储失败: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// storeSummaryVector 补全摘要后写入摘要的向量，问题和回答的向量保持不变
+func storeSummaryVector(conversation models.ConversationModel, summary string) error {
+	if !global.Config.Vector.Enable || strings.TrimSpace(summary) == "" {
+		return nil
+	}
+	vector, err := embedding_service.GetEmbedding(summary)
 	if err != nil {
-		return fmt.Errorf("问题向量化失败: %v", err)
+		return fmt.Errorf("摘要向量化失败: %v", err)
+	}
+	point := conversationPoints(conversation, []vectorPart{{kind: VectorKindSummary, slot: pointSlotSummary, vector: vector}})[0]
+	if err := vector_service.VectorServiceInstance.Store(point.ID, point.Vector, point.Payload); err != nil {
+		return fmt.Errorf("向量存储失败: %v", err)
 	}
+	return nil
+}
 
-	// 3. 准备元数据
-	metadata := map[string]interface{}{
-		"conversation_id": conversationID,
+// vectorMetadata 对话在向量库中的元数据
+func vectorMetadata(conversation models.ConversationModel) map[string]interface{} {
+	return map[string]interface{}{
+		"conversation_id": conversation.ID,
 		"session_id":      conversation.SessionID,
 		"dialog_id":       conversation.DialogID,
 	}
+}
 
-	// 4. 存储到向量数据库，ID使用uint64类型
-	err = vector_service.VectorServiceInstance.Store(uint64(conversationID), questionVector, metadata)
+// pendingVector 在保存对话之前生成的向量，随对话在同一个事务中写入
+type pendingVector struct {
+	service vector_service.TxVectorService
+	parts   []vectorPart
+}
+
+// prepareTxVector 向量库支持事务写入时，在开启事务前生成问题、摘要和回答的向量
+// 返回 nil 表示保存后仍按原方式异步写入向量
+func prepareTxVector(prompt, answer, summary string) *pendingVector {
+	if !global.Config.Vector.Enable {
+		return nil
+	}
+	service, ok := vector_service.VectorServiceInstance.(vector_service.TxVectorService)
+	if !ok {
+		return nil
+	}
+	parts, err := embedConversation(prompt, answer, summary)
 	if err != nil {
-		return fmt.Errorf("向量存储失败: %v", err)
+		logrus.Warnf("%v，保存后重试", err)
+		return nil
 	}
+	return &pendingVector{service: service, parts: parts}
+}
 
+// store 在保存对话的事务中写入向量
+func (p *pendingVector) store(tx *gorm.DB, conversation models.ConversationModel) error {
+	for _, point := range conversationPoints(conversation, p.parts) {
+		if err := p.service.StoreTx(tx, point.ID, point.Vector, point.Payload); err != nil {
+			return fmt.Errorf("向量存储失败: %v", err)
+		}
+	}
 	return nil
 }
 
@@ -363,7 +382,7 @@ func DeleteConversationVector(conversationID int64) error {
 		return nil
 	}
 
-	return vector_service.VectorServiceInstance.Delete(uint64(conversationID))
+	return vector_service.VectorServiceInstance.DeleteBatch(conversationPointIDs(conversationID))
 }
 
 // DeleteSessionVectors 删除整个会话的所有向量
@@ -381,7 +400,7 @@ func DeleteSessionVectors(sessionID int64) error {
 
 	// 逐一删除向量
 	for _, conv := range conversations {
-		err := vector_service.VectorServiceInstance.Delete(uint64(conv.ID))
+		err := vector_service.VectorServiceInstance.DeleteBatch(conversationPointIDs(conv.ID))
 		if err != nil {
 			// 记录错误但继续删除其他向量
 			fmt.Printf("删除向量失败 %d: %v\n", conv.ID, err)
@@ -395,7 +414,7 @@ func DeleteSessionVectors(sessionID int64) error {
 func FindParentConversation(parentDialogID int64) (*models.ConversationModel, error) {
 	var conversation models.ConversationModel
 	err := global.DB.Where("dialog_id = ?", parentDialogID).
-		Order("created_at DESC").
+		Order("id DESC").
 		First(&conversation).Error
 	if err != nil {
 		return nil, fmt.Errorf("找不到父节点conversation: %v", err)
@@ -420,7 +439,7 @@ func CheckIfBranching(sessionID int64, parentDialogID *int64) (bool, *models.Con
 	// 找到当前dialog中最新的conversation
 	var latestConv models.ConversationModel
 	err = global.DB.Where("dialog_id = ?", *parentDialogID).
-		Order("created_at DESC").
+		Order("id DESC").
 		First(&latestConv).Error
 	if err != nil {
 		return false, nil, fmt.Errorf("获取最新conversation失败: %v", err)
@@ -432,60 +451,38 @@ func CheckIfBranching(sessionID int64, parentDialogID *int64) (bool, *models.Con
 }
 
 // BuildDialogContextFromConversation 根据conversation ID构建对话上下文
-// 这个函数能正确处理分叉场景下的上下文追溯，返回JSON格式
+// 这个函数能正确处理分叉场景下的上下文追溯，返回JSON格式，使用默认 token 预算
 func BuildDialogContextFromConversation(sessionID int64, parentConversationID *int64, currentQuestion string) (string, error) {
-	contextData := ContextData{
-		Recent:  []QAPair{},
-		History: []QAPair{},
-		Current: currentQuestion,
-	}
-
-	// 1. 构建短期记忆上下文（从指定conversation往上追溯）
-	recentConversations, err := getRecentConversationsFromConversation(sessionID, parentConversationID)
-	if err != nil {
-		return "", fmt.Errorf("构建短期上下文失败: %v", err)
-	}
+	contextJSON, _, err := BuildDialogContextForModel(sessionID, parentConversationID, currentQuestion, "")
+	return contextJSON, err
+}
 
-	// 将最近对话转换为QAPair格式（按时间正序排列）
-	for i := len(recentConversations) - 1; i >= 0; i-- {
-		conv := recentConversations[i]
-		contextData.Recent = append(contextData.Recent, QAPair{
-			Q: truncateText(conv.Prompt, "提问中间部分"),
-			A: truncateText(conv.Answer, "答案中间部分"), // 使用完整回答
-		})
-	}
+// BuildDialogContextForModel 按模型的 token 预算构建JSON格式的对话上下文，并返回取舍报告
+func BuildDialogContextForModel(sessionID int64, parentConversationID *int64, currentQuestion, model string) (string, ContextReport, error) {
+	return BuildForkContextForModel(sessionID, parentConversationID, currentQuestion, model, nil)
+}
 
-	// 2. 构建长期记忆上下文（向量检索相关历史）
-	historyConversations, err := getLongTermContextConversations(sessionID, currentQuestion)
+// BuildForkContextForModel 按分叉的上下文范围构建JSON格式的对话上下文，fork 为空时与 BuildDialogContextForModel 相同
+func BuildForkContextForModel(sessionID int64, parentConversationID *int64, currentQuestion, model string, fork *ForkContext) (string, ContextReport, error) {
+	contextData, report, err := AssembleForkContext(sessionID, parentConversationID, currentQuestion, model, fork)
 	if err != nil {
-		// 长期记忆检索失败不应该影响整个对话流程，只记录错误
-		fmt.Printf("长期记忆检索失败: %v\n", err)
-	} else {
-		contextData.History = historyConversations
+		return "", report, err
 	}
 
-	// 3. 序列化为JSON
+	// 序列化为JSON
 	jsonData, err := json.Marshal(contextData)
 	if err != nil {
-		return "", fmt.Errorf("JSON序列化失败: %v", err)
+		return "", report, fmt.Errorf("JSON序列化失败: %v", err)
 	}
 
 	logrus.Debug("\n" + strings.Repeat("=", 30) + "上下文拼接开始" + strings.Repeat("=", 30) + "\n")
 	logrus.Debugf("本次Recent: %v+\n", contextData.Recent)
 	logrus.Debugf("本次History: %v+\n", contextData.History)
 	logrus.Debugf("本次Current: %s\n", contextData.Current)
+	logrus.Debugf("本次预算: %d，已用: %d，降级或省略: %v+\n", report.Budget, report.Used, report.Elided())
 	logrus.Debug(strings.Repeat("=", 30) + "上下文拼接结束" + strings.Repeat("=", 30) + "\n")
 
-	return string(jsonData), nil
-}
-
-func truncateText(text, note string) string {
-	if len(text) <= maxLength {
-		return text
-	}
-	head := text[:headTailLength]
-	tail := text[len(text)-headTailLength:]
-	return fmt.Sprintf("%s...(%s已省略)...%s", head, note, tail)
+	return string(jsonData), report, nil
 }
 
 // getRecentConversationsFromConversation 从指定conversation获取最近的对话记录
@@ -504,38 +501,145 @@ func getRecentConversationsFromConversation(sessionID int64, parentConversationI
 	}
 }
 
-// getLongTermContextConversations 获取长期记忆相关对话
-func getLongTermContextConversations(sessionID int64, currentQuestion string) ([]QAPair, error) {
-	if !global.Config.Vector.Enable {
-		return []QAPair{}, nil
+// recalledConversation 长期记忆召回的对话
+type recalledConversation struct {
+	models.ConversationModel
+	Score  float64 // 向量召回的相似度，仅由关键词召回时为 0
+	Source string  // SourceVector/SourceKeyword/SourceHybrid
+}
+
+// 同一对话其他类型的点命中时对融合分数的加成系数
+const fusionBonusWeight = 0.1
+
+// reciprocal rank fusion 的平滑常数
+const rrfK = 60
+
+// getLongTermContextConversations 获取长期记忆相关对话，按召回排名从高到低排列
+// 向量检索和关键词检索各取候选，按 reciprocal rank fusion 合并，某一路失败时使用另一路的结果
+func getLongTermContextConversations(sessionID int64, currentQuestion string) ([]recalledConversation, error) {
+	vectorConf := global.Config.Vector
+	topK := vectorConf.TopK
+	// 每一路多取一些候选，融合后再截取
+	candidates := topK * 2
+
+	var rankings [][]recalledConversation
+	var errs []error
+	if vectorConf.Enable {
+		recalled, err := searchVectorConversations(sessionID, currentQuestion, candidates)
+		if err != nil {
+			errs = append(errs, err)
+		} else {
+			rankings = append(rankings, recalled)
+		}
+	}
+	if vectorConf.Keyword {
+		recalled, err := searchKeywordConversations(sessionID, currentQuestion, candidates)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("关键词检索失败: %v", err))
+		} else {
+			rankings = append(rankings, recalled)
+		}
+	}
+	if len(rankings) == 0 {
+		if len(errs) > 0 {
+			return nil, errors.Join(errs...)
+		}
+		return []recalledConversation{}, nil
+	}
+	for _, err := range errs {
+		logrus.Warnf("长期记忆检索部分失败: %v", err)
+	}
+
+	return fuseRankings(topK, rankings...), nil
+}
+
+// fuseRankings 按 reciprocal rank fusion 合并多路召回结果：每一路排名第 r 的对话得分 1/(rrfK+r)
+// 得分相同时保持先出现的顺序，多路都召回的对话标记为 SourceHybrid
+func fuseRankings(topK int, rankings ...[]recalledConversation) []recalledConversation {
+	scores := map[int64]float64{}
+	merged := map[int64]*recalledConversation{}
+	var order []int64
+	for _, ranking := range rankings {
+		for rank, recalled := range ranking {
+			scores[recalled.ID] += 1 / float64(rrfK+rank+1)
+			existing, ok := merged[recalled.ID]
+			if !ok {
+				r := recalled
+				merged[recalled.ID] = &r
+				order = append(order, recalled.ID)
+				continue
+			}
+			if existing.Source != recalled.Source {
+				existing.Source = SourceHybrid
+			}
+			existing.Score = max(existing.Score, recalled.Score)
+		}
+	}
+
+	sort.SliceStable(order, func(i, j int) bool {
+		return scores[order[i]] > scores[order[j]]
+	})
+	if topK > 0 && len(order) > topK {
+		order = order[:topK]
 	}
+	results := make([]recalledConversation, 0, len(order))
+	for _, id := range order {
+		results = append(results, *merged[id])
+	}
+	return results
+}
 
+// searchVectorConversations 向量检索相关对话，按相似度从高到低排列，低于相似度阈值的点不参与召回
+// 问题、摘要和回答分段分别命中的点按对话合并：取最高分，其他类型的最高分按系数加成
+func searchVectorConversations(sessionID int64, currentQuestion string, limit int) ([]recalledConversation, error) {
 	// 1. 对当前问题进行向量化
 	questionVector, err := embedding_service.GetEmbedding(currentQuestion)
 	if err != nil {
 		return nil, fmt.Errorf("问题向量化失败: %v", err)
 	}
 
-	// 2. 在向量数据库中检索相似的历史对话
+	// 2. 在向量数据库中检索相似的点，每条对话最多占用 pointSlotAnswer+maxAnswerChunks 个点，多取一些候选再合并
 	filter := map[string]interface{}{
 		"session_id": sessionID,
 	}
 
 	results, err := vector_service.VectorServiceInstance.Search(
 		questionVector,
-		global.Config.Vector.TopK,
+		limit*(pointSlotAnswer+maxAnswerChunks),
 		filter,
 	)
 	if err != nil {
 		return nil, fmt.Errorf("向量检索失败: %v", err)
 	}
 
-	// 3. 构建历史对话QAPair列表
-	var historyPairs []QAPair
+	// 3. 按对话合并，记录每种类型的最高分
+	threshold := global.Config.Vector.SimilarityThreshold
+	bestByKind := map[int64]map[string]float64{}
+	var order []int64
 	for _, result := range results {
-		// 从向量数据库元数据中获取 conversation_id (result.ID 已经是 uint64 类型的 conversation_id)
-		conversationID := result.ID
+		// 各向量库对阈值的处理不一致，统一在这里过滤，避免弱相关的对话被当作历史注入
+		if result.Score < threshold {
+			continue
+		}
+		conversationID := conversationOfPoint(result)
+		kind, _ := result.Metadata["kind"].(string)
+		if kind == "" {
+			kind = VectorKindPrompt // 旧数据只有问题向量
+		}
+		scores, ok := bestByKind[conversationID]
+		if !ok {
+			scores = map[string]float64{}
+			bestByKind[conversationID] = scores
+			order = append(order, conversationID)
+		}
+		if score, ok := scores[kind]; !ok || result.Score > score {
+			scores[kind] = result.Score
+		}
+	}
 
+	// 4. 查询历史对话
+	var historyConversations []recalledConversation
+	for _, conversationID := range order {
 		// 从主数据库中查询对应的 ConversationModel
 		var conversation models.ConversationModel
 		err := global.DB.First(&conversation, conversationID).Error
@@ -544,51 +648,77 @@ func getLongTermContextConversations(sessionID int64, currentQuestion string) ([
 			logrus.Warnf("Warning: Conversation with ID %d not found in DB for long-term context: %v\n", conversationID, err)
 			continue
 		}
+		// 归档的分支不参与召回
+		if conversation.IsArchived {
+			continue
+		}
 
-		historyPairs = append(historyPairs, QAPair{
-			Q: conversation.Prompt,
-			A: conversation.Summary,
+		historyConversations = append(historyConversations, recalledConversation{
+			ConversationModel: conversation,
+			Score:             fuseKindScores(bestByKind[conversationID]),
+			Source:            SourceVector,
 		})
 	}
 
-	return historyPairs, nil
+	sort.SliceStable(historyConversations, func(i, j int) bool {
+		return historyConversations[i].Score > historyConversations[j].Score
+	})
+	if limit > 0 && len(historyConversations) > limit {
+		historyConversations = historyConversations[:limit]
+	}
+	return historyConversations, nil
+}
+
+// fuseKindScores 融合同一对话各类型的最高分：最高的一项加上其余各项乘以加成系数
+func fuseKindScores(scores map[string]float64) float64 {
+	var best, sum float64
+	first := true
+	for _, score := range scores {
+		sum += score
+		if first || score > best {
+			best = score
+			first = false
+		}
+	}
+	return best + fusionBonusWeight*(sum-best)
 }
 
 // CheckIfBranchingByConversation 根据conversation ID检测是否需要分叉
 func CheckIfBranchingByConversation(parentConversationID int64) (bool, error) {
+	return checkIfBranching(global.DB, parentConversationID)
+}
+
+// checkIfBranching 在指定的连接或事务中检测是否需要分叉
+func checkIfBranching(db *gorm.DB, parentConversationID int64) (bool, error) {
 	// 获取父conversation
 	var parentConv models.ConversationModel
-	if err := global.DB.First(&parentConv, parentConversationID).Error; err != nil {
+	if err := db.First(&parentConv, parentConversationID).Error; err != nil {
 		return false, fmt.Errorf("找不到父conversation: %v", err)
 	}
 
-	// 找到同一dialog中最新的conversation
-	var latestConv models.ConversationModel
-	err := global.DB.Where("dialog_id = ?", parentConv.DialogID).
-		Order("created_at DESC").
-		First(&latestConv).Error
+	// 同一dialog中存在更晚写入的conversation时，指定的父conversation不是最新的，需要分叉
+	var later int64
+	err := db.Model(&models.ConversationModel{}).
+		Where("dialog_id = ? AND id > ?", parentConv.DialogID, parentConv.ID).
+		Count(&later).Error
 	if err != nil {
 		return false, fmt.Errorf("获取最新conversation失败: %v", err)
 	}
-
-	// 如果指定的父conversation不是最新的，则需要分叉
-	return parentConv.ID != latestConv.ID, nil
+	return later > 0, nil
 }
 
 // CreateBranchingDialogs 创建分叉时的新dialogs
 // 返回: 新对话的dialogID, 被分叉出去的conversations的新dialogID, error
-func CreateBranchingDialogs(sessionID int64, parentConversationID int64, parentDialogID int64) (int64, int64, error) {
-	tx := global.DB.Begin()
-	committed := false
-	defer func() {
-		if r := recover(); r != nil {
-			tx.Rollback()
-		}
-		if !committed {
-			tx.Rollback()
-		}
-	}()
+func CreateBranchingDialogs(sessionID int64, parentConversationID int64, parentDialogID int64) (newDialogID int64, branchedDialogID int64, err error) {
+	err = WithSessionLock(func(tx *gorm.DB) error {
+		newDialogID, branchedDialogID, err = createBranchingDialogs(tx, sessionID, parentConversationID, parentDialogID)
+		return err
+	}, sessionID)
+	return
+}
 
+// createBranchingDialogs 在调用方的事务中创建分叉
+func createBranchingDialogs(tx *gorm.DB, sessionID int64, parentConversationID int64, parentDialogID int64) (int64, int64, error) {
 	// 1. 创建新 dialog，用于用户输入新分支
 	newDialog := models.DialogModel{
 		SessionID:                sessionID,
@@ -601,45 +731,49 @@ func CreateBranchingDialogs(sessionID int64, parentConversationID int64, parentD
 
 	logrus.Debugf("\n创建新 dialog，用于用户输入新分支 %v+\n", newDialog)
 
-	// 2. 创建另一个 dialog，用于接收被分出的历史对话（分叉点之后）
+	branchedDialogID, err := splitDialog(tx, sessionID, parentConversationID, parentDialogID, newDialog.ID)
+	if err != nil {
+		return 0, 0, err
+	}
+	return newDialog.ID, branchedDialogID, nil
+}
+
+// splitDialog 在分叉点把dialog一分为二：分叉点之后的对话和原有的子 dialog 移到新建的 dialog 中
+// 之后分叉点就是原 dialog 的最后一条对话，keep 中的子 dialog 保持不动
+func splitDialog(tx *gorm.DB, sessionID int64, parentConversationID int64, parentDialogID int64, keep ...int64) (int64, error) {
+	// 1. 创建另一个 dialog，用于接收被分出的历史对话（分叉点之后）
 	branchedDialog := models.DialogModel{
 		SessionID:                sessionID,
 		ParentID:                 &parentDialogID,
 		BranchFromConversationID: &parentConversationID,
 	}
 	if err := tx.Create(&branchedDialog).Error; err != nil {
-		return 0, 0, fmt.Errorf("创建分支 dialog 失败: %v", err)
+		return 0, fmt.Errorf("创建分支 dialog 失败: %v", err)
 	}
 
 	logrus.Debugf("\n创建另一个 dialog，用于接收被分出的历史对话（分叉点之后） %v+\n", branchedDialog)
 
-	// 3. 将原来 parentDialogID 的子 dialog 的 parent_id 改为 branchedDialog.ID
+	// 2. 将原来 parentDialogID 的子 dialog 的 parent_id 改为 branchedDialog.ID
 	if err := tx.Model(&models.DialogModel{}).
-		Where("parent_id = ? AND id NOT IN ?", parentDialogID, []int64{newDialog.ID, branchedDialog.ID}).
+		Where("parent_id = ? AND id NOT IN ?", parentDialogID, append(keep, branchedDialog.ID)).
 		Update("parent_id", branchedDialog.ID).Error; err != nil {
-		return 0, 0, fmt.Errorf("更新子 dialog 的父 ID 失败: %v", err)
+		return 0, fmt.Errorf("更新子 dialog 的父 ID 失败: %v", err)
 	}
 
 	logrus.Debugf("将原本父 dialog %d 的 dialog，修改其父节点 id 为 %d\n", parentDialogID, branchedDialog.ID)
 
-	// 4. 检查分叉点 conversation 是否存在（可选：防止 parentID 非法）
+	// 3. 检查分叉点 conversation 是否存在（可选：防止 parentID 非法）
 	var parentConv models.ConversationModel
 	if err := tx.First(&parentConv, parentConversationID).Error; err != nil {
-		return 0, 0, fmt.Errorf("分叉点 conversation 不存在: %v", err)
+		return 0, fmt.Errorf("分叉点 conversation 不存在: %v", err)
 	}
 
-	// 5. 移动分叉点之后的对话记录（使用 ID 比时间更可靠）
+	// 4. 移动分叉点之后的对话记录（使用 ID 比时间更可靠）
 	if err := tx.Model(&models.ConversationModel{}).
 		Where("dialog_id = ? AND id > ?", parentDialogID, parentConversationID).
 		Update("dialog_id", branchedDialog.ID).Error; err != nil {
-		return 0, 0, fmt.Errorf("移动 conversation 到分支失败: %v", err)
-	}
-
-	// 提交事务
-	if err := tx.Commit().Error; err != nil {
-		return 0, 0, fmt.Errorf("提交事务失败: %v", err)
+		return 0, fmt.Errorf("移动 conversation 到分支失败: %v", err)
 	}
-	committed = true
 
-	return newDialog.ID, branchedDialog.ID, nil
+	return branchedDialog.ID, nil
 }
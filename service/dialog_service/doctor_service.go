@@ -0,0 +1,383 @@
+// Path: ./service/dialog_service/doctor_service.go
+
+package dialog_service
+
+import (
+	"dialogTree/global"
+	"dialogTree/models"
+	"dialogTree/service/vector_service"
+	"fmt"
+	"sort"
+
+	"gorm.io/gorm"
+)
+
+// 对话树问题类型
+const (
+	IssueDialogCycle    = "dialog_cycle"   // dialog 的父链形成环
+	IssueMissingParent  = "missing_parent" // 父dialog不存在或属于其他会话
+	IssueBadBranchPoint = "bad_branch"     // 分叉点不在父dialog中
+	IssueEmptyDialog    = "empty_dialog"   // dialog 中没有对话
+	IssueWrongRoot      = "wrong_root"     // 会话的根dialog不正确
+	IssueOrphanVector   = "orphan_vector"  // 向量库中的点没有对应的对话
+)
+
+// TreeIssue 检查发现的一个问题
+type TreeIssue struct {
+	Kind      string `json:"kind"`
+	SessionID int64  `json:"sessionId,omitempty"`
+	TargetID  int64  `json:"targetId"` // 有问题的 dialog、会话或向量点的ID
+	Message   string `json:"message"`
+	Fixed     bool   `json:"fixed"`
+}
+
+// DoctorReport 对话树检查结果
+type DoctorReport struct {
+	Sessions int         `json:"sessions"`
+	Issues   []TreeIssue `json:"issues"`
+}
+
+// sessionTree 一个会话中的dialog和对话
+type sessionTree struct {
+	dialogs map[int64]models.DialogModel
+	convs   map[int64]models.ConversationModel
+	counts  map[int64]int // dialog 中的对话数
+	ids     []int64       // dialog ID 升序，保证报告顺序稳定
+}
+
+func loadSessionTree(db *gorm.DB, sessionID int64) (*sessionTree, error) {
+	t := &sessionTree{
+		dialogs: map[int64]models.DialogModel{},
+		convs:   map[int64]models.ConversationModel{},
+		counts:  map[int64]int{},
+	}
+	var dialogs []models.DialogModel
+	if err := db.Where("session_id = ?", sessionID).Order("id ASC").Find(&dialogs).Error; err != nil {
+		return nil, err
+	}
+	for _, d := range dialogs {
+		t.dialogs[d.ID] = d
+		t.ids = append(t.ids, d.ID)
+	}
+	var convs []models.ConversationModel
+	if err := db.Where("session_id = ?", sessionID).Find(&convs).Error; err != nil {
+		return nil, err
+	}
+	for _, c := range convs {
+		t.convs[c.ID] = c
+		t.counts[c.DialogID]++
+	}
+	return t, nil
+}
+
+// isDescendant dialogID 是否在 rootID 的子树中（含自身），遇到环时停止
+func (t *sessionTree) isDescendant(dialogID, rootID int64) bool {
+	seen := map[int64]bool{}
+	for id := dialogID; !seen[id]; {
+		if id == rootID {
+			return true
+		}
+		seen[id] = true
+		d, ok := t.dialogs[id]
+		if !ok || d.ParentID == nil {
+			return false
+		}
+		id = *d.ParentID
+	}
+	return false
+}
+
+// CheckTrees 检查全部会话的对话树和向量库，fix 为 true 时修复能修复的问题
+func CheckTrees(fix bool) (*DoctorReport, error) {
+	var sessionIDs []int64
+	if err := global.DB.Model(&models.SessionModel{}).Order("id ASC").Pluck("id", &sessionIDs).Error; err != nil {
+		return nil, fmt.Errorf("获取会话失败: %v", err)
+	}
+	report := &DoctorReport{Sessions: len(sessionIDs)}
+	for _, id := range sessionIDs {
+		issues, err := CheckSessionTree(id, fix)
+		if err != nil {
+			return report, err
+		}
+		report.Issues = append(report.Issues, issues...)
+	}
+
+	issues, err := checkOrphanVectors(fix)
+	if err != nil {
+		return report, err
+	}
+	report.Issues = append(report.Issues, issues...)
+	return report, nil
+}
+
+// CheckSessionTree 检查单个会话的对话树，修复时在会话锁内依次处理
+func CheckSessionTree(sessionID int64, fix bool) ([]TreeIssue, error) {
+	var issues []TreeIssue
+	check := func(db *gorm.DB) error {
+		for _, step := range []func(*gorm.DB, int64, bool) ([]TreeIssue, error){
+			checkDialogParents,
+			checkBranchPoints,
+			checkEmptyDialogs,
+			checkRootDialog,
+		} {
+			found, err := step(db, sessionID, fix)
+			if err != nil {
+				return err
+			}
+			issues = append(issues, found...)
+		}
+		return nil
+	}
+	if !fix {
+		return issues, check(global.DB)
+	}
+	return issues, WithSessionLock(check, sessionID)
+}
+
+// checkDialogParents 检查父dialog是否存在以及父链是否成环，修复时把出问题的dialog改为根dialog
+func checkDialogParents(tx *gorm.DB, sessionID int64, fix bool) ([]TreeIssue, error) {
+	t, err := loadSessionTree(tx, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	var issues []TreeIssue
+	var detach []int64
+	for _, id := range t.ids {
+		d := t.dialogs[id]
+		if d.ParentID == nil {
+			continue
+		}
+		if _, ok := t.dialogs[*d.ParentID]; !ok {
+			issues = append(issues, TreeIssue{Kind: IssueMissingParent, SessionID: sessionID, TargetID: id,
+				Message: fmt.Sprintf("dialog %d 的父dialog %d 不存在或不属于该会话", id, *d.ParentID)})
+			detach = append(detach, id)
+		}
+	}
+
+	// 沿父链行走，回到起点说明成环；每个环只报告一次，断开环中ID最小的dialog
+	reported := map[int64]bool{}
+	for _, id := range t.ids {
+		var cycle []int64
+		seen := map[int64]bool{}
+		for cur := id; ; {
+			if seen[cur] {
+				if cur == id {
+					cycle = append([]int64{}, keysOf(seen)...)
+				}
+				break
+			}
+			seen[cur] = true
+			d, ok := t.dialogs[cur]
+			if !ok || d.ParentID == nil {
+				break
+			}
+			cur = *d.ParentID
+		}
+		if len(cycle) == 0 || reported[cycle[0]] {
+			continue
+		}
+		for _, c := range cycle {
+			reported[c] = true
+		}
+		issues = append(issues, TreeIssue{Kind: IssueDialogCycle, SessionID: sessionID, TargetID: cycle[0],
+			Message: fmt.Sprintf("dialog %v 的父链形成环", cycle)})
+		detach = append(detach, cycle[0])
+	}
+
+	if fix && len(detach) > 0 {
+		if err := tx.Model(&models.DialogModel{}).Where("id IN ?", detach).Updates(map[string]any{
+			"parent_id":                   nil,
+			"branch_from_conversation_id": nil,
+		}).Error; err != nil {
+			return nil, fmt.Errorf("断开dialog失败: %v", err)
+		}
+		markFixed(issues)
+	}
+	return issues, nil
+}
+
+// checkBranchPoints 检查分叉点是否在父dialog中
+// 分叉点存在时把dialog改为挂在分叉点所在的dialog下（不会因此成环时），否则清空分叉点，按旧数据从父dialog末尾分出处理
+func checkBranchPoints(tx *gorm.DB, sessionID int64, fix bool) ([]TreeIssue, error) {
+	t, err := loadSessionTree(tx, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	var issues []TreeIssue
+	for _, id := range t.ids {
+		d := t.dialogs[id]
+		if d.BranchFromConversationID == nil {
+			continue
+		}
+		branch, ok := t.convs[*d.BranchFromConversationID]
+		if ok && d.ParentID != nil && branch.DialogID == *d.ParentID {
+			continue
+		}
+		issue := TreeIssue{Kind: IssueBadBranchPoint, SessionID: sessionID, TargetID: id,
+			Message: fmt.Sprintf("dialog %d 的分叉点 %d 不在父dialog %v 中", id, *d.BranchFromConversationID, ptrString(d.ParentID))}
+		if fix {
+			updates := map[string]any{"branch_from_conversation_id": nil}
+			if ok && !t.isDescendant(branch.DialogID, id) {
+				updates = map[string]any{"parent_id": branch.DialogID}
+			}
+			if err := tx.Model(&models.DialogModel{}).Where("id = ?", id).Updates(updates).Error; err != nil {
+				return nil, fmt.Errorf("修复分叉点失败: %v", err)
+			}
+			issue.Fixed = true
+		}
+		issues = append(issues, issue)
+	}
+	return issues, nil
+}
+
+// checkEmptyDialogs 检查没有对话的dialog，修复时子dialog改为接在它的父dialog和分叉点之后，再删除它
+func checkEmptyDialogs(tx *gorm.DB, sessionID int64, fix bool) ([]TreeIssue, error) {
+	t, err := loadSessionTree(tx, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	var issues []TreeIssue
+	for _, id := range t.ids {
+		if t.counts[id] > 0 {
+			continue
+		}
+		issue := TreeIssue{Kind: IssueEmptyDialog, SessionID: sessionID, TargetID: id,
+			Message: fmt.Sprintf("dialog %d 中没有对话", id)}
+		if fix {
+			d := t.dialogs[id]
+			if err := tx.Model(&models.DialogModel{}).Where("parent_id = ?", id).Updates(map[string]any{
+				"parent_id":                   d.ParentID,
+				"branch_from_conversation_id": d.BranchFromConversationID,
+			}).Error; err != nil {
+				return nil, fmt.Errorf("更新子dialog失败: %v", err)
+			}
+			if err := deleteDialogs(tx, sessionID, []int64{id}); err != nil {
+				return nil, err
+			}
+			// 后续空dialog的子dialog可能刚被改为挂在这里
+			for childID, child := range t.dialogs {
+				if child.ParentID != nil && *child.ParentID == id {
+					child.ParentID, child.BranchFromConversationID = d.ParentID, d.BranchFromConversationID
+					t.dialogs[childID] = child
+				}
+			}
+			issue.Fixed = true
+		}
+		issues = append(issues, issue)
+	}
+	return issues, nil
+}
+
+// checkRootDialog 检查会话的根dialog，应为会话中ID最小的根dialog
+func checkRootDialog(tx *gorm.DB, sessionID int64, fix bool) ([]TreeIssue, error) {
+	var session models.SessionModel
+	if err := tx.First(&session, sessionID).Error; err != nil {
+		return nil, err
+	}
+	var root models.DialogModel
+	err := tx.Where("session_id = ? AND parent_id IS NULL", sessionID).Order("id ASC").Take(&root).Error
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return nil, err
+	}
+
+	var expected *int64
+	if root.ID != 0 {
+		expected = &root.ID
+	}
+	if session.RootDialogID != nil && expected != nil {
+		// 指向其他根dialog也可以接受
+		var valid int64
+		if err := tx.Model(&models.DialogModel{}).
+			Where("id = ? AND session_id = ? AND parent_id IS NULL", *session.RootDialogID, sessionID).Count(&valid).Error; err != nil {
+			return nil, err
+		}
+		if valid > 0 {
+			return nil, nil
+		}
+	} else if session.RootDialogID == nil && expected == nil {
+		return nil, nil
+	}
+
+	issue := TreeIssue{Kind: IssueWrongRoot, SessionID: sessionID, TargetID: sessionID,
+		Message: fmt.Sprintf("会话 %d 的根dialog为 %s，应为 %s", sessionID, ptrString(session.RootDialogID), ptrString(expected))}
+	if fix {
+		if err := tx.Model(&session).Update("root_dialog_id", expected).Error; err != nil {
+			return nil, fmt.Errorf("更新根dialog失败: %v", err)
+		}
+		issue.Fixed = true
+	}
+	return []TreeIssue{issue}, nil
+}
+
+// checkOrphanVectors 检查向量库中没有对应对话的点，修复时删除
+func checkOrphanVectors(fix bool) ([]TreeIssue, error) {
+	if !global.Config.Vector.Enable || vector_service.VectorServiceInstance == nil {
+		return nil, nil
+	}
+	points, err := vector_service.VectorServiceInstance.GetAllPoints()
+	if err != nil {
+		return nil, fmt.Errorf("获取向量失败: %v", err)
+	}
+	// 一条对话对应多个点，按对话ID查询是否存在
+	conversationIDs := make([]int64, 0, len(points))
+	seen := map[int64]bool{}
+	for _, p := range points {
+		id := conversationOfPoint(p)
+		if !seen[id] {
+			seen[id] = true
+			conversationIDs = append(conversationIDs, id)
+		}
+	}
+	existing := map[int64]bool{}
+	for start := 0; start < len(conversationIDs); start += 500 {
+		var found []int64
+		end := min(start+500, len(conversationIDs))
+		if err := global.DB.Model(&models.ConversationModel{}).Where("id IN ?", conversationIDs[start:end]).Pluck("id", &found).Error; err != nil {
+			return nil, err
+		}
+		for _, id := range found {
+			existing[id] = true
+		}
+	}
+
+	var issues []TreeIssue
+	for _, p := range points {
+		if existing[conversationOfPoint(p)] {
+			continue
+		}
+		id := int64(p.ID)
+		issue := TreeIssue{Kind: IssueOrphanVector, TargetID: id,
+			Message: fmt.Sprintf("向量点 %d 没有对应的对话", id)}
+		if fix {
+			if err := vector_service.VectorServiceInstance.Delete(uint64(id)); err != nil {
+				issue.Message += fmt.Sprintf("，删除失败: %v", err)
+			} else {
+				issue.Fixed = true
+			}
+		}
+		issues = append(issues, issue)
+	}
+	return issues, nil
+}
+
+func markFixed(issues []TreeIssue) {
+	for i := range issues {
+		issues[i].Fixed = true
+	}
+}
+
+func keysOf(set map[int64]bool) []int64 {
+	keys := make([]int64, 0, len(set))
+	for k := range set {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	return keys
+}
+
+func ptrString(id *int64) string {
+	if id == nil {
+		return "空"
+	}
+	return fmt.Sprint(*id)
+}
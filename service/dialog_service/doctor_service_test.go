@@ -0,0 +1,91 @@
+package dialog_service
+
+import (
+	"dialogTree/global"
+	"dialogTree/models"
+	"testing"
+)
+
+func TestCheckTrees(t *testing.T) {
+	setupTestConfig()
+	global.DB = setupTestDB(t)
+	createSubtreeTestData(t, global.DB)
+
+	report, err := CheckTrees(false)
+	if err != nil {
+		t.Fatalf("检查失败: %v", err)
+	}
+	if report.Sessions != 2 || len(report.Issues) != 0 {
+		t.Fatalf("正常的对话树不应有问题: %+v", report)
+	}
+
+	// 制造问题：空dialog 5，成环的dialog 6、7，dialog3 的分叉点不在父dialog中，会话2的根dialog错误
+	global.DB.Create(&[]models.DialogModel{
+		{Model: models.Model{ID: 5}, SessionID: 1, ParentID: ptr(3), BranchFromConversationID: ptr(5)},
+		{Model: models.Model{ID: 6}, SessionID: 1},
+		{Model: models.Model{ID: 7}, SessionID: 1, ParentID: ptr(6)},
+	})
+	global.DB.Model(&models.DialogModel{}).Where("id = ?", 6).Update("parent_id", 7)
+	global.DB.Create(&[]models.ConversationModel{
+		{Model: models.Model{ID: 7}, Prompt: "问题", SessionID: 1, DialogID: 6},
+		{Model: models.Model{ID: 8}, Prompt: "问题", SessionID: 1, DialogID: 7},
+	})
+	global.DB.Model(&models.DialogModel{}).Where("id = ?", 3).Update("branch_from_conversation_id", 1)
+	global.DB.Model(&models.SessionModel{}).Where("id = ?", 2).Update("root_dialog_id", 99)
+
+	report, err = CheckTrees(false)
+	if err != nil {
+		t.Fatalf("检查失败: %v", err)
+	}
+	kinds := map[string]int64{}
+	for _, issue := range report.Issues {
+		if issue.Fixed {
+			t.Errorf("未指定修复时不应修复: %+v", issue)
+		}
+		kinds[issue.Kind] = issue.TargetID
+	}
+	want := map[string]int64{IssueDialogCycle: 6, IssueBadBranchPoint: 3, IssueEmptyDialog: 5, IssueWrongRoot: 2}
+	if len(report.Issues) != len(want) {
+		t.Errorf("应发现 %d 个问题，实际 %+v", len(want), report.Issues)
+	}
+	for kind, target := range want {
+		if kinds[kind] != target {
+			t.Errorf("问题 %s 应指向 %d，实际 %d", kind, target, kinds[kind])
+		}
+	}
+
+	report, err = CheckTrees(true)
+	if err != nil {
+		t.Fatalf("修复失败: %v", err)
+	}
+	for _, issue := range report.Issues {
+		if !issue.Fixed {
+			t.Errorf("问题应被修复: %+v", issue)
+		}
+	}
+
+	var d3, d6 models.DialogModel
+	global.DB.First(&d3, 3)
+	global.DB.First(&d6, 6)
+	if d3.ParentID == nil || *d3.ParentID != 1 {
+		t.Errorf("dialog3 应改为挂在分叉点所在的dialog1下: %+v", d3)
+	}
+	if d6.ParentID != nil {
+		t.Errorf("环中ID最小的dialog6应被断开: %+v", d6)
+	}
+	var count int64
+	global.DB.Model(&models.DialogModel{}).Where("id = ?", 5).Count(&count)
+	if count != 0 {
+		t.Error("空dialog应被删除")
+	}
+	var session models.SessionModel
+	global.DB.First(&session, 2)
+	if session.RootDialogID == nil || *session.RootDialogID != 4 {
+		t.Errorf("会话2的根dialog应改为4: %v", session.RootDialogID)
+	}
+
+	report, _ = CheckTrees(false)
+	if len(report.Issues) != 0 {
+		t.Errorf("修复后不应再有问题: %+v", report.Issues)
+	}
+}
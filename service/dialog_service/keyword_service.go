@@ -0,0 +1,137 @@
+// Path: ./service/dialog_service/keyword_service.go
+
+package dialog_service
+
+import (
+	"dialogTree/global"
+	"dialogTree/models"
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// BM25 参数
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// tokenize 把文本切分为检索词
+// 字母、数字和下划线连续的部分作为一个词（不区分大小写），保留完整的标识符和错误码；连续的汉字按相邻两字切分
+func tokenize(text string) []string {
+	var tokens []string
+	var word, han []rune
+	flushWord := func() {
+		if len(word) > 0 {
+			tokens = append(tokens, strings.ToLower(string(word)))
+			word = word[:0]
+		}
+	}
+	flushHan := func() {
+		if len(han) == 1 {
+			tokens = append(tokens, string(han))
+		}
+		for i := 0; i+1 < len(han); i++ {
+			tokens = append(tokens, string(han[i:i+2]))
+		}
+		han = han[:0]
+	}
+
+	for _, r := range text {
+		switch {
+		case unicode.Is(unicode.Han, r):
+			flushWord()
+			han = append(han, r)
+		case unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_':
+			flushHan()
+			word = append(word, r)
+		default:
+			flushWord()
+			flushHan()
+		}
+	}
+	flushWord()
+	flushHan()
+	return tokens
+}
+
+// searchKeywordConversations 在会话未归档的对话中按 BM25 检索问题和回答，返回得分最高的 limit 条
+// 个人使用的会话规模不大，直接加载会话内的对话计算，不依赖数据库的全文索引
+func searchKeywordConversations(sessionID int64, question string, limit int) ([]recalledConversation, error) {
+	terms := map[string]bool{}
+	for _, term := range tokenize(question) {
+		terms[term] = true
+	}
+	if len(terms) == 0 {
+		return nil, nil
+	}
+
+	var conversations []models.ConversationModel
+	err := global.DB.Where("session_id = ? AND is_archived = ?", sessionID, false).
+		Order("id ASC").
+		Find(&conversations).Error
+	if err != nil {
+		return nil, err
+	}
+	if len(conversations) == 0 {
+		return nil, nil
+	}
+
+	// 统计查询词在每条对话中的词频和文档频率
+	type document struct {
+		tf     map[string]int
+		length int
+	}
+	docs := make([]document, len(conversations))
+	df := map[string]int{}
+	var totalLength int
+	for i, conv := range conversations {
+		tokens := tokenize(conv.Prompt + "\n" + conv.Answer)
+		tf := map[string]int{}
+		for _, token := range tokens {
+			if terms[token] {
+				tf[token]++
+			}
+		}
+		for term := range tf {
+			df[term]++
+		}
+		docs[i] = document{tf: tf, length: len(tokens)}
+		totalLength += len(tokens)
+	}
+	n := float64(len(docs))
+	avgLength := math.Max(float64(totalLength)/n, 1)
+
+	type scored struct {
+		index int
+		score float64
+	}
+	var ranked []scored
+	for i, doc := range docs {
+		var score float64
+		for term, freq := range doc.tf {
+			idf := math.Log(1 + (n-float64(df[term])+0.5)/(float64(df[term])+0.5))
+			f := float64(freq)
+			score += idf * f * (bm25K1 + 1) / (f + bm25K1*(1-bm25B+bm25B*float64(doc.length)/avgLength))
+		}
+		if score > 0 {
+			ranked = append(ranked, scored{index: i, score: score})
+		}
+	}
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return ranked[i].score > ranked[j].score
+	})
+	if limit > 0 && len(ranked) > limit {
+		ranked = ranked[:limit]
+	}
+
+	results := make([]recalledConversation, 0, len(ranked))
+	for _, r := range ranked {
+		results = append(results, recalledConversation{
+			ConversationModel: conversations[r.index],
+			Source:            SourceKeyword,
+		})
+	}
+	return results, nil
+}
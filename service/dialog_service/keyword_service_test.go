@@ -0,0 +1,48 @@
+package dialog_service
+
+import (
+	"dialogTree/global"
+	"dialogTree/models"
+	"reflect"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	got := tokenize("连接报错 ERR_PROXY_TIMEOUT, 见 config.yaml")
+	want := []string{"连接", "接报", "报错", "err_proxy_timeout", "见", "config", "yaml"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("分词结果 %v，应为 %v", got, want)
+	}
+}
+
+func TestSearchKeywordConversations(t *testing.T) {
+	setupTestConfig()
+	global.DB = setupTestDB(t)
+	sessionID, _, conversationIDs := createTestData(t, global.DB)
+
+	global.DB.Model(&models.ConversationModel{}).Where("id = ?", conversationIDs[1]).
+		Update("answer", "出现 ERR_PROXY_TIMEOUT 时检查代理设置")
+	global.DB.Model(&models.ConversationModel{}).Where("id = ?", conversationIDs[3]).
+		Update("prompt", "代理设置在哪里")
+	global.DB.Model(&models.ConversationModel{}).Where("id = ?", conversationIDs[4]).
+		Updates(map[string]interface{}{"answer": "ERR_PROXY_TIMEOUT", "is_archived": true})
+
+	recalled, err := searchKeywordConversations(sessionID, "又遇到 err_proxy_timeout 了", 5)
+	if err != nil {
+		t.Fatalf("检索失败: %v", err)
+	}
+	// 归档的对话不参与召回
+	if len(recalled) != 1 || recalled[0].ID != conversationIDs[1] || recalled[0].Source != SourceKeyword {
+		t.Errorf("应只召回包含错误码的对话2，实际 %+v", recalled)
+	}
+
+	// 只命中"代理设置"的对话排在后面
+	recalled, _ = searchKeywordConversations(sessionID, "ERR_PROXY_TIMEOUT 的代理设置", 5)
+	if len(recalled) != 2 || recalled[0].ID != conversationIDs[1] || recalled[1].ID != conversationIDs[3] {
+		t.Errorf("同时命中错误码和关键词的对话应排在前面，实际 %+v", recalled)
+	}
+
+	if recalled, _ := searchKeywordConversations(sessionID, "，。", 5); len(recalled) != 0 {
+		t.Errorf("没有检索词时不应召回，实际 %+v", recalled)
+	}
+}
@@ -0,0 +1,107 @@
+// Path: ./service/dialog_service/lineage_service.go
+
+package dialog_service
+
+import (
+	"dialogTree/global"
+	"dialogTree/models"
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// MigrationBackfillParents backfill-parents 完成后记录的迁移名称
+const MigrationBackfillParents = "backfill_parents"
+
+// findParentConversation 找到指定conversation的父conversation
+// 优先使用记录的 ParentConversationID，未回填的旧数据按原来的规则推断
+func findParentConversation(conv models.ConversationModel) (*models.ConversationModel, error) {
+	return findParentConversationIn(global.DB, conv)
+}
+
+// findParentConversationIn 在指定的连接或事务中查找父conversation
+func findParentConversationIn(db *gorm.DB, conv models.ConversationModel) (*models.ConversationModel, error) {
+	if conv.ParentConversationID == nil {
+		// 回填之后没有父对话的只有会话根部的对话
+		if parentsBackfilled(db) {
+			return nil, fmt.Errorf("已到达根节点")
+		}
+		return inferParentConversation(db, conv)
+	}
+	var parent models.ConversationModel
+	if err := db.First(&parent, *conv.ParentConversationID).Error; err != nil {
+		return nil, fmt.Errorf("查询父conversation失败: %v", err)
+	}
+	return &parent, nil
+}
+
+// parentsBackfilled 旧数据的父对话是否已经回填，新建的数据库没有旧数据，迁移时即视为已回填
+func parentsBackfilled(db *gorm.DB) bool {
+	var count int64
+	err := db.Model(&models.MigrationModel{}).Where("name = ?", MigrationBackfillParents).Count(&count).Error
+	return err == nil && count > 0
+}
+
+// markParentsBackfilled 记录父对话已经回填，重复记录时忽略
+func markParentsBackfilled(db *gorm.DB) error {
+	return db.Where(models.MigrationModel{Name: MigrationBackfillParents}).
+		FirstOrCreate(&models.MigrationModel{}).Error
+}
+
+// GetAncestors 获取对话的全部祖先，按从会话根部到父对话的顺序排列
+func GetAncestors(conversationID int64) ([]models.ConversationModel, error) {
+	var conv models.ConversationModel
+	if err := global.DB.First(&conv, conversationID).Error; err != nil {
+		return nil, err
+	}
+
+	var ancestors []models.ConversationModel
+	visited := map[int64]bool{conv.ID: true}
+	for {
+		parent, err := findParentConversation(conv)
+		if err != nil {
+			// 到达根节点
+			break
+		}
+		if visited[parent.ID] {
+			return nil, fmt.Errorf("对话 %d 的祖先链存在环", conversationID)
+		}
+		visited[parent.ID] = true
+		ancestors = append(ancestors, *parent)
+		conv = *parent
+	}
+
+	for i, j := 0, len(ancestors)-1; i < j; i, j = i+1, j-1 {
+		ancestors[i], ancestors[j] = ancestors[j], ancestors[i]
+	}
+	return ancestors, nil
+}
+
+// BackfillParentConversations 为没有 ParentConversationID 的旧数据按原来的推断规则回填父对话
+// 推断只依赖dialog结构和对话ID，与回填顺序无关，可以重复执行；返回回填的对话数
+// 全部回填后记录迁移，此后没有父对话的对话直接视为会话根部，不再推断
+func BackfillParentConversations() (int, error) {
+	var conversations []models.ConversationModel
+	if err := global.DB.Where("parent_conversation_id IS NULL").Order("id ASC").Find(&conversations).Error; err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, conv := range conversations {
+		parent, err := inferParentConversation(global.DB, conv)
+		if err != nil {
+			// 会话根部的对话没有父对话
+			continue
+		}
+		if err := global.DB.Model(&conv).UpdateColumn("parent_conversation_id", parent.ID).Error; err != nil {
+			return count, fmt.Errorf("回填对话 %d 失败: %v", conv.ID, err)
+		}
+		count++
+	}
+	if err := markParentsBackfilled(global.DB); err != nil {
+		return count, fmt.Errorf("记录回填迁移失败: %v", err)
+	}
+	logrus.Infof("回填了 %d 条对话的父对话，%d 条位于会话根部", count, len(conversations)-count)
+	return count, nil
+}
@@ -0,0 +1,73 @@
+package dialog_service
+
+import (
+	"dialogTree/global"
+	"dialogTree/models"
+	"testing"
+	"time"
+)
+
+func TestBackfillParentConversations(t *testing.T) {
+	setupTestConfig()
+	global.DB = setupTestDB(t)
+	_, _, conversationIDs := createTestData(t, global.DB)
+
+	count, err := BackfillParentConversations()
+	if err != nil {
+		t.Fatalf("回填失败: %v", err)
+	}
+	if count != 4 {
+		t.Errorf("除第一条外都应回填，实际 %d 条", count)
+	}
+	var convs []models.ConversationModel
+	global.DB.Order("id ASC").Find(&convs)
+	if convs[0].ParentConversationID != nil {
+		t.Error("会话根部的对话不应有父对话")
+	}
+	for i := 1; i < len(convs); i++ {
+		if convs[i].ParentConversationID == nil || *convs[i].ParentConversationID != conversationIDs[i-1] {
+			t.Errorf("对话 %d 的父对话应为 %d", convs[i].ID, conversationIDs[i-1])
+		}
+	}
+
+	// 可以重复执行
+	if count, _ := BackfillParentConversations(); count != 0 {
+		t.Errorf("重复回填不应再更新，实际 %d 条", count)
+	}
+
+	// 回填后祖先链不再受创建时间影响
+	global.DB.Model(&models.ConversationModel{}).Where("id = ?", conversationIDs[1]).
+		UpdateColumn("created_at", time.Now().Add(time.Hour))
+	ancestors, err := GetAncestors(conversationIDs[4])
+	if err != nil {
+		t.Fatalf("获取祖先失败: %v", err)
+	}
+	if len(ancestors) != 4 || ancestors[0].ID != conversationIDs[0] || ancestors[1].ID != conversationIDs[1] {
+		t.Errorf("祖先应为对话1到4，实际 %v", ancestors)
+	}
+
+	// 回填后没有父对话的对话就是会话根部，不再按dialog推断
+	if !parentsBackfilled(global.DB) {
+		t.Fatal("回填后应记录迁移")
+	}
+	root := models.ConversationModel{Prompt: "新的根部问题", SessionID: 1, DialogID: 1}
+	global.DB.Create(&root)
+	if ancestors, _ := GetAncestors(root.ID); len(ancestors) != 0 {
+		t.Errorf("会话根部的对话不应有祖先，实际 %v", ancestors)
+	}
+}
+
+func TestGetAncestorsUsesBranchPoint(t *testing.T) {
+	setupTestConfig()
+	global.DB = setupTestDB(t)
+	createSubtreeTestData(t, global.DB)
+
+	// dialog2 从对话1分出，祖先不应包含兄弟分支中的对话2
+	ancestors, err := GetAncestors(5)
+	if err != nil {
+		t.Fatalf("获取祖先失败: %v", err)
+	}
+	if len(ancestors) != 2 || ancestors[0].ID != 1 || ancestors[1].ID != 3 {
+		t.Errorf("对话5的祖先应为 1、3，实际 %v", ancestors)
+	}
+}
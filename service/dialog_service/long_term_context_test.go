@@ -0,0 +1,208 @@
+package dialog_service
+
+import (
+	"dialogTree/global"
+	"dialogTree/models"
+	"dialogTree/service/embedding_service"
+	"dialogTree/service/vector_service"
+	"dialogTree/service/vector_service/common"
+	"dialogTree/service/vector_service/local_service"
+	"strings"
+	"testing"
+)
+
+// fakeEmbedder 按预设返回向量，未预设的文本与其他文本都不相似
+type fakeEmbedder map[string][]float32
+
+func (f fakeEmbedder) GetEmbedding(text string) ([]float32, error) {
+	if v, ok := f[text]; ok {
+		return v, nil
+	}
+	return []float32{0, 0, 1}, nil
+}
+
+// setupLocalVector 使用进程内向量库和预设的向量，测试结束后恢复配置
+func setupLocalVector(t *testing.T, vectors fakeEmbedder) {
+	setupTestConfig()
+	vectorConf, embedder, service := global.Config.Vector, embedding_service.EmbeddingServiceInstance, vector_service.VectorServiceInstance
+	t.Cleanup(func() {
+		global.Config.Vector = vectorConf
+		embedding_service.EmbeddingServiceInstance = embedder
+		vector_service.VectorServiceInstance = service
+	})
+
+	global.Config.Vector.Enable = true
+	global.Config.Vector.TopK = 3
+	global.Config.Vector.SimilarityThreshold = 0.5
+	embedding_service.EmbeddingServiceInstance = vectors
+	vector_service.VectorServiceInstance = &local_service.LocalService{}
+	if err := vector_service.VectorServiceInstance.InitCollection(); err != nil {
+		t.Fatalf("初始化向量库失败: %v", err)
+	}
+}
+
+func TestLongTermContextWithLocalVector(t *testing.T) {
+	global.DB = setupTestDB(t)
+	setupLocalVector(t, fakeEmbedder{
+		"问题1":      {1, 0, 0},
+		"问题2":      {0, 1, 0},
+		"问题3":      {0.9, 0.1, 0},
+		"关于问题1的追问": {1, 0, 0},
+	})
+	sessionID, _, conversationIDs := createTestData(t, global.DB)
+
+	for i, prompt := range []string{"问题1", "问题2", "问题3"} {
+		if err := StoreConversationVector(conversationIDs[i], prompt, "回答", "摘要"); err != nil {
+			t.Fatalf("存储向量失败: %v", err)
+		}
+	}
+	// 其他会话的点不应被召回
+	vector_service.VectorServiceInstance.Store(99, []float32{1, 0, 0}, map[string]interface{}{"session_id": 2})
+	if _, err := ArchiveConversation(conversationIDs[2], true); err != nil {
+		t.Fatalf("归档失败: %v", err)
+	}
+
+	recalled, err := getLongTermContextConversations(sessionID, "关于问题1的追问")
+	if err != nil {
+		t.Fatalf("检索失败: %v", err)
+	}
+	if len(recalled) != 1 || recalled[0].ID != conversationIDs[0] || recalled[0].Score < 0.99 {
+		t.Errorf("应只召回对话1，实际 %+v", recalled)
+	}
+
+	longTerm, err := buildLongTermContext(sessionID, "关于问题1的追问")
+	if err != nil {
+		t.Fatalf("构建长期上下文失败: %v", err)
+	}
+	if !strings.Contains(longTerm, "问题1") || strings.Contains(longTerm, "问题2") || strings.Contains(longTerm, "问题3") {
+		t.Errorf("长期上下文应只包含对话1: %s", longTerm)
+	}
+
+	// 重新加载后向量仍在
+	reloaded := &local_service.LocalService{}
+	if err := reloaded.InitCollection(); err != nil {
+		t.Fatalf("重新加载失败: %v", err)
+	}
+	points, _ := reloaded.GetAllPoints()
+	// 3 条对话各有问题、摘要和回答 3 个点，加上其他会话的 1 个点
+	if len(points) != 10 {
+		t.Errorf("应从数据库加载 10 个点，实际 %d", len(points))
+	}
+}
+
+func TestLongTermContextRecallsAnswers(t *testing.T) {
+	global.DB = setupTestDB(t)
+	setupLocalVector(t, fakeEmbedder{
+		"问题1":    {0, 1, 0},
+		"摘要1":    {0.8, 0.6, 0},
+		"回答1":    {1, 0, 0},
+		"问题2":    {0.7, 0.7, 0},
+		"怎么配置代理": {1, 0, 0},
+	})
+	sessionID, _, conversationIDs := createTestData(t, global.DB)
+
+	if err := StoreConversationVector(conversationIDs[0], "问题1", "回答1", "摘要1"); err != nil {
+		t.Fatalf("存储向量失败: %v", err)
+	}
+	if err := StoreConversationVector(conversationIDs[1], "问题2", "回答2", "摘要2"); err != nil {
+		t.Fatalf("存储向量失败: %v", err)
+	}
+
+	// 对话1的问题不相似，但回答和摘要命中，合并为一条并排在前面
+	recalled, err := getLongTermContextConversations(sessionID, "怎么配置代理")
+	if err != nil {
+		t.Fatalf("检索失败: %v", err)
+	}
+	if len(recalled) != 2 || recalled[0].ID != conversationIDs[0] || recalled[1].ID != conversationIDs[1] {
+		t.Fatalf("应按融合分数召回对话1、对话2，实际 %+v", recalled)
+	}
+	if recalled[0].Score < 1.07 || recalled[0].Score > 1.09 {
+		t.Errorf("对话1的融合分数应为 1 + 0.1*0.8，实际 %v", recalled[0].Score)
+	}
+
+	// 删除对话的向量时一并删除摘要和回答的点
+	if err := DeleteConversationVector(conversationIDs[0]); err != nil {
+		t.Fatalf("删除向量失败: %v", err)
+	}
+	points, _ := vector_service.VectorServiceInstance.GetAllPoints()
+	for _, p := range points {
+		if conversationOfPoint(p) == conversationIDs[0] {
+			t.Errorf("对话1的点应被删除: %+v", p)
+		}
+	}
+}
+
+func TestChunkAnswer(t *testing.T) {
+	cases := []struct {
+		length int
+		chunks int
+		size   int
+	}{
+		{0, 0, 0},
+		{100, 1, 100},
+		{5000, 4, 1250},
+		{20000, 4, maxAnswerChunkSize},
+	}
+	for _, c := range cases {
+		chunks := chunkAnswer(strings.Repeat("字", c.length))
+		if len(chunks) != c.chunks {
+			t.Errorf("长度 %d 应分为 %d 段，实际 %d", c.length, c.chunks, len(chunks))
+			continue
+		}
+		if c.chunks > 0 && len([]rune(chunks[0])) != c.size {
+			t.Errorf("长度 %d 每段应为 %d 字，实际 %d", c.length, c.size, len([]rune(chunks[0])))
+		}
+	}
+}
+
+// unfilteredStore 忽略相似度阈值，按预设返回检索结果
+type unfilteredStore struct {
+	*local_service.LocalService
+	results []common.SearchResult
+}
+
+func (s *unfilteredStore) Search(vector []float32, topK int, filter map[string]interface{}) ([]common.SearchResult, error) {
+	return s.results, nil
+}
+
+func TestLongTermContextHybrid(t *testing.T) {
+	global.DB = setupTestDB(t)
+	setupLocalVector(t, fakeEmbedder{})
+	global.Config.Vector.Keyword = true
+	sessionID, _, conversationIDs := createTestData(t, global.DB)
+	global.DB.Model(&models.ConversationModel{}).Where("id = ?", conversationIDs[0]).
+		Update("answer", "重启后 ERR_PROXY_TIMEOUT 消失")
+	global.DB.Model(&models.ConversationModel{}).Where("id = ?", conversationIDs[2]).
+		Update("answer", "ERR_PROXY_TIMEOUT 是代理超时")
+
+	// 对话2的相似度低于阈值，即使向量库返回也不应召回
+	vector_service.VectorServiceInstance = &unfilteredStore{
+		LocalService: vector_service.VectorServiceInstance.(*local_service.LocalService),
+		results: []common.SearchResult{
+			{ID: uint64(conversationIDs[0]), Score: 0.8, Metadata: map[string]interface{}{"conversation_id": conversationIDs[0], "kind": VectorKindPrompt}},
+			{ID: uint64(conversationIDs[1]), Score: 0.3, Metadata: map[string]interface{}{"conversation_id": conversationIDs[1], "kind": VectorKindPrompt}},
+		},
+	}
+
+	recalled, err := getLongTermContextConversations(sessionID, "ERR_PROXY_TIMEOUT 怎么处理")
+	if err != nil {
+		t.Fatalf("检索失败: %v", err)
+	}
+	// 对话1两路都召回排在最前，对话3只由关键词召回
+	if len(recalled) != 2 {
+		t.Fatalf("应召回对话1和对话3，实际 %+v", recalled)
+	}
+	if recalled[0].ID != conversationIDs[0] || recalled[0].Source != SourceHybrid || recalled[0].Score != 0.8 {
+		t.Errorf("第一条应为两路都召回的对话1，实际 %+v", recalled[0])
+	}
+	if recalled[1].ID != conversationIDs[2] || recalled[1].Source != SourceKeyword || recalled[1].Score != 0 {
+		t.Errorf("第二条应为关键词召回的对话3，实际 %+v", recalled[1])
+	}
+
+	// 向量检索关闭时仍可按关键词召回
+	global.Config.Vector.Enable = false
+	recalled, err = getLongTermContextConversations(sessionID, "ERR_PROXY_TIMEOUT")
+	if err != nil || len(recalled) != 2 || recalled[0].Source != SourceKeyword {
+		t.Errorf("应只按关键词召回，实际 %+v %v", recalled, err)
+	}
+}
@@ -0,0 +1,281 @@
+// Path: ./service/dialog_service/merge_service.go
+
+package dialog_service
+
+import (
+	"context"
+	"dialogTree/global"
+	"dialogTree/models"
+	"dialogTree/service/ai_service"
+	"dialogTree/service/ai_service/prompts"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// DefaultMergePrompt 未指定合并要求时使用
+const DefaultMergePrompt = "请综合这两个分支的讨论，调和其中的分歧，给出统一的结论。"
+
+// MergeContextData 合并两个分支时发送给模型的上下文
+type MergeContextData struct {
+	Common   []QAPair   `json:"common"`
+	Branches [][]QAPair `json:"branches"`
+	Current  string     `json:"current"`
+}
+
+// MergeResult 合并产生的对话及上下文取舍情况
+type MergeResult struct {
+	Conversation models.ConversationModel
+	Report       ContextReport
+}
+
+// isLeafConversation 对话是所在dialog的最后一条，且没有子分支和合并产生的子对话
+func isLeafConversation(db *gorm.DB, conv models.ConversationModel) (bool, error) {
+	latest, err := checkIfBranching(db, conv.ID)
+	if err != nil || latest {
+		return false, err
+	}
+	// 从该对话分出的dialog，未记录分叉点的旧数据视为从父dialog的最后一条分出
+	var children int64
+	if err := db.Model(&models.DialogModel{}).
+		Where("branch_from_conversation_id = ? OR (parent_id = ? AND branch_from_conversation_id IS NULL)", conv.ID, conv.DialogID).
+		Count(&children).Error; err != nil {
+		return false, err
+	}
+	if children > 0 {
+		return false, nil
+	}
+	if err := db.Model(&models.ConversationParentModel{}).Where("parent_conversation_id = ?", conv.ID).Count(&children).Error; err != nil {
+		return false, err
+	}
+	return children == 0, nil
+}
+
+// getMergeLeaves 校验两个对话属于同一会话且都是叶子
+func getMergeLeaves(db *gorm.DB, leftID, rightID int64) (left, right models.ConversationModel, err error) {
+	if leftID == rightID {
+		return left, right, fmt.Errorf("不能合并同一条对话")
+	}
+	if err = db.First(&left, leftID).Error; err != nil {
+		return left, right, fmt.Errorf("对话 %d 不存在", leftID)
+	}
+	if err = db.First(&right, rightID).Error; err != nil {
+		return left, right, fmt.Errorf("对话 %d 不存在", rightID)
+	}
+	if left.SessionID != right.SessionID {
+		return left, right, fmt.Errorf("只能合并同一会话中的对话")
+	}
+	for _, conv := range []models.ConversationModel{left, right} {
+		leaf, err := isLeafConversation(db, conv)
+		if err != nil {
+			return left, right, fmt.Errorf("检查对话 %d 失败: %v", conv.ID, err)
+		}
+		if !leaf {
+			return left, right, fmt.Errorf("对话 %d 不是分支末端", conv.ID)
+		}
+	}
+	return left, right, nil
+}
+
+// buildMergeContext 在模型的 token 预算内拼装两条祖先链，共同的前序对话只出现一次
+// 预算优先分给两个分支靠近末端的对话，剩余部分给共同的前序对话
+func buildMergeContext(left, right models.ConversationModel, question, model string) (MergeContextData, ContextReport, error) {
+	data := MergeContextData{Common: []QAPair{}, Branches: [][]QAPair{{}, {}}, Current: question}
+	budgetConf := global.Config.Ai.ContextBudget
+	report := ContextReport{
+		Model:         model,
+		Budget:        budgetConf.For(model),
+		CurrentTokens: ai_service.EstimateTokens(question),
+	}
+
+	layers := global.Config.Ai.ContextLayers
+	if layers < 1 {
+		layers = 1
+	}
+	var chains [2][]models.ConversationModel
+	for i, leaf := range []models.ConversationModel{left, right} {
+		chain, err := traceParentConversationsFromConversation(leaf.ID, layers)
+		if err != nil {
+			return data, report, fmt.Errorf("追溯分支失败: %v", err)
+		}
+		chains[i] = chain
+	}
+
+	// 两条链都包含的对话属于共同前序
+	inLeft := map[int64]bool{}
+	for _, conv := range chains[0] {
+		inLeft[conv.ID] = true
+	}
+	isCommon := map[int64]bool{}
+	for _, conv := range chains[1] {
+		if inLeft[conv.ID] {
+			isCommon[conv.ID] = true
+		}
+	}
+
+	var branches [2][]*contextCandidate
+	var common []*contextCandidate
+	for i, chain := range chains {
+		for _, conv := range chain {
+			if !isCommon[conv.ID] {
+				branches[i] = append(branches[i], newContextCandidate(conv, SectionBranch, SourceAncestor))
+			} else if i == 0 {
+				common = append(common, newContextCandidate(conv, SectionCommon, SourceAncestor))
+			}
+		}
+	}
+
+	remaining := report.Budget - report.CurrentTokens - contextOverhead
+	if remaining < 0 {
+		remaining = 0
+	}
+	usedLeft := allocate(branches[0], remaining/2)
+	usedRight := allocate(branches[1], remaining-usedLeft)
+	usedCommon := allocate(common, remaining-usedLeft-usedRight)
+	usedLeft += upgrade(branches[0], remaining-usedLeft-usedRight-usedCommon)
+	report.Used = report.CurrentTokens + contextOverhead + usedLeft + usedRight + usedCommon
+
+	// 祖先链从末端往上追溯，输出时按时间正序
+	collect := func(cands []*contextCandidate) []QAPair {
+		pairs := []QAPair{}
+		for i := len(cands) - 1; i >= 0; i-- {
+			if cands[i].level != LevelDropped {
+				pairs = append(pairs, cands[i].pair())
+			}
+		}
+		return pairs
+	}
+	data.Common = collect(common)
+	data.Branches = [][]QAPair{collect(branches[0]), collect(branches[1])}
+
+	for _, c := range append(append(branches[0], branches[1]...), common...) {
+		report.Items = append(report.Items, ContextItem{
+			ConversationID: c.conv.ID,
+			Section:        c.section,
+			Source:         c.source,
+			Level:          c.level,
+			Tokens:         c.tokens(),
+		})
+	}
+	return data, report, nil
+}
+
+// MergeConversations 合并同一会话中的两个分支末端，生成综合两个分支的回答
+// 新对话位于 left 所在dialog的子dialog中，两个父对话都记录在 ConversationParentModel
+func MergeConversations(ctx context.Context, leftID, rightID int64, question string, provider ai_service.ChatProvider, opts ai_service.ChatOptions) (*MergeResult, error) {
+	left, right, err := getMergeLeaves(global.DB, leftID, rightID)
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(question) == "" {
+		question = DefaultMergePrompt
+	}
+
+	data, report, err := buildMergeContext(left, right, question, ai_service.ModelOf(provider, opts))
+	if err != nil {
+		return nil, err
+	}
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("JSON序列化失败: %v", err)
+	}
+
+	var usage ai_service.Usage
+	opts.Usage = &usage
+	opts.Instruction = prompts.MergePrompt
+	msgChan, sumChan, answerer, err := ai_service.ChatStreamSumWithFailover(ctx, string(jsonData), provider, opts)
+	if err != nil {
+		return nil, fmt.Errorf("AI服务调用失败: %v", err)
+	}
+
+	var answer strings.Builder
+	for chunk := range msgChan {
+		answer.WriteString(chunk)
+	}
+	var summaryRaw string
+	for s := range sumChan {
+		summaryRaw += s
+	}
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	title, summary := ai_service.ParseSummary(summaryRaw)
+
+	conversation := models.ConversationModel{
+		Prompt:    question,
+		Answer:    answer.String(),
+		SessionID: left.SessionID,
+		Title:     title,
+		Summary:   summary,
+		Provider:  answerer.Provider,
+		ModelName: answerer.Model,
+
+		PromptTokens:     usage.PromptTokens,
+		CompletionTokens: usage.CompletionTokens,
+		Cost:             ai_service.CostOf(answerer.Model, usage),
+		UsageEstimated:   usage.Estimated,
+	}
+	pending := prepareTxVector(question, conversation.Answer, summary)
+	// 生成回答期间分支可能已被接续，写入前在会话锁内重新检查
+	err = WithSessionLock(func(tx *gorm.DB) error {
+		if _, _, err := getMergeLeaves(tx, leftID, rightID); err != nil {
+			return err
+		}
+		dialog := models.DialogModel{
+			SessionID:                left.SessionID,
+			ParentID:                 &left.DialogID,
+			BranchFromConversationID: &left.ID,
+		}
+		if err := tx.Create(&dialog).Error; err != nil {
+			return fmt.Errorf("创建合并dialog失败: %v", err)
+		}
+		conversation.DialogID = dialog.ID
+		conversation.ParentConversationID = &left.ID
+		if err := tx.Create(&conversation).Error; err != nil {
+			return fmt.Errorf("创建合并对话失败: %v", err)
+		}
+		edges := []models.ConversationParentModel{
+			{SessionID: left.SessionID, ConversationID: conversation.ID, ParentConversationID: left.ID},
+			{SessionID: left.SessionID, ConversationID: conversation.ID, ParentConversationID: right.ID},
+		}
+		if err := tx.Create(&edges).Error; err != nil {
+			return fmt.Errorf("记录父对话失败: %v", err)
+		}
+		if pending != nil {
+			return pending.store(tx, conversation)
+		}
+		return nil
+	}, left.SessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	if title == "" || summary == "" {
+		ResummarizeAsync(conversation.ID)
+	}
+	if global.Config.Vector.Enable && pending == nil {
+		go func() {
+			if err := StoreConversationVector(conversation.ID, question, conversation.Answer, summary); err != nil {
+				logrus.Errorf("向量化存储失败: %v", err)
+			}
+		}()
+	}
+
+	return &MergeResult{Conversation: conversation, Report: report}, nil
+}
+
+// GetMergeParents 获取会话中合并产生的对话及其全部父对话
+func GetMergeParents(sessionID int64) (map[int64][]int64, error) {
+	var edges []models.ConversationParentModel
+	if err := global.DB.Where("session_id = ?", sessionID).Order("id ASC").Find(&edges).Error; err != nil {
+		return nil, err
+	}
+	parents := make(map[int64][]int64)
+	for _, edge := range edges {
+		parents[edge.ConversationID] = append(parents[edge.ConversationID], edge.ParentConversationID)
+	}
+	return parents, nil
+}
@@ -0,0 +1,40 @@
+// Path: ./service/dialog_service/persona_service.go
+
+package dialog_service
+
+import (
+	"dialogTree/global"
+	"dialogTree/models"
+	"dialogTree/service/ai_service"
+)
+
+// GetSessionPersona 获取会话使用的角色，未设置时返回 nil
+func GetSessionPersona(session models.SessionModel) (*models.PersonaModel, error) {
+	if session.PersonaID == nil {
+		return nil, nil
+	}
+	var persona models.PersonaModel
+	if err := global.DB.First(&persona, *session.PersonaID).Error; err != nil {
+		return nil, err
+	}
+	return &persona, nil
+}
+
+// ApplyPersona 用角色的默认设置补全请求未指定的提供商、模型和温度，并带上角色说明
+// 请求指定了其他提供商时不沿用角色的模型
+func ApplyPersona(persona *models.PersonaModel, providerName string, opts ai_service.ChatOptions) (string, ai_service.ChatOptions) {
+	if persona == nil {
+		return providerName, opts
+	}
+	opts.Persona = persona.SystemPrompt
+	if providerName == "" {
+		providerName = persona.Provider
+	}
+	if opts.Model == "" && providerName == persona.Provider {
+		opts.Model = persona.ModelName
+	}
+	if opts.Temperature == nil {
+		opts.Temperature = persona.Temperature
+	}
+	return providerName, opts
+}
@@ -0,0 +1,17 @@
+// Path: ./service/dialog_service/pin_service.go
+
+package dialog_service
+
+import (
+	"dialogTree/global"
+	"dialogTree/models"
+)
+
+// GetPinnedConversations 获取会话中固定且未归档的对话，按创建顺序排列
+func GetPinnedConversations(sessionID int64) ([]models.ConversationModel, error) {
+	var conversations []models.ConversationModel
+	err := global.DB.Where("session_id = ? AND is_pinned = ? AND is_archived = ?", sessionID, true, false).
+		Order("id ASC").
+		Find(&conversations).Error
+	return conversations, err
+}
@@ -0,0 +1,302 @@
+// Path: ./service/dialog_service/prune_service.go
+
+package dialog_service
+
+import (
+	"dialogTree/global"
+	"dialogTree/models"
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// descendants 一条对话及其后续的全部对话
+type descendants struct {
+	convIDs   []int64
+	dialogIDs []int64 // 因此变空、需要整体删除的dialog
+}
+
+// collectDescendants 收集对话本身、同一dialog中之后的对话，以及从这些对话分出的全部子dialog
+// 对话是所在dialog的第一条时，整个dialog都在范围内
+func collectDescendants(tx *gorm.DB, conv models.ConversationModel) (*descendants, error) {
+	d := &descendants{}
+
+	var later []models.ConversationModel
+	if err := tx.Where("dialog_id = ? AND id >= ?", conv.DialogID, conv.ID).Order("id ASC").Find(&later).Error; err != nil {
+		return nil, err
+	}
+	for _, c := range later {
+		d.convIDs = append(d.convIDs, c.ID)
+	}
+
+	var earlier int64
+	if err := tx.Model(&models.ConversationModel{}).Where("dialog_id = ? AND id < ?", conv.DialogID, conv.ID).Count(&earlier).Error; err != nil {
+		return nil, err
+	}
+	if earlier == 0 {
+		d.dialogIDs = append(d.dialogIDs, conv.DialogID)
+	}
+
+	// 未记录分叉点的旧数据视为从dialog的最后一条分出，最后一条一定在范围内
+	var children []models.DialogModel
+	if err := tx.Where("parent_id = ?", conv.DialogID).
+		Where("branch_from_conversation_id IN ? OR branch_from_conversation_id IS NULL", d.convIDs).
+		Order("id ASC").Find(&children).Error; err != nil {
+		return nil, err
+	}
+	for _, child := range children {
+		t, err := loadSubtree(tx, child.ID)
+		if err != nil {
+			return nil, err
+		}
+		d.dialogIDs = append(d.dialogIDs, t.dialogIDs()...)
+		d.convIDs = append(d.convIDs, t.conversationIDs()...)
+	}
+	return d, nil
+}
+
+// withConversationLock 在对话所属会话的锁内重新读取对话并执行 fn，加锁前读到的对话可能已被其他修改改变
+// 加锁期间发现对话已被移动到其他会话时，按新的会话重新加锁
+func withConversationLock(conversationID int64, fn func(tx *gorm.DB, conv models.ConversationModel) error) error {
+	for attempt := 0; attempt < 3; attempt++ {
+		var current models.ConversationModel
+		if err := global.DB.Select("id", "session_id").First(&current, conversationID).Error; err != nil {
+			return fmt.Errorf("对话不存在")
+		}
+		moved := false
+		err := WithSessionLock(func(tx *gorm.DB) error {
+			var conv models.ConversationModel
+			if err := tx.First(&conv, conversationID).Error; err != nil {
+				return fmt.Errorf("对话不存在")
+			}
+			if conv.SessionID != current.SessionID {
+				moved = true
+				return nil
+			}
+			return fn(tx, conv)
+		}, current.SessionID)
+		if err != nil || !moved {
+			return err
+		}
+	}
+	return fmt.Errorf("对话所属会话正在变化，请稍后重试")
+}
+
+// promoteAlternatives 原回答被删除后，剩下的备选回答中最早的一条成为新的原回答
+func promoteAlternatives(tx *gorm.DB, deletedIDs []int64) error {
+	var alternatives []models.ConversationModel
+	if err := tx.Where("regenerated_from_id IN ? AND id NOT IN ?", deletedIDs, deletedIDs).
+		Order("id ASC").Find(&alternatives).Error; err != nil {
+		return err
+	}
+	promoted := map[int64]int64{}
+	for _, alt := range alternatives {
+		newOriginal, ok := promoted[*alt.RegeneratedFromID]
+		if !ok {
+			promoted[*alt.RegeneratedFromID] = alt.ID
+			if err := tx.Model(&alt).Update("regenerated_from_id", nil).Error; err != nil {
+				return err
+			}
+			continue
+		}
+		if err := tx.Model(&alt).Update("regenerated_from_id", newOriginal).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteConversations 删除对话及与其相关的合并关系，提交后再删除向量
+func deleteConversations(tx *gorm.DB, ids []int64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	if err := promoteAlternatives(tx, ids); err != nil {
+		return fmt.Errorf("更新备选回答失败: %v", err)
+	}
+	if err := tx.Where("conversation_id IN ? OR parent_conversation_id IN ?", ids, ids).
+		Delete(&models.ConversationParentModel{}).Error; err != nil {
+		return fmt.Errorf("删除合并关系失败: %v", err)
+	}
+	if err := tx.Where("id IN ?", ids).Delete(&models.ConversationModel{}).Error; err != nil {
+		return fmt.Errorf("删除对话失败: %v", err)
+	}
+	return nil
+}
+
+// deleteVectors 删除对话对应的向量，失败只记录日志
+func deleteVectors(ids []int64) {
+	for _, id := range ids {
+		if err := DeleteConversationVector(id); err != nil {
+			logrus.Errorf("向量数据[id: %d]删除错误: %v", id, err)
+		}
+	}
+}
+
+// DeleteConversation 删除单条对话，从它分出的分支和合并关系改为接在它的父对话之后
+func DeleteConversation(conversationID int64) error {
+	err := withConversationLock(conversationID, func(tx *gorm.DB, conv models.ConversationModel) error {
+		// 以它为父对话的对话改为接在它的父对话之后
+		var parentID *int64
+		if parent, err := findParentConversationIn(tx, conv); err == nil {
+			parentID = &parent.ID
+		}
+		if err := tx.Model(&models.ConversationModel{}).Where("parent_conversation_id = ?", conv.ID).
+			UpdateColumn("parent_conversation_id", parentID).Error; err != nil {
+			return fmt.Errorf("更新子对话失败: %v", err)
+		}
+
+		var dialog models.DialogModel
+		if err := tx.First(&dialog, conv.DialogID).Error; err != nil {
+			return err
+		}
+
+		// 同一dialog中的前一条对话，没有时父对话是dialog的分叉点
+		var prev models.ConversationModel
+		err := tx.Where("dialog_id = ? AND id < ?", conv.DialogID, conv.ID).Order("id DESC").Take(&prev).Error
+		if err != nil && err != gorm.ErrRecordNotFound {
+			return err
+		}
+		parentDialogID, branchFrom := dialog.ParentID, dialog.BranchFromConversationID
+		if prev.ID != 0 {
+			parentDialogID, branchFrom = &dialog.ID, &prev.ID
+		}
+
+		var remaining int64
+		if err := tx.Model(&models.ConversationModel{}).Where("dialog_id = ? AND id <> ?", conv.DialogID, conv.ID).Count(&remaining).Error; err != nil {
+			return err
+		}
+		children := tx.Model(&models.DialogModel{}).Where("branch_from_conversation_id = ?", conv.ID)
+		if remaining == 0 {
+			children = tx.Model(&models.DialogModel{}).Where("parent_id = ?", conv.DialogID)
+		}
+		if err := children.Updates(map[string]any{
+			"parent_id":                   parentDialogID,
+			"branch_from_conversation_id": branchFrom,
+		}).Error; err != nil {
+			return fmt.Errorf("更新子分支失败: %v", err)
+		}
+
+		// 以它为父对话的合并关系改为指向它的父对话
+		if branchFrom != nil {
+			var edges []models.ConversationParentModel
+			if err := tx.Where("parent_conversation_id = ?", conv.ID).Find(&edges).Error; err != nil {
+				return err
+			}
+			for _, edge := range edges {
+				var exists int64
+				tx.Model(&models.ConversationParentModel{}).
+					Where("conversation_id = ? AND parent_conversation_id = ?", edge.ConversationID, *branchFrom).Count(&exists)
+				if exists == 0 {
+					if err := tx.Model(&edge).Update("parent_conversation_id", *branchFrom).Error; err != nil {
+						return fmt.Errorf("更新合并关系失败: %v", err)
+					}
+				}
+			}
+		}
+
+		if err := deleteConversations(tx, []int64{conv.ID}); err != nil {
+			return err
+		}
+		if remaining == 0 {
+			return deleteDialogs(tx, conv.SessionID, []int64{conv.DialogID})
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	deleteVectors([]int64{conversationID})
+	return nil
+}
+
+// DeleteConversationSubtree 删除对话及其后续的全部对话和分支，返回删除的对话数
+func DeleteConversationSubtree(conversationID int64) (int, error) {
+	var d *descendants
+	err := withConversationLock(conversationID, func(tx *gorm.DB, conv models.ConversationModel) error {
+		var err error
+		if d, err = collectDescendants(tx, conv); err != nil {
+			return err
+		}
+		if err := deleteConversations(tx, d.convIDs); err != nil {
+			return err
+		}
+		return deleteDialogs(tx, conv.SessionID, d.dialogIDs)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	deleteVectors(d.convIDs)
+	return len(d.convIDs), nil
+}
+
+// deleteDialogs 删除已经没有对话的dialog，会话的根dialog被删除时改用剩下的根dialog
+func deleteDialogs(tx *gorm.DB, sessionID int64, ids []int64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	if err := tx.Where("id IN ?", ids).Delete(&models.DialogModel{}).Error; err != nil {
+		return fmt.Errorf("删除dialog失败: %v", err)
+	}
+	var session models.SessionModel
+	if err := tx.First(&session, sessionID).Error; err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if session.RootDialogID != nil && *session.RootDialogID == id {
+			return resetRootDialog(tx, sessionID, id)
+		}
+	}
+	return nil
+}
+
+// ArchiveConversation 归档或恢复对话及其后续的全部对话和分支，返回更新的对话数
+// 恢复时一并恢复它的祖先，保证它在对话树中可见
+func ArchiveConversation(conversationID int64, archived bool) (int, error) {
+	var count int
+	err := withConversationLock(conversationID, func(tx *gorm.DB, conv models.ConversationModel) error {
+		var err error
+		count, err = archiveConversation(tx, conv, archived)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// ToggleArchiveConversation 切换对话的归档状态，在会话锁内读取当前状态，返回切换后的状态和更新的对话数
+func ToggleArchiveConversation(conversationID int64) (archived bool, count int, err error) {
+	err = withConversationLock(conversationID, func(tx *gorm.DB, conv models.ConversationModel) error {
+		archived = !conv.IsArchived
+		var err error
+		count, err = archiveConversation(tx, conv, archived)
+		return err
+	})
+	if err != nil {
+		return false, 0, err
+	}
+	return archived, count, nil
+}
+
+// archiveConversation 在调用方的事务中归档或恢复对话及其后续，恢复时一并恢复已归档的祖先
+func archiveConversation(tx *gorm.DB, conv models.ConversationModel, archived bool) (int, error) {
+	var ids []int64
+	if !archived {
+		for parent, err := findParentConversationIn(tx, conv); err == nil && parent.IsArchived; parent, err = findParentConversationIn(tx, *parent) {
+			ids = append(ids, parent.ID)
+		}
+	}
+	d, err := collectDescendants(tx, conv)
+	if err != nil {
+		return 0, err
+	}
+	ids = append(ids, d.convIDs...)
+	if err := tx.Model(&models.ConversationModel{}).Where("id IN ?", ids).Update("is_archived", archived).Error; err != nil {
+		return 0, err
+	}
+	return len(ids), nil
+}
@@ -0,0 +1,177 @@
+package dialog_service
+
+import (
+	"dialogTree/global"
+	"dialogTree/models"
+	"sync"
+	"testing"
+)
+
+// chainIDs 从对话往上追溯的ID序列
+func chainIDs(t *testing.T, conversationID int64) []int64 {
+	chain, err := traceParentConversationsFromConversation(conversationID, 10)
+	if err != nil {
+		t.Fatalf("追溯失败: %v", err)
+	}
+	var ids []int64
+	for _, c := range chain {
+		ids = append(ids, c.ID)
+	}
+	return ids
+}
+
+func TestDeleteConversation(t *testing.T) {
+	setupTestConfig()
+
+	t.Run("删除dialog的第一条对话，子分支接到dialog的分叉点", func(t *testing.T) {
+		global.DB = setupTestDB(t)
+		createSubtreeTestData(t, global.DB)
+
+		if err := DeleteConversation(3); err != nil {
+			t.Fatalf("删除失败: %v", err)
+		}
+		if ids := chainIDs(t, 5); len(ids) != 2 || ids[1] != 1 {
+			t.Errorf("对话5的祖先链应为 5→1，实际 %v", ids)
+		}
+		if ids := chainIDs(t, 4); len(ids) != 2 || ids[1] != 1 {
+			t.Errorf("对话4的祖先链应为 4→1，实际 %v", ids)
+		}
+	})
+
+	t.Run("删除dialog中间的对话，子分支接到前一条对话", func(t *testing.T) {
+		global.DB = setupTestDB(t)
+		createSubtreeTestData(t, global.DB)
+		global.DB.Create(&models.ConversationModel{Model: models.Model{ID: 7}, SessionID: 1, DialogID: 3})
+		global.DB.Create(&models.DialogModel{Model: models.Model{ID: 5}, SessionID: 1, ParentID: ptr(3), BranchFromConversationID: ptr(7)})
+		global.DB.Create(&models.ConversationModel{Model: models.Model{ID: 8}, SessionID: 1, DialogID: 5})
+
+		if err := DeleteConversation(7); err != nil {
+			t.Fatalf("删除失败: %v", err)
+		}
+		if ids := chainIDs(t, 8); len(ids) != 4 || ids[1] != 5 {
+			t.Errorf("对话8的祖先链应为 8→5→3→1，实际 %v", ids)
+		}
+	})
+
+	t.Run("删除原回答时备选回答成为新的原回答", func(t *testing.T) {
+		global.DB = setupTestDB(t)
+		createSubtreeTestData(t, global.DB)
+
+		if err := DeleteConversation(2); err != nil {
+			t.Fatalf("删除失败: %v", err)
+		}
+		var conv5 models.ConversationModel
+		global.DB.First(&conv5, 5)
+		if conv5.RegeneratedFromID != nil {
+			t.Error("备选回答应成为新的原回答")
+		}
+	})
+
+	t.Run("删除唯一的对话时删除dialog并更新会话根节点", func(t *testing.T) {
+		global.DB = setupTestDB(t)
+		createSubtreeTestData(t, global.DB)
+
+		if err := DeleteConversation(6); err != nil {
+			t.Fatalf("删除失败: %v", err)
+		}
+		var session models.SessionModel
+		global.DB.First(&session, 2)
+		if session.RootDialogID != nil {
+			t.Errorf("会话2已没有dialog，根节点应为空: %v", *session.RootDialogID)
+		}
+	})
+}
+
+func TestDeleteConversationSubtree(t *testing.T) {
+	setupTestConfig()
+	global.DB = setupTestDB(t)
+	createSubtreeTestData(t, global.DB)
+
+	count, err := DeleteConversationSubtree(3)
+	if err != nil {
+		t.Fatalf("删除失败: %v", err)
+	}
+	if count != 3 {
+		t.Errorf("应删除对话3、4、5，实际 %d 条", count)
+	}
+	var dialogs int64
+	global.DB.Model(&models.DialogModel{}).Where("id IN ?", []int64{2, 3}).Count(&dialogs)
+	if dialogs != 0 {
+		t.Error("变空的dialog应一并删除")
+	}
+	if ids := chainIDs(t, 2); len(ids) != 2 {
+		t.Errorf("原dialog不应受影响: %v", ids)
+	}
+}
+
+func TestArchiveConversation(t *testing.T) {
+	setupTestConfig()
+	global.DB = setupTestDB(t)
+	createSubtreeTestData(t, global.DB)
+	global.DB.Model(&models.ConversationModel{}).Where("id = ?", 4).Update("is_pinned", true)
+
+	count, err := ArchiveConversation(3, true)
+	if err != nil || count != 3 {
+		t.Fatalf("应归档对话3、4、5: count=%d err=%v", count, err)
+	}
+	pinned, _ := GetPinnedConversations(1)
+	if len(pinned) != 0 {
+		t.Error("归档的对话不应作为固定上下文")
+	}
+	recent, _ := getRecentConversationsAcrossDialogs(1, 10)
+	for _, c := range recent {
+		if c.IsArchived {
+			t.Errorf("归档的对话不应作为最近上下文: %d", c.ID)
+		}
+	}
+
+	// 恢复对话5时一并恢复它已归档的祖先
+	if _, err := ArchiveConversation(5, false); err != nil {
+		t.Fatalf("恢复失败: %v", err)
+	}
+	var archived []int64
+	global.DB.Model(&models.ConversationModel{}).Where("is_archived = ?", true).Pluck("id", &archived)
+	if len(archived) != 1 || archived[0] != 4 {
+		t.Errorf("只有对话4应保持归档，实际 %v", archived)
+	}
+}
+
+// TestToggleArchiveConcurrent 并发切换归档状态时，每次都基于锁内读到的状态切换
+func TestToggleArchiveConcurrent(t *testing.T) {
+	setupTestConfig()
+	global.DB = setupFileTestDB(t)
+	_, _, conversationIDs := createTestData(t, global.DB)
+
+	const toggles = 10
+	var wg sync.WaitGroup
+	results := make(chan bool, toggles)
+	for i := 0; i < toggles; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			archived, _, err := ToggleArchiveConversation(conversationIDs[2])
+			if err != nil {
+				t.Errorf("切换失败: %v", err)
+				return
+			}
+			results <- archived
+		}()
+	}
+	wg.Wait()
+	close(results)
+
+	archivedCount := 0
+	for archived := range results {
+		if archived {
+			archivedCount++
+		}
+	}
+	if archivedCount != toggles/2 {
+		t.Errorf("一半的切换应为归档，实际 %d 次", archivedCount)
+	}
+	var conv models.ConversationModel
+	global.DB.First(&conv, conversationIDs[2])
+	if conv.IsArchived {
+		t.Error("偶数次切换后应恢复为未归档")
+	}
+}
@@ -0,0 +1,243 @@
+// Path: ./service/dialog_service/reindex_service.go
+
+package dialog_service
+
+import (
+	"dialogTree/global"
+	"dialogTree/models"
+	"dialogTree/service/embedding_service"
+	"dialogTree/service/vector_service"
+	"dialogTree/service/vector_service/common"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultReindexStateFile 重建向量索引的进度文件
+const DefaultReindexStateFile = "vector_reindex.json"
+
+// ReindexState 重建向量索引的进度，每批完成后写入文件，中断后从上次完成的位置继续
+type ReindexState struct {
+	Provider   string    `json:"provider"`
+	Collection string    `json:"collection,omitempty"` // 写入的新集合，向量库不支持别名时原地覆盖，为空
+	Dimension  int       `json:"dimension"`
+	LastID     int64     `json:"lastId"`             // 已完成的最大对话ID
+	Switched   bool      `json:"switched,omitempty"` // 别名已切换到新集合，之后只需补写
+	StartedAt  time.Time `json:"startedAt"`          // 开始重建的时间，之后修改过的对话在切换后重新写入
+	Done       int64     `json:"done"`
+	Total      int64     `json:"total"`
+}
+
+// ReindexOptions 重建向量索引的选项
+type ReindexOptions struct {
+	StateFile string
+	BatchSize int
+	Restart   bool               // 忽略已有进度重新开始
+	Progress  func(ReindexState) // 每批完成后回调
+}
+
+// ReindexVectors 按对话ID顺序分批重新生成全部对话的向量
+// 支持别名的向量库写入新集合，完成后切换别名并删除旧集合；其他向量库原地覆盖
+// 切换别名后补写重建期间新增和修改的对话，并删除重建期间已删除对话的向量
+func ReindexVectors(opts ReindexOptions) (*ReindexState, error) {
+	if !global.Config.Vector.Enable {
+		return nil, fmt.Errorf("向量服务未启用")
+	}
+	if opts.StateFile == "" {
+		opts.StateFile = DefaultReindexStateFile
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 50
+	}
+	aliased, _ := vector_service.VectorServiceInstance.(vector_service.AliasedVectorService)
+
+	state, err := loadReindexState(opts, aliased)
+	if err != nil {
+		return nil, err
+	}
+	if err := global.DB.Model(&models.ConversationModel{}).Count(&state.Total).Error; err != nil {
+		return state, err
+	}
+	if err := saveReindexState(opts.StateFile, state); err != nil {
+		return state, err
+	}
+
+	store := func(points []common.Point) error {
+		if aliased != nil {
+			return aliased.StoreBatch(state.Collection, points)
+		}
+		for _, p := range points {
+			if err := vector_service.VectorServiceInstance.Store(p.ID, p.Vector, p.Payload); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := reindexFrom(state, opts, store); err != nil {
+		return state, err
+	}
+
+	if aliased != nil {
+		// 切换失败时保留进度文件，再次执行会重新切换别名；首次切换时旧集合可能已经删除，不能重新开始
+		if !state.Switched {
+			previous, err := aliased.SwitchAlias(state.Collection)
+			if err != nil {
+				return state, fmt.Errorf("切换别名失败，请不带 --restart 再次执行以完成切换: %v", err)
+			}
+			state.Switched = true
+			if err := saveReindexState(opts.StateFile, state); err != nil {
+				return state, err
+			}
+			if previous != "" && previous != state.Collection {
+				if err := aliased.DropCollection(previous); err != nil {
+					logrus.Warnf("删除旧集合 %s 失败: %v", previous, err)
+				}
+			}
+		}
+		// 重建期间新保存的对话写入了旧集合，切换后补写到新集合
+		copied := state.LastID
+		if err := reindexFrom(state, opts, store); err != nil {
+			return state, err
+		}
+		// 已写入新集合的对话在重建期间被移动、更新摘要或删除时，改动只写到了旧集合，切换后重新同步
+		if err := resyncChanged(state, copied, store); err != nil {
+			return state, err
+		}
+		issues, err := checkOrphanVectors(true)
+		if err != nil {
+			return state, fmt.Errorf("删除重建期间已删除对话的向量失败: %v", err)
+		}
+		if len(issues) > 0 {
+			logrus.Infof("删除了重建期间已删除对话的 %d 个向量点", len(issues))
+		}
+	}
+
+	if err := os.Remove(opts.StateFile); err != nil && !errors.Is(err, os.ErrNotExist) {
+		logrus.Warnf("删除进度文件失败: %v", err)
+	}
+	return state, nil
+}
+
+// loadReindexState 读取未完成的进度，没有或要求重新开始时新建，需要时创建新集合
+func loadReindexState(opts ReindexOptions, aliased vector_service.AliasedVectorService) (*ReindexState, error) {
+	provider := strings.ToLower(global.Config.Vector.Provider)
+
+	var previous *ReindexState
+	if byteData, err := os.ReadFile(opts.StateFile); err == nil {
+		previous = &ReindexState{}
+		if err := json.Unmarshal(byteData, previous); err != nil {
+			return nil, fmt.Errorf("进度文件 %s 无法解析: %v", opts.StateFile, err)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	if previous != nil && previous.Provider == provider && !opts.Restart {
+		return previous, nil
+	}
+	// 别名已经指向上次的新集合时，它就是正在使用的集合，不能删除
+	if previous != nil && previous.Collection != "" && !previous.Switched && aliased != nil {
+		if err := aliased.DropCollection(previous.Collection); err != nil {
+			logrus.Warnf("删除未完成的集合 %s 失败: %v", previous.Collection, err)
+		}
+	}
+
+	dimension, err := embedding_service.Dimension()
+	if err != nil {
+		return nil, err
+	}
+	state := &ReindexState{Provider: provider, Dimension: dimension, StartedAt: time.Now()}
+	if aliased != nil {
+		state.Collection = fmt.Sprintf("%s_%d", global.Config.Vector.Qdrant.Collection, time.Now().Unix())
+		if err := aliased.CreateCollection(state.Collection, dimension); err != nil {
+			return nil, fmt.Errorf("创建集合失败: %v", err)
+		}
+	} else if resizable, ok := vector_service.VectorServiceInstance.(vector_service.ResizableVectorService); ok {
+		if err := resizable.Resize(dimension); err != nil {
+			return nil, fmt.Errorf("调整向量维度失败: %v", err)
+		}
+	}
+	return state, nil
+}
+
+// reindexFrom 从进度中的位置开始分批生成并写入向量，每批完成后保存进度
+func reindexFrom(state *ReindexState, opts ReindexOptions, store func([]common.Point) error) error {
+	for {
+		var batch []models.ConversationModel
+		if err := global.DB.Where("id > ?", state.LastID).Order("id ASC").Limit(opts.BatchSize).Find(&batch).Error; err != nil {
+			return err
+		}
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := reindexBatch(state, batch, store); err != nil {
+			return err
+		}
+
+		state.LastID = batch[len(batch)-1].ID
+		state.Done += int64(len(batch))
+		if err := saveReindexState(opts.StateFile, state); err != nil {
+			return err
+		}
+		if opts.Progress != nil {
+			opts.Progress(*state)
+		}
+	}
+}
+
+// resyncChanged 重新写入ID不超过 lastID、在开始重建后修改过的对话
+// 只依赖修改时间，中断后再次执行会重复同步，结果不变
+func resyncChanged(state *ReindexState, lastID int64, store func([]common.Point) error) error {
+	var changed []models.ConversationModel
+	err := global.DB.Where("id <= ? AND updated_at >= ?", lastID, state.StartedAt).
+		Order("id ASC").
+		Find(&changed).Error
+	if err != nil {
+		return err
+	}
+	if len(changed) == 0 {
+		return nil
+	}
+	if err := reindexBatch(state, changed, store); err != nil {
+		return err
+	}
+	logrus.Infof("重新同步了重建期间修改过的 %d 条对话", len(changed))
+	return nil
+}
+
+// reindexBatch 生成一批对话的向量并写入
+func reindexBatch(state *ReindexState, batch []models.ConversationModel, store func([]common.Point) error) error {
+	points := make([]common.Point, 0, len(batch))
+	for _, conv := range batch {
+		parts, err := embedConversation(conv.Prompt, conv.Answer, conv.Summary)
+		if err != nil {
+			return fmt.Errorf("对话 %d %v", conv.ID, err)
+		}
+		for _, part := range parts {
+			if len(part.vector) != state.Dimension {
+				return fmt.Errorf("对话 %d 的向量为 %d 维，与集合的 %d 维不一致", conv.ID, len(part.vector), state.Dimension)
+			}
+		}
+		points = append(points, conversationPoints(conv, parts)...)
+	}
+	if err := store(points); err != nil {
+		return fmt.Errorf("写入向量失败: %v", err)
+	}
+	return nil
+}
+
+func saveReindexState(path string, state *ReindexState) error {
+	byteData, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, byteData, 0644); err != nil {
+		return fmt.Errorf("保存进度失败: %v", err)
+	}
+	return nil
+}
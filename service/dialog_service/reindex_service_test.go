@@ -0,0 +1,280 @@
+package dialog_service
+
+import (
+	"dialogTree/global"
+	"dialogTree/models"
+	"dialogTree/service/embedding_service"
+	"dialogTree/service/vector_service"
+	"dialogTree/service/vector_service/common"
+	"dialogTree/service/vector_service/local_service"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// failingEmbedder 对指定文本返回错误，模拟重建过程中断
+type failingEmbedder struct {
+	fakeEmbedder
+	fail string
+}
+
+func (f failingEmbedder) GetEmbedding(text string) ([]float32, error) {
+	if text == f.fail {
+		return nil, errors.New("接口不可用")
+	}
+	return f.fakeEmbedder.GetEmbedding(text)
+}
+
+func TestReindexVectorsResume(t *testing.T) {
+	global.DB = setupTestDB(t)
+	setupLocalVector(t, fakeEmbedder{})
+	global.Config.Vector.Dimension = 3
+	createTestData(t, global.DB)
+
+	opts := ReindexOptions{StateFile: filepath.Join(t.TempDir(), "state.json"), BatchSize: 2}
+	embedding := failingEmbedder{fakeEmbedder: fakeEmbedder{"问题1": {1, 0, 0}}, fail: "问题4"}
+	embedding_service.EmbeddingServiceInstance = embedding
+	state, err := ReindexVectors(opts)
+	if err == nil {
+		t.Fatal("向量化失败时应中断")
+	}
+	if state.LastID != 2 || state.Done != 2 || state.Total != 5 {
+		t.Errorf("应完成第一批，实际 %+v", state)
+	}
+	if _, err := os.Stat(opts.StateFile); err != nil {
+		t.Fatalf("中断后应保留进度文件: %v", err)
+	}
+
+	embedding.fail = ""
+	embedding_service.EmbeddingServiceInstance = embedding
+	var progress []int64
+	opts.Progress = func(s ReindexState) { progress = append(progress, s.LastID) }
+	if _, err := ReindexVectors(opts); err != nil {
+		t.Fatalf("继续重建失败: %v", err)
+	}
+	if len(progress) != 2 || progress[0] != 4 || progress[1] != 5 {
+		t.Errorf("应从对话3继续，实际进度 %v", progress)
+	}
+	points, _ := vector_service.VectorServiceInstance.GetAllPoints()
+	// 每条对话的问题、摘要和回答各一个点
+	if len(points) != 15 || points[0].Vector[0] != 1 {
+		t.Errorf("应写入全部 5 条对话共 15 个点，实际 %+v", points)
+	}
+	if _, err := os.Stat(opts.StateFile); !os.IsNotExist(err) {
+		t.Error("完成后应删除进度文件")
+	}
+}
+
+// aliasedStore 记录写入的集合和别名切换，模拟 Qdrant
+type aliasedStore struct {
+	*local_service.LocalService
+	collections map[string][]common.Point
+	alias       string
+	switchFail  bool
+}
+
+func (s *aliasedStore) CreateCollection(name string, dimension int) error {
+	s.collections[name] = nil
+	return nil
+}
+
+// StoreBatch 同一ID的点覆盖原来的点
+func (s *aliasedStore) StoreBatch(collection string, points []common.Point) error {
+	for _, p := range points {
+		s.Delete(p.ID)
+		s.collections[collection] = append(s.collections[collection], p)
+	}
+	return nil
+}
+
+// GetAllPoints 返回别名指向的集合中的点
+func (s *aliasedStore) GetAllPoints() ([]common.SearchResult, error) {
+	var results []common.SearchResult
+	for _, p := range s.collections[s.alias] {
+		results = append(results, common.SearchResult{ID: p.ID, Metadata: p.Payload, Vector: p.Vector})
+	}
+	return results, nil
+}
+
+// Delete 从别名指向的集合中删除点
+func (s *aliasedStore) Delete(id uint64) error {
+	points := s.collections[s.alias][:0]
+	for _, p := range s.collections[s.alias] {
+		if p.ID != id {
+			points = append(points, p)
+		}
+	}
+	s.collections[s.alias] = points
+	return nil
+}
+
+func (s *aliasedStore) SwitchAlias(collection string) (string, error) {
+	if s.switchFail {
+		return "", errors.New("创建别名失败")
+	}
+	previous := s.alias
+	s.alias = collection
+	return previous, nil
+}
+
+func (s *aliasedStore) DropCollection(name string) error {
+	delete(s.collections, name)
+	return nil
+}
+
+func TestReindexVectorsSwitchesAlias(t *testing.T) {
+	global.DB = setupTestDB(t)
+	setupLocalVector(t, fakeEmbedder{})
+	global.Config.Vector.Dimension = 3
+	global.Config.Vector.Qdrant.Collection = "dialog"
+	createTestData(t, global.DB)
+
+	store := &aliasedStore{
+		LocalService: vector_service.VectorServiceInstance.(*local_service.LocalService),
+		collections:  map[string][]common.Point{"dialog_old": nil},
+		alias:        "dialog_old",
+	}
+	vector_service.VectorServiceInstance = store
+
+	state, err := ReindexVectors(ReindexOptions{StateFile: filepath.Join(t.TempDir(), "state.json")})
+	if err != nil {
+		t.Fatalf("重建失败: %v", err)
+	}
+	if store.alias != state.Collection || len(store.collections[state.Collection]) != 15 {
+		t.Errorf("别名应指向写入了 15 个点的新集合，实际 %q %+v", store.alias, store.collections)
+	}
+	if _, ok := store.collections["dialog_old"]; ok {
+		t.Error("旧集合应被删除")
+	}
+}
+
+func TestReindexVectorsRetriesAliasSwitch(t *testing.T) {
+	global.DB = setupTestDB(t)
+	setupLocalVector(t, fakeEmbedder{})
+	global.Config.Vector.Dimension = 3
+	global.Config.Vector.Qdrant.Collection = "dialog"
+	createTestData(t, global.DB)
+
+	store := &aliasedStore{
+		LocalService: vector_service.VectorServiceInstance.(*local_service.LocalService),
+		collections:  map[string][]common.Point{},
+		switchFail:   true,
+	}
+	vector_service.VectorServiceInstance = store
+
+	opts := ReindexOptions{StateFile: filepath.Join(t.TempDir(), "state.json")}
+	failed, err := ReindexVectors(opts)
+	if err == nil {
+		t.Fatal("切换别名失败时应返回错误")
+	}
+	if _, err := os.Stat(opts.StateFile); err != nil {
+		t.Fatalf("切换失败后应保留进度文件: %v", err)
+	}
+
+	store.switchFail = false
+	state, err := ReindexVectors(opts)
+	if err != nil {
+		t.Fatalf("再次执行失败: %v", err)
+	}
+	if state.Collection != failed.Collection || store.alias != state.Collection {
+		t.Errorf("再次执行应把别名切换到已写好的集合 %s，实际 %q", failed.Collection, store.alias)
+	}
+	if len(store.collections[state.Collection]) != 15 {
+		t.Errorf("再次执行不应重复写入，实际 %d 个点", len(store.collections[state.Collection]))
+	}
+}
+
+func TestReindexVectorsResyncsChangesDuringReindex(t *testing.T) {
+	global.DB = setupTestDB(t)
+	setupLocalVector(t, fakeEmbedder{})
+	global.Config.Vector.Dimension = 3
+	global.Config.Vector.Qdrant.Collection = "dialog"
+	createSubtreeTestData(t, global.DB)
+
+	store := &aliasedStore{
+		LocalService: vector_service.VectorServiceInstance.(*local_service.LocalService),
+		collections:  map[string][]common.Point{},
+	}
+	vector_service.VectorServiceInstance = store
+
+	// 对话5和对话2写入新集合后，把对话5所在的dialog3移动到会话2，并删除对话2
+	changed := false
+	opts := ReindexOptions{StateFile: filepath.Join(t.TempDir(), "state.json"), BatchSize: 2}
+	opts.Progress = func(s ReindexState) {
+		if changed || s.LastID < 5 {
+			return
+		}
+		changed = true
+		if _, err := MoveSubtree(3, 6); err != nil {
+			t.Fatalf("移动失败: %v", err)
+		}
+		if err := DeleteConversation(2); err != nil {
+			t.Fatalf("删除失败: %v", err)
+		}
+	}
+	state, err := ReindexVectors(opts)
+	if err != nil {
+		t.Fatalf("重建失败: %v", err)
+	}
+	if !changed {
+		t.Fatal("重建期间应执行移动")
+	}
+
+	var moved models.ConversationModel
+	global.DB.First(&moved, 5)
+	found := 0
+	for _, p := range store.collections[state.Collection] {
+		switch conversationOfPoint(common.SearchResult{ID: p.ID, Metadata: p.Payload}) {
+		case 5:
+			found++
+			if p.Payload["session_id"] != int64(2) || p.Payload["dialog_id"] != moved.DialogID {
+				t.Errorf("移动后对话5的向量元数据应更新，实际 %v", p.Payload)
+			}
+		case 2:
+			t.Errorf("重建期间删除的对话2不应留在新集合中: %v", p.Payload)
+		}
+	}
+	if found == 0 {
+		t.Error("新集合中应有对话5的向量")
+	}
+}
+
+// resizableStore 记录维度调整，模拟 pgvector
+type resizableStore struct {
+	*local_service.LocalService
+	resized []int
+}
+
+func (s *resizableStore) Resize(dimension int) error {
+	s.resized = append(s.resized, dimension)
+	return s.ClearCollection()
+}
+
+func TestReindexVectorsResizesInPlace(t *testing.T) {
+	global.DB = setupTestDB(t)
+	setupLocalVector(t, fakeEmbedder{})
+	global.Config.Vector.Dimension = 3
+	createTestData(t, global.DB)
+
+	store := &resizableStore{LocalService: vector_service.VectorServiceInstance.(*local_service.LocalService)}
+	vector_service.VectorServiceInstance = store
+
+	opts := ReindexOptions{StateFile: filepath.Join(t.TempDir(), "state.json"), BatchSize: 2}
+	embedding_service.EmbeddingServiceInstance = failingEmbedder{fail: "问题4"}
+	if _, err := ReindexVectors(opts); err == nil {
+		t.Fatal("向量化失败时应中断")
+	}
+	embedding_service.EmbeddingServiceInstance = fakeEmbedder{}
+	if _, err := ReindexVectors(opts); err != nil {
+		t.Fatalf("继续重建失败: %v", err)
+	}
+
+	if len(store.resized) != 1 || store.resized[0] != 3 {
+		t.Errorf("应在开始重建前调整一次维度，继续时不再调整，实际 %v", store.resized)
+	}
+	points, _ := store.GetAllPoints()
+	if len(points) != 15 {
+		t.Errorf("调整维度后应重新写入全部 15 个点，实际 %d", len(points))
+	}
+}
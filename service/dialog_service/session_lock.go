@@ -0,0 +1,114 @@
+// Path: ./service/dialog_service/session_lock.go
+
+package dialog_service
+
+import (
+	"dialogTree/global"
+	"dialogTree/models"
+	"dialogTree/service/redis_service"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	sessionLockTTL  = 30 * time.Second // 实例崩溃后 Redis 锁自动释放的时间，持有期间自动续期
+	sessionLockWait = 10 * time.Second // 等待其他实例释放锁的最长时间
+)
+
+// keyedMutex 按会话ID加锁，没有等待者的锁会被回收
+type keyedMutex struct {
+	mu    sync.Mutex
+	locks map[int64]*refMutex
+}
+
+type refMutex struct {
+	sync.Mutex
+	refs int
+}
+
+var sessionMutex = keyedMutex{locks: map[int64]*refMutex{}}
+
+func (k *keyedMutex) lock(id int64) func() {
+	k.mu.Lock()
+	m, ok := k.locks[id]
+	if !ok {
+		m = &refMutex{}
+		k.locks[id] = m
+	}
+	m.refs++
+	k.mu.Unlock()
+
+	m.Lock()
+	return func() {
+		m.Unlock()
+		k.mu.Lock()
+		m.refs--
+		if m.refs == 0 {
+			delete(k.locks, id)
+		}
+		k.mu.Unlock()
+	}
+}
+
+// lockSessions 按ID升序锁定会话，避免同时锁定两个会话时死锁
+// 进程内总是使用互斥锁（SQLite 只能依赖它），配置了 Redis 时再加分布式锁以覆盖多实例部署
+func lockSessions(sessionIDs ...int64) (unlock func(), err error) {
+	ids := uniqueSorted(sessionIDs)
+	var unlocks []func()
+	unlock = func() {
+		for i := len(unlocks) - 1; i >= 0; i-- {
+			unlocks[i]()
+		}
+	}
+	for _, id := range ids {
+		unlocks = append(unlocks, sessionMutex.lock(id))
+		if global.Redis == nil {
+			continue
+		}
+		redisUnlock, err := redis_service.Lock(fmt.Sprintf("session_tree_lock_%d", id), sessionLockTTL, sessionLockWait)
+		if err != nil {
+			unlock()
+			return nil, err
+		}
+		unlocks = append(unlocks, redisUnlock)
+	}
+	return unlock, nil
+}
+
+func uniqueSorted(ids []int64) []int64 {
+	seen := map[int64]bool{}
+	var result []int64
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			result = append(result, id)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i] < result[j] })
+	return result
+}
+
+// WithSessionLock 在会话锁内开启事务修改对话树，同一会话的修改依次执行
+// 事务中对会话行加 FOR UPDATE 锁，支持行锁的数据库可以在多实例间互斥（SQLite 会忽略该子句）
+func WithSessionLock(fn func(tx *gorm.DB) error, sessionIDs ...int64) error {
+	unlock, err := lockSessions(sessionIDs...)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return global.DB.Transaction(func(tx *gorm.DB) error {
+		for _, id := range uniqueSorted(sessionIDs) {
+			var session models.SessionModel
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").First(&session, id).Error; err != nil {
+				return fmt.Errorf("会话 %d 不存在", id)
+			}
+		}
+		return fn(tx)
+	})
+}
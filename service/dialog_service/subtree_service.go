@@ -0,0 +1,413 @@
+// Path: ./service/dialog_service/subtree_service.go
+
+package dialog_service
+
+import (
+	"dialogTree/global"
+	"dialogTree/models"
+	"dialogTree/service/vector_service"
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// subtree 以某个dialog为根的子树，dialogs 按从根到叶的顺序排列
+type subtree struct {
+	root          models.DialogModel
+	dialogs       []models.DialogModel
+	conversations []models.ConversationModel // 按ID升序
+	dialogSet     map[int64]bool
+	convSet       map[int64]bool
+}
+
+// loadSubtree 沿 ParentID 收集子树中的全部dialog和对话
+func loadSubtree(tx *gorm.DB, dialogID int64) (*subtree, error) {
+	t := &subtree{dialogSet: map[int64]bool{}, convSet: map[int64]bool{}}
+	if err := tx.First(&t.root, dialogID).Error; err != nil {
+		return nil, fmt.Errorf("dialog不存在")
+	}
+
+	t.dialogs = []models.DialogModel{t.root}
+	t.dialogSet[t.root.ID] = true
+	for i := 0; i < len(t.dialogs); i++ {
+		var children []models.DialogModel
+		if err := tx.Where("parent_id = ?", t.dialogs[i].ID).Order("id ASC").Find(&children).Error; err != nil {
+			return nil, err
+		}
+		for _, child := range children {
+			if !t.dialogSet[child.ID] {
+				t.dialogSet[child.ID] = true
+				t.dialogs = append(t.dialogs, child)
+			}
+		}
+	}
+
+	if err := tx.Where("dialog_id IN ?", t.dialogIDs()).Order("id ASC").Find(&t.conversations).Error; err != nil {
+		return nil, err
+	}
+	for _, conv := range t.conversations {
+		t.convSet[conv.ID] = true
+	}
+	return t, nil
+}
+
+func (t *subtree) dialogIDs() []int64 {
+	ids := make([]int64, 0, len(t.dialogs))
+	for _, d := range t.dialogs {
+		ids = append(ids, d.ID)
+	}
+	return ids
+}
+
+func (t *subtree) conversationIDs() []int64 {
+	ids := make([]int64, 0, len(t.conversations))
+	for _, c := range t.conversations {
+		ids = append(ids, c.ID)
+	}
+	return ids
+}
+
+// subtreeSessions 子树和目标对话所在的会话，用于在修改前加锁
+func subtreeSessions(dialogID, targetConversationID int64) ([]int64, error) {
+	var dialog models.DialogModel
+	if err := global.DB.First(&dialog, dialogID).Error; err != nil {
+		return nil, fmt.Errorf("dialog不存在")
+	}
+	target, err := getSubtreeTarget(global.DB, targetConversationID)
+	if err != nil {
+		return nil, err
+	}
+	return []int64{dialog.SessionID, target.SessionID}, nil
+}
+
+// getSubtreeTarget 获取子树要挂到的目标对话
+func getSubtreeTarget(tx *gorm.DB, targetConversationID int64) (models.ConversationModel, error) {
+	var target models.ConversationModel
+	if err := tx.First(&target, targetConversationID).Error; err != nil {
+		return target, fmt.Errorf("目标对话不存在")
+	}
+	return target, nil
+}
+
+// splitAtTarget 目标对话不是所在dialog的最后一条时先分叉，与保存对话时的分叉方式一致
+// 分叉后目标对话是dialog的最后一条，子树的根作为它的子dialog挂上去
+func splitAtTarget(tx *gorm.DB, target models.ConversationModel) error {
+	needsBranching, err := checkIfBranching(tx, target.ID)
+	if err != nil {
+		return fmt.Errorf("检查分叉失败: %v", err)
+	}
+	if !needsBranching {
+		return nil
+	}
+	if _, err := splitDialog(tx, target.SessionID, target.ID, target.DialogID); err != nil {
+		return fmt.Errorf("创建分叉失败: %v", err)
+	}
+	return nil
+}
+
+// resetRootDialog 子树的根是会话的根dialog时，移走后改用会话中剩下的第一个根dialog
+func resetRootDialog(tx *gorm.DB, sessionID, movedDialogID int64) error {
+	var session models.SessionModel
+	if err := tx.First(&session, sessionID).Error; err != nil {
+		return err
+	}
+	if session.RootDialogID == nil || *session.RootDialogID != movedDialogID {
+		return nil
+	}
+	var root models.DialogModel
+	err := tx.Where("session_id = ? AND parent_id IS NULL", sessionID).Order("id ASC").Take(&root).Error
+	if err == gorm.ErrRecordNotFound {
+		return tx.Model(&session).Update("root_dialog_id", nil).Error
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Model(&session).Update("root_dialog_id", root.ID).Error
+}
+
+// MoveSubtree 把以 dialogID 为根的子树移动到目标对话之后，目标可以在其他会话中
+// 子树中的对话保持原有的dialog，跨会话移动时与会话外对话的合并、重新生成关系被解除
+func MoveSubtree(dialogID, targetConversationID int64) (*models.DialogModel, error) {
+	sessions, err := subtreeSessions(dialogID, targetConversationID)
+	if err != nil {
+		return nil, err
+	}
+
+	var moved *subtree
+	var sourceSessionID int64
+	var target models.ConversationModel
+	err = WithSessionLock(func(tx *gorm.DB) error {
+		var err error
+		if moved, err = loadSubtree(tx, dialogID); err != nil {
+			return err
+		}
+		if target, err = getSubtreeTarget(tx, targetConversationID); err != nil {
+			return err
+		}
+		if moved.dialogSet[target.DialogID] {
+			return fmt.Errorf("不能移动到自身的子树中")
+		}
+		sourceSessionID = moved.root.SessionID
+		if err := splitAtTarget(tx, target); err != nil {
+			return err
+		}
+
+		if err := tx.Model(&models.DialogModel{}).Where("id = ?", moved.root.ID).Updates(map[string]any{
+			"parent_id":                   target.DialogID,
+			"branch_from_conversation_id": target.ID,
+		}).Error; err != nil {
+			return fmt.Errorf("更新子树父节点失败: %v", err)
+		}
+		// 子树根dialog的第一条对话改为接在目标对话之后
+		var first models.ConversationModel
+		if err := tx.Where("dialog_id = ?", moved.root.ID).Order("id ASC").Take(&first).Error; err == nil {
+			if err := tx.Model(&first).UpdateColumn("parent_conversation_id", target.ID).Error; err != nil {
+				return fmt.Errorf("更新父对话失败: %v", err)
+			}
+		}
+		if err := resetRootDialog(tx, sourceSessionID, moved.root.ID); err != nil {
+			return fmt.Errorf("更新会话根节点失败: %v", err)
+		}
+		if sourceSessionID == target.SessionID {
+			return nil
+		}
+
+		dialogIDs, convIDs := moved.dialogIDs(), moved.conversationIDs()
+		if err := tx.Model(&models.DialogModel{}).Where("id IN ?", dialogIDs).
+			Update("session_id", target.SessionID).Error; err != nil {
+			return fmt.Errorf("更新dialog所属会话失败: %v", err)
+		}
+		if len(convIDs) == 0 {
+			return nil
+		}
+		if err := tx.Model(&models.ConversationModel{}).Where("id IN ?", convIDs).
+			Update("session_id", target.SessionID).Error; err != nil {
+			return fmt.Errorf("更新对话所属会话失败: %v", err)
+		}
+
+		// 合并和重新生成只在同一会话内有意义，跨越子树边界的关系解除
+		if err := tx.Where("(conversation_id IN ?) != (parent_conversation_id IN ?)", convIDs, convIDs).
+			Delete(&models.ConversationParentModel{}).Error; err != nil {
+			return fmt.Errorf("解除合并关系失败: %v", err)
+		}
+		if err := tx.Model(&models.ConversationParentModel{}).Where("conversation_id IN ?", convIDs).
+			Update("session_id", target.SessionID).Error; err != nil {
+			return fmt.Errorf("更新合并关系失败: %v", err)
+		}
+		if err := tx.Model(&models.ConversationModel{}).
+			Where("(id IN ?) != (regenerated_from_id IN ?)", convIDs, convIDs).
+			Where("regenerated_from_id IS NOT NULL").
+			Update("regenerated_from_id", nil).Error; err != nil {
+			return fmt.Errorf("解除重新生成关系失败: %v", err)
+		}
+		return nil
+	}, sessions...)
+	if err != nil {
+		return nil, err
+	}
+
+	if sourceSessionID != target.SessionID {
+		syncMovedVectors(moved.conversations, target.SessionID)
+		global.DB.Model(&models.SessionModel{}).Where("id = ?", target.SessionID).Update("updated_at", gorm.Expr("CURRENT_TIMESTAMP"))
+	}
+
+	var root models.DialogModel
+	if err := global.DB.First(&root, dialogID).Error; err != nil {
+		return nil, err
+	}
+	return &root, nil
+}
+
+// syncMovedVectors 更新移动到其他会话的对话在向量库中的元数据
+func syncMovedVectors(conversations []models.ConversationModel, sessionID int64) {
+	if !global.Config.Vector.Enable {
+		return
+	}
+	for _, conv := range conversations {
+		err := vector_service.VectorServiceInstance.SetPayload(conversationPointIDs(conv.ID), map[string]interface{}{
+			"session_id": sessionID,
+			"dialog_id":  conv.DialogID,
+		})
+		if err != nil {
+			logrus.Errorf("向量数据[id: %d]元数据更新错误: %v", conv.ID, err)
+		}
+	}
+}
+
+// CopySubtree 把以 dialogID 为根的子树复制到目标对话之后，目标可以在其他会话中
+// 复制的对话保持原有的创建时间和先后顺序，子树内部的合并、重新生成关系一并复制
+func CopySubtree(dialogID, targetConversationID int64) (*models.DialogModel, error) {
+	sessions, err := subtreeSessions(dialogID, targetConversationID)
+	if err != nil {
+		return nil, err
+	}
+
+	// 向量与对话在同一个数据库时，在加锁前生成向量，随复制的对话一起提交
+	pending := map[int64]*pendingVector{}
+	if preview, err := loadSubtree(global.DB, dialogID); err == nil {
+		for _, conv := range preview.conversations {
+			if p := prepareTxVector(conv.Prompt, conv.Answer, conv.Summary); p != nil {
+				pending[conv.ID] = p
+			}
+		}
+	}
+
+	var newRoot models.DialogModel
+	// 没有随事务写入向量的对话，提交后异步写入
+	var unstored []models.ConversationModel
+	err = WithSessionLock(func(tx *gorm.DB) error {
+		source, err := loadSubtree(tx, dialogID)
+		if err != nil {
+			return err
+		}
+		target, err := getSubtreeTarget(tx, targetConversationID)
+		if err != nil {
+			return err
+		}
+		sameSession := source.root.SessionID == target.SessionID
+		if err := splitAtTarget(tx, target); err != nil {
+			return err
+		}
+
+		// 先复制dialog，父节点总是先于子节点创建
+		dialogMap := map[int64]int64{}
+		for i, d := range source.dialogs {
+			dialog := models.DialogModel{SessionID: target.SessionID}
+			if i == 0 {
+				dialog.ParentID = &target.DialogID
+				dialog.BranchFromConversationID = &target.ID
+			} else {
+				parentID := dialogMap[*d.ParentID]
+				dialog.ParentID = &parentID
+			}
+			if err := tx.Create(&dialog).Error; err != nil {
+				return fmt.Errorf("复制dialog失败: %v", err)
+			}
+			dialogMap[d.ID] = dialog.ID
+			if i == 0 {
+				newRoot = dialog
+			}
+		}
+
+		// 按ID升序复制对话，保持同一dialog内的先后顺序
+		// 移动过的子树中父对话的ID可能大于子对话，子树内部的父对话和重新生成关系在全部复制后再更新
+		// 子树根dialog的第一条对话接在目标对话之后
+		convMap := map[int64]int64{}
+		for i, conv := range source.conversations {
+			c := conv
+			c.ID = 0
+			c.SessionID = target.SessionID
+			c.DialogID = dialogMap[conv.DialogID]
+			c.RegeneratedFromID = nil
+			if conv.RegeneratedFromID != nil && !source.convSet[*conv.RegeneratedFromID] && sameSession {
+				c.RegeneratedFromID = conv.RegeneratedFromID
+			}
+			c.ParentConversationID = nil
+			if conv.DialogID == source.root.ID && isFirstInDialog(source.conversations[:i], conv.DialogID) {
+				c.ParentConversationID = &target.ID
+			}
+			if err := tx.Create(&c).Error; err != nil {
+				return fmt.Errorf("复制对话失败: %v", err)
+			}
+			convMap[conv.ID] = c.ID
+			if p := pending[conv.ID]; p != nil {
+				if err := p.store(tx, c); err != nil {
+					return err
+				}
+			} else {
+				unstored = append(unstored, c)
+			}
+		}
+
+		// 父对话和重新生成关系在子树内的指向复制后的对话
+		for _, conv := range source.conversations {
+			updates := map[string]any{}
+			if parentID := remapID(conv.ParentConversationID, convMap, false); parentID != nil {
+				updates["parent_conversation_id"] = *parentID
+			}
+			if conv.RegeneratedFromID != nil && source.convSet[*conv.RegeneratedFromID] {
+				updates["regenerated_from_id"] = convMap[*conv.RegeneratedFromID]
+			}
+			if len(updates) == 0 {
+				continue
+			}
+			if err := tx.Model(&models.ConversationModel{}).Where("id = ?", convMap[conv.ID]).
+				UpdateColumns(updates).Error; err != nil {
+				return fmt.Errorf("更新父对话失败: %v", err)
+			}
+		}
+
+		// 分叉点在子树内的指向复制后的对话
+		for _, d := range source.dialogs[1:] {
+			branchFrom := remapID(d.BranchFromConversationID, convMap, false)
+			if branchFrom == nil {
+				continue
+			}
+			if err := tx.Model(&models.DialogModel{}).Where("id = ?", dialogMap[d.ID]).
+				Update("branch_from_conversation_id", *branchFrom).Error; err != nil {
+				return fmt.Errorf("更新分叉点失败: %v", err)
+			}
+		}
+
+		var edges []models.ConversationParentModel
+		if convIDs := source.conversationIDs(); len(convIDs) > 0 {
+			if err := tx.Where("conversation_id IN ?", convIDs).Order("id ASC").Find(&edges).Error; err != nil {
+				return err
+			}
+		}
+		for _, edge := range edges {
+			parentID := remapID(&edge.ParentConversationID, convMap, sameSession)
+			if parentID == nil {
+				continue
+			}
+			if err := tx.Create(&models.ConversationParentModel{
+				SessionID:            target.SessionID,
+				ConversationID:       convMap[edge.ConversationID],
+				ParentConversationID: *parentID,
+			}).Error; err != nil {
+				return fmt.Errorf("复制合并关系失败: %v", err)
+			}
+		}
+		return nil
+	}, sessions...)
+	if err != nil {
+		return nil, err
+	}
+
+	global.DB.Model(&models.SessionModel{}).Where("id = ?", newRoot.SessionID).Update("updated_at", gorm.Expr("CURRENT_TIMESTAMP"))
+	if global.Config.Vector.Enable && len(unstored) > 0 {
+		go func() {
+			for _, c := range unstored {
+				if err := StoreConversationVector(c.ID, c.Prompt, c.Answer, c.Summary); err != nil {
+					logrus.Errorf("向量化存储失败: %v", err)
+				}
+			}
+		}()
+	}
+	return &newRoot, nil
+}
+
+// isFirstInDialog 之前复制的对话中没有同一dialog的对话
+func isFirstInDialog(previous []models.ConversationModel, dialogID int64) bool {
+	for _, c := range previous {
+		if c.DialogID == dialogID {
+			return false
+		}
+	}
+	return true
+}
+
+// remapID 子树内的对话ID换成复制后的ID，子树外的ID在 keepOutside 时保留，否则置空
+func remapID(id *int64, convMap map[int64]int64, keepOutside bool) *int64 {
+	if id == nil {
+		return nil
+	}
+	if mapped, ok := convMap[*id]; ok {
+		return &mapped
+	}
+	if keepOutside {
+		return id
+	}
+	return nil
+}
@@ -0,0 +1,191 @@
+package dialog_service
+
+import (
+	"dialogTree/global"
+	"dialogTree/models"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// createSubtreeTestData 按保存对话时的分叉方式构造：对话1之后分出对话2、对话3两个分支，对话3之后分出对话4、对话5两个分支
+// 会话1：dialog1(对话1)，dialog10、dialog2 从对话1分出(对话2 / 对话3)，dialog11、dialog3 从对话3分出(对话4 / 对话5)
+// 会话2：dialog4(对话6)
+func createSubtreeTestData(t *testing.T, db *gorm.DB) {
+	now := time.Now()
+	db.Create(&models.CategoryModel{Model: models.Model{ID: 1}, Name: "测试类别"})
+	for _, id := range []int64{1, 2} {
+		db.Create(&models.SessionModel{Model: models.Model{ID: id}, Tittle: "测试会话", CategoryID: 1})
+	}
+	dialogs := []models.DialogModel{
+		{Model: models.Model{ID: 1}, SessionID: 1},
+		{Model: models.Model{ID: 2}, SessionID: 1, ParentID: ptr(1), BranchFromConversationID: ptr(1)},
+		{Model: models.Model{ID: 3}, SessionID: 1, ParentID: ptr(2), BranchFromConversationID: ptr(3)},
+		{Model: models.Model{ID: 4}, SessionID: 2},
+		{Model: models.Model{ID: 10}, SessionID: 1, ParentID: ptr(1), BranchFromConversationID: ptr(1)},
+		{Model: models.Model{ID: 11}, SessionID: 1, ParentID: ptr(2), BranchFromConversationID: ptr(3)},
+	}
+	db.Create(&dialogs)
+	db.Model(&models.SessionModel{}).Where("id = ?", 1).Update("root_dialog_id", 1)
+	db.Model(&models.SessionModel{}).Where("id = ?", 2).Update("root_dialog_id", 4)
+
+	placement := []struct{ session, dialog int64 }{{1, 1}, {1, 10}, {1, 2}, {1, 11}, {1, 3}, {2, 4}}
+	for i, p := range placement {
+		db.Create(&models.ConversationModel{
+			Model:     models.Model{ID: int64(i + 1), CreatedAt: now.Add(time.Duration(i) * time.Minute)},
+			Prompt:    "问题",
+			Answer:    "回答",
+			SessionID: p.session,
+			DialogID:  p.dialog,
+		})
+	}
+	// 对话5记为对话2的重新生成，用于测试跨会话移动时解除子树外的关系
+	db.Model(&models.ConversationModel{}).Where("id = ?", 5).Update("regenerated_from_id", 2)
+}
+
+// assertSplitAt 目标对话是所在dialog的最后一条，之后的对话被分到挂在它之后的dialog中
+func assertSplitAt(t *testing.T, targetID, laterID int64) {
+	t.Helper()
+	var target, later models.ConversationModel
+	global.DB.First(&target, targetID)
+	global.DB.First(&later, laterID)
+	if later.DialogID == target.DialogID {
+		t.Fatalf("对话 %d 之后的对话 %d 应分到新的dialog中", targetID, laterID)
+	}
+	var branched models.DialogModel
+	global.DB.First(&branched, later.DialogID)
+	if branched.ParentID == nil || *branched.ParentID != target.DialogID || *branched.BranchFromConversationID != targetID {
+		t.Errorf("分出的dialog应挂在对话 %d 之后: %+v", targetID, branched)
+	}
+}
+
+func ptr(v int64) *int64 { return &v }
+
+func TestMoveSubtree(t *testing.T) {
+	setupTestConfig()
+	global.DB = setupTestDB(t)
+	createSubtreeTestData(t, global.DB)
+
+	t.Run("不能移动到自身的子树中", func(t *testing.T) {
+		if _, err := MoveSubtree(2, 5); err == nil {
+			t.Error("应该拒绝移动到子树内部")
+		}
+	})
+
+	t.Run("移动到其他会话", func(t *testing.T) {
+		// 对话6之后还有对话7，移动到对话6之后需要先分叉
+		global.DB.Create(&models.ConversationModel{Model: models.Model{ID: 7, CreatedAt: time.Now().Add(time.Hour)}, Prompt: "问题", SessionID: 2, DialogID: 4})
+		root, err := MoveSubtree(2, 6)
+		if err != nil {
+			t.Fatalf("移动失败: %v", err)
+		}
+		if root.SessionID != 2 || *root.ParentID != 4 || *root.BranchFromConversationID != 6 {
+			t.Errorf("子树根应挂到对话6之后: %+v", root)
+		}
+		assertSplitAt(t, 6, 7)
+
+		var count int64
+		global.DB.Model(&models.ConversationModel{}).Where("session_id = ? AND id IN ?", 2, []int64{3, 4, 5}).Count(&count)
+		if count != 3 {
+			t.Errorf("子树中的对话应全部移到会话2，实际 %d 条", count)
+		}
+		var conv5 models.ConversationModel
+		global.DB.First(&conv5, 5)
+		if conv5.RegeneratedFromID != nil {
+			t.Error("跨会话的重新生成关系应被解除")
+		}
+
+		chain, err := traceParentConversationsFromConversation(5, 10)
+		if err != nil {
+			t.Fatalf("追溯失败: %v", err)
+		}
+		var ids []int64
+		for _, c := range chain {
+			ids = append(ids, c.ID)
+		}
+		if len(ids) != 3 || ids[0] != 5 || ids[1] != 3 || ids[2] != 6 {
+			t.Errorf("移动后祖先链应为 5→3→6，实际 %v", ids)
+		}
+	})
+}
+
+func TestCopySubtree(t *testing.T) {
+	setupTestConfig()
+	global.DB = setupTestDB(t)
+	createSubtreeTestData(t, global.DB)
+
+	root, err := CopySubtree(2, 2)
+	if err != nil {
+		t.Fatalf("复制失败: %v", err)
+	}
+	if root.ID == 2 || *root.BranchFromConversationID != 2 {
+		t.Fatalf("应新建挂在对话2之后的dialog: %+v", root)
+	}
+
+	var copiedChild models.DialogModel
+	global.DB.Where("parent_id = ?", root.ID).First(&copiedChild)
+	var branchFrom models.ConversationModel
+	global.DB.First(&branchFrom, *copiedChild.BranchFromConversationID)
+	if branchFrom.DialogID != root.ID {
+		t.Errorf("子树内部的分叉点应指向复制后的对话: %+v", copiedChild)
+	}
+
+	var count int64
+	global.DB.Model(&models.ConversationModel{}).Where("session_id = ?", 1).Count(&count)
+	if count != 8 {
+		t.Errorf("应复制3条对话，会话1实际有 %d 条", count)
+	}
+	var original models.DialogModel
+	global.DB.First(&original, 2)
+	if *original.ParentID != 1 || *original.BranchFromConversationID != 1 {
+		t.Error("原子树不应改变")
+	}
+
+	// 目标对话之后还有对话时先分叉
+	global.DB.Create(&models.ConversationModel{Model: models.Model{ID: 20, CreatedAt: time.Now().Add(time.Hour)}, Prompt: "问题", SessionID: 2, DialogID: 4})
+	root, err = CopySubtree(3, 6)
+	if err != nil {
+		t.Fatalf("复制失败: %v", err)
+	}
+	if *root.ParentID != 4 || *root.BranchFromConversationID != 6 {
+		t.Errorf("复制的子树根应挂到对话6之后: %+v", root)
+	}
+	assertSplitAt(t, 6, 20)
+}
+
+// TestCopyMovedSubtree 移动后子树中父对话的ID可能大于子对话，复制后仍保留完整的祖先链
+func TestCopyMovedSubtree(t *testing.T) {
+	setupTestConfig()
+	global.DB = setupTestDB(t)
+	createSubtreeTestData(t, global.DB)
+
+	// 把较早的dialog10（对话2）挂到较新的对话5之后
+	if _, err := MoveSubtree(10, 5); err != nil {
+		t.Fatalf("移动失败: %v", err)
+	}
+	root, err := CopySubtree(3, 6)
+	if err != nil {
+		t.Fatalf("复制失败: %v", err)
+	}
+
+	var copy5, copy2 models.ConversationModel
+	global.DB.Where("dialog_id = ?", root.ID).First(&copy5)
+	var child models.DialogModel
+	global.DB.Where("parent_id = ?", root.ID).First(&child)
+	global.DB.Where("dialog_id = ?", child.ID).First(&copy2)
+	if copy2.ParentConversationID == nil || *copy2.ParentConversationID != copy5.ID {
+		t.Fatalf("复制的对话2的父对话应为复制的对话5 %d，实际 %v", copy5.ID, copy2.ParentConversationID)
+	}
+	if copy5.RegeneratedFromID == nil || *copy5.RegeneratedFromID != copy2.ID {
+		t.Errorf("子树内的重新生成关系应指向复制的对话2 %d，实际 %v", copy2.ID, copy5.RegeneratedFromID)
+	}
+
+	ancestors, err := GetAncestors(copy2.ID)
+	if err != nil {
+		t.Fatalf("获取祖先失败: %v", err)
+	}
+	if len(ancestors) != 2 || ancestors[0].ID != 6 || ancestors[1].ID != copy5.ID {
+		t.Errorf("复制的对话2的祖先应为 6、%d，实际 %v", copy5.ID, ancestors)
+	}
+}
@@ -0,0 +1,241 @@
+// Path: ./service/dialog_service/summary_service.go
+
+package dialog_service
+
+import (
+	"context"
+	"dialogTree/global"
+	"dialogTree/models"
+	"dialogTree/service/ai_service"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"sync"
+	"time"
+)
+
+const (
+	resummarizeBatch      = 20               // 每轮后台扫描最多补全的对话数
+	resummarizeInterval   = 10 * time.Minute // 后台扫描间隔
+	resummarizeRetryAfter = 24 * time.Hour   // 补全失败的对话间隔多久再由后台重试
+)
+
+// resummarizing 正在补全的对话，避免保存后的补全与定时扫描重复请求
+var resummarizing sync.Map
+
+// summarizeConversation 单独请求为对话生成标题和摘要
+// 优先使用回答该对话的提供商，已不可用时使用默认提供商
+func summarizeConversation(ctx context.Context, conv models.ConversationModel) (ai_service.SummaryResult, error) {
+	provider, err := ai_service.ResolveProvider(conv.Provider)
+	if err != nil {
+		provider, err = ai_service.ResolveProvider("")
+		if err != nil {
+			return ai_service.SummaryResult{}, err
+		}
+	}
+
+	result, _, err := ai_service.SummarizeWithFailover(ctx, conv.Prompt, conv.Answer, provider)
+	if err != nil {
+		return result, fmt.Errorf("生成标题和摘要失败: %v", err)
+	}
+	return result, nil
+}
+
+// isFirstExchange 判断对话是否为会话的第一轮问答（根 dialog 中最早的对话）
+func isFirstExchange(conv models.ConversationModel) bool {
+	var first models.ConversationModel
+	err := global.DB.
+		Joins("JOIN session_models ON session_models.root_dialog_id = conversation_models.dialog_id").
+		Where("session_models.id = ?", conv.SessionID).
+		Order("conversation_models.id ASC").
+		First(&first).Error
+	if err != nil {
+		// 旧数据可能没有记录根 dialog，退化为会话中最早的对话
+		err = global.DB.Where("session_id = ?", conv.SessionID).Order("id ASC").First(&first).Error
+	}
+	return err == nil && first.ID == conv.ID
+}
+
+// fillSessionTitle 会话标题为空时，用第一轮问答的标题和摘要补全
+func fillSessionTitle(conv models.ConversationModel, title, summary string) {
+	if !isFirstExchange(conv) {
+		return
+	}
+	if title != "" {
+		err := global.DB.Model(&models.SessionModel{}).
+			Where("id = ? AND (tittle = '' OR tittle IS NULL)", conv.SessionID).
+			Update("tittle", title).Error
+		if err != nil {
+			logrus.Errorf("更新会话标题失败: %v", err)
+		}
+	}
+	if summary != "" {
+		err := global.DB.Model(&models.SessionModel{}).
+			Where("id = ? AND (summary = '' OR summary IS NULL)", conv.SessionID).
+			Update("summary", summary).Error
+		if err != nil {
+			logrus.Errorf("更新会话摘要失败: %v", err)
+		}
+	}
+}
+
+// ResummarizeConversation 为缺少标题或摘要的对话补全，已有的内容不会被覆盖
+func ResummarizeConversation(ctx context.Context, conversationID int64) error {
+	if _, loaded := resummarizing.LoadOrStore(conversationID, true); loaded {
+		return nil
+	}
+	defer resummarizing.Delete(conversationID)
+
+	var conv models.ConversationModel
+	if err := global.DB.First(&conv, conversationID).Error; err != nil {
+		return fmt.Errorf("对话不存在: %v", err)
+	}
+	if conv.Summary != "" && conv.Title != "" {
+		return nil
+	}
+	// 记录尝试时间，持续失败的对话不会占满后台每轮的批次
+	if err := global.DB.Model(&conv).UpdateColumn("summary_attempt_at", time.Now()).Error; err != nil {
+		return fmt.Errorf("记录补全时间失败: %v", err)
+	}
+
+	result, err := summarizeConversation(ctx, conv)
+	if err != nil {
+		return err
+	}
+
+	updates := map[string]interface{}{}
+	if conv.Summary == "" && result.Summary != "" {
+		updates["summary"] = result.Summary
+	}
+	if conv.Title == "" && result.Title != "" {
+		updates["title"] = result.Title
+	}
+	if len(updates) == 0 {
+		return fmt.Errorf("生成的标题和摘要为空")
+	}
+	if err := global.DB.Model(&conv).Updates(updates).Error; err != nil {
+		return fmt.Errorf("更新标题和摘要失败: %v", err)
+	}
+
+	fillSessionTitle(conv, result.Title, result.Summary)
+	if summary, ok := updates["summary"].(string); ok {
+		if err := storeSummaryVector(conv, summary); err != nil {
+			logrus.Warnf("对话 %d 摘要向量化失败: %v", conv.ID, err)
+		}
+	}
+
+	logrus.Infof("已补全对话 %d 的标题和摘要: %s / %s", conv.ID, result.Title, result.Summary)
+	return nil
+}
+
+// ResummarizeMissing 补全缺少标题或摘要的对话，返回成功补全的数量
+// resummarizeRetryAfter 内尝试过的对话跳过，避免持续失败的对话挡住后面的对话
+func ResummarizeMissing(ctx context.Context, limit int) (int, error) {
+	var ids []int64
+	err := global.DB.Model(&models.ConversationModel{}).
+		Where("(summary = '' OR summary IS NULL OR title = '' OR title IS NULL) AND answer <> ''").
+		Where("summary_attempt_at IS NULL OR summary_attempt_at < ?", time.Now().Add(-resummarizeRetryAfter)).
+		Order("id ASC").
+		Limit(limit).
+		Pluck("id", &ids).Error
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, id := range ids {
+		if ctx.Err() != nil {
+			break
+		}
+		if err := ResummarizeConversation(ctx, id); err != nil {
+			logrus.Warnf("对话 %d 标题和摘要补全失败: %v", id, err)
+			continue
+		}
+		count++
+	}
+	return count, nil
+}
+
+// ResummarizeAsync 在后台为刚保存的对话补全标题和摘要
+func ResummarizeAsync(conversationID int64) {
+	go func() {
+		if err := ResummarizeConversation(context.Background(), conversationID); err != nil {
+			logrus.Warnf("对话 %d 标题和摘要补全失败: %v", conversationID, err)
+		}
+	}()
+}
+
+// StartSummaryWorker 启动后台补全：启动时扫描一次，之后定时扫描
+// 未配置任何提供商时不启动，避免用模拟摘要填充数据库
+func StartSummaryWorker() {
+	if len(ai_service.Providers()) == 0 {
+		return
+	}
+	go func() {
+		for {
+			count, err := ResummarizeMissing(context.Background(), resummarizeBatch)
+			if err != nil {
+				logrus.Errorf("扫描缺少标题或摘要的对话失败: %v", err)
+			} else if count > 0 {
+				logrus.Infof("本轮补全了 %d 条对话的标题和摘要", count)
+			}
+			time.Sleep(resummarizeInterval)
+		}
+	}()
+}
+
+// RetitleResult 批量重新生成标题的结果
+type RetitleResult struct {
+	Updated      int    `json:"updated"`      // 成功重新生成的对话数
+	Failed       int    `json:"failed"`       // 生成失败的对话数
+	SessionTitle string `json:"sessionTitle"` // 会话当前标题
+}
+
+// RetitleSession 为会话中的所有对话重新生成标题，缺少摘要的一并补全
+// keepSessionTitle 为 false 时，会话标题改为第一轮问答的新标题
+func RetitleSession(ctx context.Context, sessionID int64, keepSessionTitle bool) (result RetitleResult, err error) {
+	var session models.SessionModel
+	if err = global.DB.First(&session, sessionID).Error; err != nil {
+		return result, fmt.Errorf("会话不存在: %v", err)
+	}
+
+	var convs []models.ConversationModel
+	err = global.DB.Where("session_id = ?", sessionID).Order("id ASC").Find(&convs).Error
+	if err != nil {
+		return result, fmt.Errorf("查询对话失败: %v", err)
+	}
+
+	result.SessionTitle = session.Tittle
+	for _, conv := range convs {
+		if ctx.Err() != nil {
+			return result, ctx.Err()
+		}
+
+		s, err := summarizeConversation(ctx, conv)
+		if err != nil || s.Title == "" {
+			logrus.Warnf("对话 %d 重新生成标题失败: %v", conv.ID, err)
+			result.Failed++
+			continue
+		}
+
+		updates := map[string]interface{}{"title": s.Title}
+		if conv.Summary == "" && s.Summary != "" {
+			updates["summary"] = s.Summary
+		}
+		if err := global.DB.Model(&conv).Updates(updates).Error; err != nil {
+			logrus.Errorf("更新对话 %d 标题失败: %v", conv.ID, err)
+			result.Failed++
+			continue
+		}
+		result.Updated++
+
+		if (!keepSessionTitle || session.Tittle == "") && isFirstExchange(conv) {
+			err := global.DB.Model(&session).Update("tittle", s.Title).Error
+			if err != nil {
+				logrus.Errorf("更新会话标题失败: %v", err)
+				continue
+			}
+			result.SessionTitle = s.Title
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,74 @@
+package dialog_service
+
+import (
+	"context"
+	"dialogTree/conf"
+	"dialogTree/global"
+	"dialogTree/models"
+	"dialogTree/service/ai_service/common"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// untitledProvider 对以"坏"开头的问题只返回摘要不返回标题，模拟持续补全失败
+type untitledProvider struct {
+	common.MockProvider
+}
+
+func (untitledProvider) Name() string { return "untitled" }
+
+func (untitledProvider) Summarize(ctx context.Context, question, answer string, opts common.ChatOptions) (common.SummaryResult, error) {
+	if strings.HasPrefix(question, "坏") {
+		return common.SummaryResult{Summary: "只有摘要"}, nil
+	}
+	return common.SummaryResult{Title: "标题", Summary: "摘要"}, nil
+}
+
+func TestResummarizeMissingSkipsFailedRows(t *testing.T) {
+	setupTestConfig()
+	global.DB = setupTestDB(t)
+	createTestData(t, global.DB)
+	common.RegisterProviderType("untitled-test", func(cfg conf.Provider) (common.ChatProvider, error) {
+		return untitledProvider{}, nil
+	})
+	aiConf := global.Config.Ai
+	t.Cleanup(func() { global.Config.Ai = aiConf })
+	global.Config.Ai.Providers = []conf.Provider{{Name: "untitled", Type: "untitled-test"}}
+
+	// 前一批对话每次都补不出标题，后面还有缺少标题和摘要的对话
+	var convs []models.ConversationModel
+	for i := 0; i < resummarizeBatch+5; i++ {
+		prompt := fmt.Sprintf("好问题%d", i)
+		if i < resummarizeBatch {
+			prompt = fmt.Sprintf("坏问题%d", i)
+		}
+		convs = append(convs, models.ConversationModel{Prompt: prompt, Answer: "回答", SessionID: 1, DialogID: 1, Provider: "untitled"})
+	}
+	global.DB.Create(&convs)
+
+	ctx := context.Background()
+	for tick := 0; tick < 2; tick++ {
+		if _, err := ResummarizeMissing(ctx, resummarizeBatch); err != nil {
+			t.Fatalf("补全失败: %v", err)
+		}
+	}
+
+	var untitled int64
+	global.DB.Model(&models.ConversationModel{}).Where("prompt LIKE ? AND title = ''", "好问题%").Count(&untitled)
+	if untitled != 0 {
+		t.Errorf("前一批持续失败时，后面的 %d 条对话仍未补全", untitled)
+	}
+
+	// 超过重试间隔后再次尝试失败的对话
+	global.DB.Model(&models.ConversationModel{}).Where("prompt LIKE ?", "坏问题%").
+		UpdateColumn("summary_attempt_at", time.Now().Add(-2*resummarizeRetryAfter))
+	ResummarizeMissing(ctx, resummarizeBatch)
+	var retried int64
+	global.DB.Model(&models.ConversationModel{}).
+		Where("prompt LIKE ? AND summary_attempt_at > ?", "坏问题%", time.Now().Add(-time.Minute)).Count(&retried)
+	if retried != resummarizeBatch {
+		t.Errorf("超过重试间隔的对话应再次尝试，实际 %d", retried)
+	}
+}
@@ -0,0 +1,77 @@
+// Path: ./service/dialog_service/template_service.go
+
+package dialog_service
+
+import (
+	"dialogTree/global"
+	"dialogTree/models"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// TemplatePreviousVar 多步模板中替换为上一步回答的内置变量
+const TemplatePreviousVar = "previous"
+
+var templateVarPattern = regexp.MustCompile(`\{\{\s*([^{}\s]+)\s*\}\}`)
+
+// TemplateVariables 返回模板各步骤中需要调用方提供的变量，按首次出现的顺序，不含内置变量
+func TemplateVariables(steps []string) []string {
+	var names []string
+	seen := map[string]bool{TemplatePreviousVar: true}
+	for _, step := range steps {
+		for _, m := range templateVarPattern.FindAllStringSubmatch(step, -1) {
+			if !seen[m[1]] {
+				seen[m[1]] = true
+				names = append(names, m[1])
+			}
+		}
+	}
+	return names
+}
+
+// CheckTemplateVars 检查调用方是否提供了模板需要的全部变量
+func CheckTemplateVars(steps []string, vars map[string]string) error {
+	var missing []string
+	for _, name := range TemplateVariables(steps) {
+		if _, ok := vars[name]; !ok {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("缺少模板变量: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// RenderTemplateStep 替换一个步骤中的变量，previous 为上一步的回答，第一步为空
+func RenderTemplateStep(step string, vars map[string]string, previous string) string {
+	return templateVarPattern.ReplaceAllStringFunc(step, func(match string) string {
+		name := templateVarPattern.FindStringSubmatch(match)[1]
+		if name == TemplatePreviousVar {
+			return previous
+		}
+		if value, ok := vars[name]; ok {
+			return value
+		}
+		return match
+	})
+}
+
+// GetTemplate 按ID获取模板
+func GetTemplate(id int64) (*models.PromptTemplateModel, error) {
+	var tmpl models.PromptTemplateModel
+	if err := global.DB.First(&tmpl, id).Error; err != nil {
+		return nil, fmt.Errorf("模板不存在: %v", err)
+	}
+	return &tmpl, nil
+}
+
+// GetTemplateByName 按名称获取模板
+func GetTemplateByName(name string) (*models.PromptTemplateModel, error) {
+	var tmpl models.PromptTemplateModel
+	if err := global.DB.Where("name = ?", name).First(&tmpl).Error; err != nil {
+		return nil, fmt.Errorf("模板 %s 不存在: %v", name, err)
+	}
+	return &tmpl, nil
+}
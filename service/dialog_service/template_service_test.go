@@ -0,0 +1,29 @@
+package dialog_service
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestRenderTemplateStep 变量替换，未提供的变量保持原样，previous 为内置变量
+func TestRenderTemplateStep(t *testing.T) {
+	steps := []string{"把{{ text }}翻译成{{lang}}", "润色：{{previous}}，保持{{lang}}"}
+
+	if got := TemplateVariables(steps); !reflect.DeepEqual(got, []string{"text", "lang"}) {
+		t.Errorf("变量列表错误：%v", got)
+	}
+	if err := CheckTemplateVars(steps, map[string]string{"text": "你好"}); err == nil {
+		t.Error("缺少 lang 时应该报错")
+	}
+
+	vars := map[string]string{"text": "你好", "lang": "英文"}
+	if got := RenderTemplateStep(steps[0], vars, ""); got != "把你好翻译成英文" {
+		t.Errorf("第一步渲染错误：%s", got)
+	}
+	if got := RenderTemplateStep(steps[1], vars, "Hello"); got != "润色：Hello，保持英文" {
+		t.Errorf("第二步渲染错误：%s", got)
+	}
+	if got := RenderTemplateStep("{{unknown}}", vars, ""); got != "{{unknown}}" {
+		t.Errorf("未知变量应保持原样：%s", got)
+	}
+}
@@ -0,0 +1,123 @@
+// Path: ./service/dialog_service/vector_points.go
+
+package dialog_service
+
+import (
+	"dialogTree/models"
+	"dialogTree/service/embedding_service"
+	"dialogTree/service/vector_service/common"
+	"fmt"
+	"strings"
+)
+
+// 一条对话在向量库中对应多个点：问题、摘要和分段的回答
+const (
+	VectorKindPrompt  = "prompt"
+	VectorKindSummary = "summary"
+	VectorKindAnswer  = "answer"
+)
+
+const (
+	pointSlotShift     = 48 // 点ID的高位存放槽位，低位为对话ID
+	pointSlotPrompt    = 0  // 问题的点ID与对话ID相同，兼容只存问题向量的旧数据
+	pointSlotSummary   = 1
+	pointSlotAnswer    = 2 // 回答分段从该槽位开始依次存放
+	maxAnswerChunks    = 4
+	minAnswerChunkSize = 800  // 回答分段的最小长度（字符）
+	maxAnswerChunkSize = 4000 // 回答分段的最大长度，超出部分不参与向量化
+)
+
+// vectorPart 对话中的一段文本及其向量
+type vectorPart struct {
+	kind   string
+	chunk  int
+	slot   uint64
+	vector []float32
+}
+
+// pointID 对话某个槽位的点ID
+func pointID(conversationID int64, slot uint64) uint64 {
+	return slot<<pointSlotShift | uint64(conversationID)
+}
+
+// conversationPointIDs 对话可能占用的全部点ID，删除和更新元数据时使用
+func conversationPointIDs(conversationID int64) []uint64 {
+	ids := make([]uint64, 0, pointSlotAnswer+maxAnswerChunks)
+	for slot := uint64(0); slot < pointSlotAnswer+maxAnswerChunks; slot++ {
+		ids = append(ids, pointID(conversationID, slot))
+	}
+	return ids
+}
+
+// conversationOfPoint 点所属的对话ID，优先使用元数据中的 conversation_id
+func conversationOfPoint(result common.SearchResult) int64 {
+	switch id := result.Metadata["conversation_id"].(type) {
+	case int64:
+		return id
+	case int:
+		return int64(id)
+	case float64:
+		return int64(id)
+	}
+	return int64(result.ID & (1<<pointSlotShift - 1))
+}
+
+// chunkAnswer 把回答切分为不超过 maxAnswerChunks 段
+// 每段至少 minAnswerChunkSize 个字符，回答过长时每段最多 maxAnswerChunkSize 个字符
+func chunkAnswer(answer string) []string {
+	runes := []rune(strings.TrimSpace(answer))
+	if len(runes) == 0 {
+		return nil
+	}
+	size := max(minAnswerChunkSize, (len(runes)+maxAnswerChunks-1)/maxAnswerChunks)
+	size = min(size, maxAnswerChunkSize)
+
+	var chunks []string
+	for start := 0; start < len(runes) && len(chunks) < maxAnswerChunks; start += size {
+		end := min(start+size, len(runes))
+		chunks = append(chunks, string(runes[start:end]))
+	}
+	return chunks
+}
+
+// embedConversation 分别向量化问题、摘要和分段的回答，摘要或回答为空时跳过
+func embedConversation(prompt, answer, summary string) ([]vectorPart, error) {
+	vector, err := embedding_service.GetEmbedding(prompt)
+	if err != nil {
+		return nil, fmt.Errorf("问题向量化失败: %v", err)
+	}
+	parts := []vectorPart{{kind: VectorKindPrompt, slot: pointSlotPrompt, vector: vector}}
+
+	if strings.TrimSpace(summary) != "" {
+		vector, err := embedding_service.GetEmbedding(summary)
+		if err != nil {
+			return nil, fmt.Errorf("摘要向量化失败: %v", err)
+		}
+		parts = append(parts, vectorPart{kind: VectorKindSummary, slot: pointSlotSummary, vector: vector})
+	}
+
+	for i, chunk := range chunkAnswer(answer) {
+		vector, err := embedding_service.GetEmbedding(chunk)
+		if err != nil {
+			return nil, fmt.Errorf("回答向量化失败: %v", err)
+		}
+		parts = append(parts, vectorPart{kind: VectorKindAnswer, chunk: i, slot: pointSlotAnswer + uint64(i), vector: vector})
+	}
+	return parts, nil
+}
+
+// conversationPoints 把向量化的结果组装为对话的点
+func conversationPoints(conversation models.ConversationModel, parts []vectorPart) []common.Point {
+	points := make([]common.Point, 0, len(parts))
+	for _, part := range parts {
+		payload := vectorMetadata(conversation)
+		payload["kind"] = part.kind
+		payload["chunk"] = part.chunk
+		points = append(points, common.Point{
+			ID:      pointID(conversation.ID, part.slot),
+			Vector:  part.vector,
+			Payload: payload,
+		})
+	}
+	return points
+}
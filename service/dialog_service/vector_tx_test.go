@@ -0,0 +1,106 @@
+package dialog_service
+
+import (
+	"dialogTree/global"
+	"dialogTree/models"
+	"dialogTree/service/vector_service"
+	"dialogTree/service/vector_service/local_service"
+	"errors"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+// txVectorStore 在事务中把向量写入业务数据库，模拟 pgvector
+type txVectorStore struct {
+	*local_service.LocalService
+	fail bool
+}
+
+func (s *txVectorStore) StoreTx(tx *gorm.DB, id uint64, vector []float32, metadata map[string]interface{}) error {
+	if s.fail {
+		return errors.New("写入失败")
+	}
+	return tx.Create(&models.VectorPointModel{ID: id, Payload: metadata}).Error
+}
+
+func TestSaveConversationStoresVectorInTransaction(t *testing.T) {
+	global.DB = setupTestDB(t)
+	setupLocalVector(t, fakeEmbedder{})
+	sessionID, _, conversationIDs := createTestData(t, global.DB)
+	store := &txVectorStore{LocalService: vector_service.VectorServiceInstance.(*local_service.LocalService)}
+	vector_service.VectorServiceInstance = store
+
+	parentID := conversationIDs[4]
+	conv, err := SaveConversation(ConversationRecord{
+		SessionID:            sessionID,
+		ParentConversationID: &parentID,
+		Prompt:               "新问题",
+		Answer:               "新回答",
+		SummaryRaw:           `{"title":"t","summary":"s"}`,
+	})
+	if err != nil {
+		t.Fatalf("保存失败: %v", err)
+	}
+	var point models.VectorPointModel
+	if err := global.DB.First(&point, conv.ID).Error; err != nil {
+		t.Fatalf("向量应随对话写入: %v", err)
+	}
+	if point.Payload["dialog_id"] != float64(conv.DialogID) {
+		t.Errorf("向量元数据不正确: %v", point.Payload)
+	}
+
+	// 向量写入失败时对话一并回滚
+	store.fail = true
+	var before, after int64
+	global.DB.Model(&models.ConversationModel{}).Count(&before)
+	if _, err := SaveConversation(ConversationRecord{
+		SessionID:            sessionID,
+		ParentConversationID: &conv.ID,
+		Prompt:               "另一个问题",
+		Answer:               "回答",
+		SummaryRaw:           `{"title":"t","summary":"s"}`,
+	}); err == nil {
+		t.Fatal("向量写入失败时保存应失败")
+	}
+	global.DB.Model(&models.ConversationModel{}).Count(&after)
+	if after != before {
+		t.Errorf("对话应回滚，保存前 %d 条，保存后 %d 条", before, after)
+	}
+}
+
+func TestCopySubtreeStoresVectorInTransaction(t *testing.T) {
+	global.DB = setupTestDB(t)
+	setupLocalVector(t, fakeEmbedder{})
+	createSubtreeTestData(t, global.DB)
+	store := &txVectorStore{LocalService: vector_service.VectorServiceInstance.(*local_service.LocalService)}
+	vector_service.VectorServiceInstance = store
+
+	root, err := CopySubtree(3, 6)
+	if err != nil {
+		t.Fatalf("复制失败: %v", err)
+	}
+	var copied models.ConversationModel
+	if err := global.DB.Where("dialog_id = ?", root.ID).First(&copied).Error; err != nil {
+		t.Fatalf("查询复制的对话失败: %v", err)
+	}
+	var point models.VectorPointModel
+	if err := global.DB.First(&point, copied.ID).Error; err != nil {
+		t.Fatalf("向量应随复制的对话写入: %v", err)
+	}
+	if point.Payload["session_id"] != float64(copied.SessionID) {
+		t.Errorf("向量元数据不正确: %v", point.Payload)
+	}
+
+	// 向量写入失败时复制一并回滚
+	store.fail = true
+	var before, after int64
+	global.DB.Model(&models.ConversationModel{}).Count(&before)
+	if _, err := CopySubtree(3, 6); err == nil {
+		t.Fatal("向量写入失败时复制应失败")
+	}
+	global.DB.Model(&models.ConversationModel{}).Count(&after)
+	if after != before {
+		t.Errorf("对话应回滚，复制前 %d 条，复制后 %d 条", before, after)
+	}
+}
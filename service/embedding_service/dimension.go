@@ -0,0 +1,54 @@
+// Path: ./service/embedding_service/dimension.go
+
+package embedding_service
+
+import (
+	"dialogTree/global"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// knownDimensions 常见 embedding 模型的向量维度
+var knownDimensions = map[string]int{
+	"text-embedding-3-small": 1536,
+	"text-embedding-3-large": 3072,
+	"text-embedding-ada-002": 1536,
+	"bge-m3":                 1024,
+	"bge-large-zh-v1.5":      1024,
+}
+
+var (
+	probeMu         sync.Mutex
+	probedModel     string
+	probedDimension int
+)
+
+// Dimension 返回向量维度：优先使用 vector.dimension 配置，其次按 embedding 模型查表，都没有时生成一次向量探测
+func Dimension() (int, error) {
+	if n := global.Config.Vector.Dimension; n > 0 {
+		return n, nil
+	}
+	model := strings.ToLower(global.Config.Ai.EmbeddingModel)
+	if n, ok := knownDimensions[model]; ok {
+		return n, nil
+	}
+
+	probeMu.Lock()
+	defer probeMu.Unlock()
+	if probedModel == model && probedDimension > 0 {
+		return probedDimension, nil
+	}
+	if EmbeddingServiceInstance == nil {
+		return 0, fmt.Errorf("embedding 服务未初始化，无法探测向量维度")
+	}
+	vector, err := EmbeddingServiceInstance.GetEmbedding("dimension probe")
+	if err != nil {
+		return 0, fmt.Errorf("探测向量维度失败: %v", err)
+	}
+	if len(vector) == 0 {
+		return 0, fmt.Errorf("探测向量维度失败: 返回的向量为空")
+	}
+	probedModel, probedDimension = model, len(vector)
+	return probedDimension, nil
+}
@@ -19,7 +19,12 @@ const (
 
 type EmbeddingService struct{}
 
-var EmbeddingServiceInstance *EmbeddingService
+// Embedder 把文本转换为向量，测试时可以替换为不依赖外部接口的实现
+type Embedder interface {
+	GetEmbedding(text string) ([]float32, error)
+}
+
+var EmbeddingServiceInstance Embedder
 
 func InitEmbeddingService() {
 	EmbeddingServiceInstance = &EmbeddingService{}
@@ -0,0 +1,69 @@
+// Path: ./service/redis_service/lock.go
+
+package redis_service
+
+import (
+	"dialogTree/global"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// unlockScript 只释放自己持有的锁，避免锁过期后误删其他实例的锁
+const unlockScript = `if redis.call("get", KEYS[1]) == ARGV[1] then return redis.call("del", KEYS[1]) else return 0 end`
+
+// renewScript 只延长自己持有的锁
+const renewScript = `if redis.call("get", KEYS[1]) == ARGV[1] then return redis.call("pexpire", KEYS[1], ARGV[2]) else return 0 end`
+
+// Lock 获取分布式锁，wait 内未获取到时返回错误
+// ttl 为实例崩溃后锁自动释放的时间，持有期间每隔 ttl/3 续期，耗时超过 ttl 的事务也不会失去锁
+func Lock(key string, ttl, wait time.Duration) (unlock func(), err error) {
+	token := uuid.New().String()
+	deadline := time.Now().Add(wait)
+	for {
+		ok, err := global.Redis.SetNX(key, token, ttl).Result()
+		if err != nil {
+			return nil, fmt.Errorf("获取锁 %s 失败: %v", key, err)
+		}
+		if ok {
+			stop := make(chan struct{})
+			go renewLock(key, token, ttl, stop)
+			var once sync.Once
+			return func() {
+				once.Do(func() {
+					close(stop)
+					global.Redis.Eval(unlockScript, []string{key}, token)
+				})
+			}, nil
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("等待锁 %s 超时", key)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// renewLock 在锁释放前定期续期，锁已不属于自己时停止
+func renewLock(key, token string, ttl time.Duration, stop chan struct{}) {
+	ticker := time.NewTicker(ttl / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			renewed, err := global.Redis.Eval(renewScript, []string{key}, token, ttl.Milliseconds()).Int()
+			if err != nil {
+				logrus.Warnf("续期锁 %s 失败: %v", key, err)
+				continue
+			}
+			if renewed == 0 {
+				logrus.Errorf("锁 %s 已过期并被其他实例持有", key)
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,59 @@
+// Path: ./service/stream_service/enter.go
+
+package stream_service
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"sync"
+)
+
+// activeStream 正在生成中的回答
+type activeStream struct {
+	cancel      context.CancelFunc
+	stopped     bool // 是否由用户主动停止
+	keepPartial bool // 主动停止时是否保留已生成的部分回答
+}
+
+var (
+	streamsMu sync.Mutex
+	streams   = map[string]*activeStream{}
+)
+
+// Register 登记一个正在生成的回答，返回用于停止它的 streamId
+func Register(cancel context.CancelFunc) string {
+	id := uuid.New().String()
+	streamsMu.Lock()
+	defer streamsMu.Unlock()
+	streams[id] = &activeStream{cancel: cancel}
+	return id
+}
+
+// Unregister 回答结束后移除登记
+func Unregister(id string) {
+	streamsMu.Lock()
+	defer streamsMu.Unlock()
+	delete(streams, id)
+}
+
+// Stop 主动停止一个回答，返回 false 表示该回答不存在或已结束
+func Stop(id string, keepPartial bool) bool {
+	streamsMu.Lock()
+	defer streamsMu.Unlock()
+	s, ok := streams[id]
+	if !ok {
+		return false
+	}
+	s.stopped = true
+	s.keepPartial = keepPartial
+	s.cancel()
+	return true
+}
+
+// KeepPartial 回答被用户主动停止且要求保留部分内容时返回 true
+func KeepPartial(id string) bool {
+	streamsMu.Lock()
+	defer streamsMu.Unlock()
+	s, ok := streams[id]
+	return ok && s.stopped && s.keepPartial
+}
@@ -40,6 +40,10 @@ func SetupTestEnvironment(t *testing.T) (*gorm.DB, *gin.Engine) {
 		&models.DialogModel{},
 		&models.ConversationModel{},
 		&models.CategoryModel{},
+		&models.PersonaModel{},
+		&models.PromptTemplateModel{},
+		&models.ConversationParentModel{},
+		&models.MigrationModel{},
 	)
 	if err != nil {
 		t.Fatalf("数据库迁移失败: %v", err)
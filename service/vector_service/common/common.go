@@ -8,3 +8,10 @@ type SearchResult struct {
 	Metadata map[string]interface{} `json:"metadata"`
 	Vector   []float32              `json:"vector"`
 }
+
+// Point 待写入的点
+type Point struct {
+	ID      uint64                 `json:"id"`
+	Vector  []float32              `json:"vector"`
+	Payload map[string]interface{} `json:"payload"`
+}
@@ -3,8 +3,15 @@
 package vector_service
 
 import (
+	"dialogTree/global"
 	"dialogTree/service/vector_service/common"
+	"dialogTree/service/vector_service/local_service"
+	"dialogTree/service/vector_service/pgvector_service"
 	"dialogTree/service/vector_service/qdrant_service"
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
 )
 
 type VectorService interface {
@@ -16,6 +23,12 @@ type VectorService interface {
 	
 	// 删除向量
 	Delete(id uint64) error
+
+	// 批量删除向量，不存在的点直接忽略
+	DeleteBatch(ids []uint64) error
+
+	// 更新元数据（合并到已有元数据中），不存在的点直接忽略，对话移动到其他会话时使用
+	SetPayload(ids []uint64, metadata map[string]interface{}) error
 	
 	// 初始化集合
 	InitCollection() error
@@ -27,10 +40,58 @@ type VectorService interface {
 	ClearCollection() error
 }
 
+// TxVectorService 向量与业务数据存放在同一个数据库中的实现，可以在保存对话的事务中写入向量
+type TxVectorService interface {
+	VectorService
+
+	StoreTx(tx *gorm.DB, id uint64, vector []float32, metadata map[string]interface{}) error
+}
+
+// AliasedVectorService 通过别名访问集合的向量库，重建索引时写入新集合，完成后原子地切换别名
+type AliasedVectorService interface {
+	VectorService
+
+	// 以指定维度新建集合
+	CreateCollection(name string, dimension int) error
+
+	// 批量写入指定集合
+	StoreBatch(collection string, points []common.Point) error
+
+	// 把别名切换到指定集合，返回切换前别名指向的集合；失败后可以再次调用
+	SwitchAlias(collection string) (previous string, err error)
+
+	// 删除集合
+	DropCollection(name string) error
+}
+
+// ResizableVectorService 向量维度固定在表结构中、原地重建索引的向量库
+type ResizableVectorService interface {
+	VectorService
+
+	// 把向量改为指定维度，维度变化时清空已有向量
+	Resize(dimension int) error
+}
+
 var VectorServiceInstance VectorService
 
+// 向量库实现
+const (
+	ProviderQdrant   = "qdrant"   // 独立部署的 Qdrant，默认
+	ProviderLocal    = "local"    // 进程内检索，向量保存在业务数据库中，无需额外服务
+	ProviderPgVector = "pgvector" // PostgreSQL 的 pgvector 扩展，向量与对话在同一个事务中写入
+)
+
 func InitVectorService() error {
 	// 根据配置选择向量数据库实现
-	VectorServiceInstance = &qdrant_service.QdrantService{}
+	switch strings.ToLower(global.Config.Vector.Provider) {
+	case ProviderQdrant, "":
+		VectorServiceInstance = &qdrant_service.QdrantService{}
+	case ProviderLocal:
+		VectorServiceInstance = &local_service.LocalService{}
+	case ProviderPgVector:
+		VectorServiceInstance = &pgvector_service.PgVectorService{}
+	default:
+		return fmt.Errorf("不支持的向量库: %s", global.Config.Vector.Provider)
+	}
 	return VectorServiceInstance.InitCollection()
 }
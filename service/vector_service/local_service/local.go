@@ -0,0 +1,235 @@
+// Path: ./service/vector_service/local_service/local.go
+
+package local_service
+
+import (
+	"dialogTree/global"
+	"dialogTree/models"
+	"dialogTree/service/vector_service/common"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"sync"
+)
+
+// LocalService 进程内向量库，暴力计算余弦相似度，向量持久化到业务数据库
+// 适合个人部署的 SQLite 场景，对话量在几万条以内时检索耗时可以忽略
+type LocalService struct {
+	mu     sync.RWMutex
+	points map[uint64]*localPoint
+}
+
+type localPoint struct {
+	vector  []float32
+	norm    float64
+	payload map[string]interface{}
+}
+
+func newLocalPoint(vector []float32, payload map[string]interface{}) *localPoint {
+	var sum float64
+	for _, v := range vector {
+		sum += float64(v) * float64(v)
+	}
+	return &localPoint{vector: vector, norm: math.Sqrt(sum), payload: payload}
+}
+
+// InitCollection 建表并把已有向量加载到内存
+func (l *LocalService) InitCollection() error {
+	if err := global.DB.AutoMigrate(&models.VectorPointModel{}); err != nil {
+		return fmt.Errorf("创建向量表失败: %v", err)
+	}
+	var rows []models.VectorPointModel
+	if err := global.DB.Find(&rows).Error; err != nil {
+		return fmt.Errorf("加载向量失败: %v", err)
+	}
+
+	points := make(map[uint64]*localPoint, len(rows))
+	for _, row := range rows {
+		points[row.ID] = newLocalPoint(decodeVector(row.Vector), row.Payload)
+	}
+	l.mu.Lock()
+	l.points = points
+	l.mu.Unlock()
+	return nil
+}
+
+func (l *LocalService) Store(id uint64, vector []float32, metadata map[string]interface{}) error {
+	row := models.VectorPointModel{ID: id, Vector: encodeVector(vector), Payload: metadata}
+	if err := global.DB.Save(&row).Error; err != nil {
+		return err
+	}
+	l.mu.Lock()
+	l.points[id] = newLocalPoint(vector, metadata)
+	l.mu.Unlock()
+	return nil
+}
+
+func (l *LocalService) Search(vector []float32, topK int, filter map[string]interface{}) ([]common.SearchResult, error) {
+	query := newLocalPoint(vector, nil)
+	if query.norm == 0 {
+		return []common.SearchResult{}, nil
+	}
+
+	l.mu.RLock()
+	results := make([]common.SearchResult, 0)
+	for id, p := range l.points {
+		if len(p.vector) != len(vector) || p.norm == 0 || !matchFilter(p.payload, filter) {
+			continue
+		}
+		var dot float64
+		for i, v := range p.vector {
+			dot += float64(v) * float64(vector[i])
+		}
+		score := dot / (p.norm * query.norm)
+		if score >= global.Config.Vector.SimilarityThreshold {
+			results = append(results, common.SearchResult{ID: id, Score: score, Metadata: p.payload})
+		}
+	}
+	l.mu.RUnlock()
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].ID < results[j].ID
+	})
+	if topK > 0 && len(results) > topK {
+		results = results[:topK]
+	}
+	return results, nil
+}
+
+func (l *LocalService) Delete(id uint64) error {
+	if err := global.DB.Delete(&models.VectorPointModel{}, id).Error; err != nil {
+		return err
+	}
+	l.mu.Lock()
+	delete(l.points, id)
+	l.mu.Unlock()
+	return nil
+}
+
+func (l *LocalService) DeleteBatch(ids []uint64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	if err := global.DB.Delete(&models.VectorPointModel{}, ids).Error; err != nil {
+		return err
+	}
+	l.mu.Lock()
+	for _, id := range ids {
+		delete(l.points, id)
+	}
+	l.mu.Unlock()
+	return nil
+}
+
+func (l *LocalService) SetPayload(ids []uint64, metadata map[string]interface{}) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, id := range ids {
+		p, ok := l.points[id]
+		if !ok {
+			continue
+		}
+		payload := make(map[string]interface{}, len(p.payload)+len(metadata))
+		for k, v := range p.payload {
+			payload[k] = v
+		}
+		for k, v := range metadata {
+			payload[k] = v
+		}
+		if err := global.DB.Model(&models.VectorPointModel{ID: id}).Select("payload").Updates(&models.VectorPointModel{Payload: payload}).Error; err != nil {
+			return err
+		}
+		p.payload = payload
+	}
+	return nil
+}
+
+func (l *LocalService) GetAllPoints() ([]common.SearchResult, error) {
+	l.mu.RLock()
+	results := make([]common.SearchResult, 0, len(l.points))
+	for id, p := range l.points {
+		results = append(results, common.SearchResult{ID: id, Metadata: p.payload, Vector: p.vector})
+	}
+	l.mu.RUnlock()
+	sort.Slice(results, func(i, j int) bool { return results[i].ID < results[j].ID })
+	return results, nil
+}
+
+func (l *LocalService) ClearCollection() error {
+	if err := global.DB.Where("1 = 1").Delete(&models.VectorPointModel{}).Error; err != nil {
+		return err
+	}
+	l.mu.Lock()
+	l.points = map[uint64]*localPoint{}
+	l.mu.Unlock()
+	return nil
+}
+
+// matchFilter 元数据是否满足过滤条件
+// 支持 {"session_id": 1} 这样的等值条件，以及 Qdrant 格式的 {"must": [{"key": "session_id", "match": {"value": 1}}]}
+func matchFilter(payload, filter map[string]interface{}) bool {
+	for key, want := range filter {
+		if key == "must" {
+			conditions, _ := want.([]interface{})
+			for _, c := range conditions {
+				cond, _ := c.(map[string]interface{})
+				match, _ := cond["match"].(map[string]interface{})
+				field, _ := cond["key"].(string)
+				if !sameValue(payload[field], match["value"]) {
+					return false
+				}
+			}
+			continue
+		}
+		if !sameValue(payload[key], want) {
+			return false
+		}
+	}
+	return true
+}
+
+// sameValue 比较元数据的值，从 JSON 加载的数字都是 float64，需要与代码中的整数相等
+func sameValue(a, b interface{}) bool {
+	if x, ok := toFloat(a); ok {
+		y, ok := toFloat(b)
+		return ok && x == y
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	}
+	return 0, false
+}
+
+func encodeVector(vector []float32) []byte {
+	buf := make([]byte, 4*len(vector))
+	for i, v := range vector {
+		binary.LittleEndian.PutUint32(buf[4*i:], math.Float32bits(v))
+	}
+	return buf
+}
+
+func decodeVector(buf []byte) []float32 {
+	vector := make([]float32, len(buf)/4)
+	for i := range vector {
+		vector[i] = math.Float32frombits(binary.LittleEndian.Uint32(buf[4*i:]))
+	}
+	return vector
+}
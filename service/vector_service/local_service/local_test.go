@@ -0,0 +1,82 @@
+package local_service
+
+import (
+	"dialogTree/conf"
+	"dialogTree/global"
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func setupLocalService(t *testing.T) *LocalService {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("创建测试数据库失败: %v", err)
+	}
+	global.DB = db
+	global.Config = &conf.Config{Vector: conf.Vector{Enable: true, Provider: "local"}}
+
+	l := &LocalService{}
+	if err := l.InitCollection(); err != nil {
+		t.Fatalf("初始化失败: %v", err)
+	}
+	return l
+}
+
+func TestLocalServiceSearch(t *testing.T) {
+	l := setupLocalService(t)
+	l.Store(1, []float32{1, 0}, map[string]interface{}{"session_id": int64(1)})
+	l.Store(2, []float32{0.6, 0.8}, map[string]interface{}{"session_id": int64(1)})
+	l.Store(3, []float32{1, 0}, map[string]interface{}{"session_id": int64(2)})
+
+	results, err := l.Search([]float32{1, 0}, 10, map[string]interface{}{"session_id": int64(1)})
+	if err != nil {
+		t.Fatalf("检索失败: %v", err)
+	}
+	if len(results) != 2 || results[0].ID != 1 || results[1].ID != 2 {
+		t.Fatalf("应按相似度返回点1、2，实际 %+v", results)
+	}
+
+	// Qdrant 格式的过滤条件
+	must := map[string]interface{}{"must": []interface{}{
+		map[string]interface{}{"key": "session_id", "match": map[string]interface{}{"value": 2}},
+	}}
+	if results, _ := l.Search([]float32{1, 0}, 10, must); len(results) != 1 || results[0].ID != 3 {
+		t.Errorf("应只返回会话2的点3，实际 %+v", results)
+	}
+
+	// 阈值以下的点不返回
+	global.Config.Vector.SimilarityThreshold = 0.9
+	if results, _ := l.Search([]float32{1, 0}, 10, map[string]interface{}{"session_id": 1}); len(results) != 1 {
+		t.Errorf("阈值以下的点不应返回，实际 %+v", results)
+	}
+}
+
+func TestLocalServicePersistence(t *testing.T) {
+	l := setupLocalService(t)
+	l.Store(1, []float32{0.5, -0.25}, map[string]interface{}{"session_id": 1, "dialog_id": 1})
+	l.Store(2, []float32{1, 0}, map[string]interface{}{"session_id": 1})
+	if err := l.SetPayload([]uint64{1, 3}, map[string]interface{}{"session_id": 2}); err != nil {
+		t.Fatalf("更新元数据失败: %v", err)
+	}
+	if err := l.DeleteBatch([]uint64{2, 4}); err != nil {
+		t.Fatalf("删除失败: %v", err)
+	}
+
+	reloaded := &LocalService{}
+	if err := reloaded.InitCollection(); err != nil {
+		t.Fatalf("重新加载失败: %v", err)
+	}
+	points, _ := reloaded.GetAllPoints()
+	if len(points) != 1 {
+		t.Fatalf("应剩 1 个点，实际 %d", len(points))
+	}
+	p := points[0]
+	if p.Vector[0] != 0.5 || p.Vector[1] != -0.25 {
+		t.Errorf("向量应原样保存，实际 %v", p.Vector)
+	}
+	if p.Metadata["session_id"] != float64(2) || p.Metadata["dialog_id"] != float64(1) {
+		t.Errorf("元数据应合并更新，实际 %v", p.Metadata)
+	}
+}
@@ -0,0 +1,370 @@
+// Path: ./service/vector_service/pgvector_service/pgvector.go
+
+package pgvector_service
+
+import (
+	"database/sql/driver"
+	"dialogTree/global"
+	"dialogTree/models"
+	"dialogTree/service/embedding_service"
+	"dialogTree/service/vector_service/common"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Vector pgvector 的 vector 类型，以 '[1,2,3]' 文本格式读写
+type Vector []float32
+
+func (v Vector) Value() (driver.Value, error) {
+	parts := make([]string, len(v))
+	for i, f := range v {
+		parts[i] = strconv.FormatFloat(float64(f), 'f', -1, 32)
+	}
+	return "[" + strings.Join(parts, ",") + "]", nil
+}
+
+func (v *Vector) Scan(src interface{}) error {
+	var text string
+	switch s := src.(type) {
+	case string:
+		text = s
+	case []byte:
+		text = string(s)
+	default:
+		return fmt.Errorf("无法解析向量: %T", src)
+	}
+	text = strings.Trim(strings.TrimSpace(text), "[]")
+	if text == "" {
+		*v = Vector{}
+		return nil
+	}
+	parts := strings.Split(text, ",")
+	result := make(Vector, len(parts))
+	for i, p := range parts {
+		f, err := strconv.ParseFloat(strings.TrimSpace(p), 32)
+		if err != nil {
+			return fmt.Errorf("无法解析向量: %v", err)
+		}
+		result[i] = float32(f)
+	}
+	*v = result
+	return nil
+}
+
+// ConversationVectorModel 对话的向量，与对话同库存放，删除对话时级联删除
+// 一条对话可以有多个点，conversation_id、session_id、dialog_id 单独成列用于过滤，其余元数据放在 payload 中
+type ConversationVectorModel struct {
+	ID             int64                  `gorm:"primaryKey;autoIncrement:false"`
+	ConversationID int64                  `gorm:"index"`
+	SessionID      int64                  `gorm:"index"`
+	DialogID       int64                  `gorm:"index"`
+	Embedding      Vector                 `gorm:"type:vector;not null"` // 维度在建表后按 embedding 模型固定，见 setDimension
+	Payload        map[string]interface{} `gorm:"serializer:json;type:jsonb"`
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+
+	// fk
+	ConversationModel models.ConversationModel `gorm:"foreignKey:ConversationID;references:ID;constraint:OnDelete:CASCADE"`
+}
+
+// 单独成列的元数据
+var columnFilters = map[string]string{
+	"conversation_id": "conversation_id",
+	"session_id":      "session_id",
+	"dialog_id":       "dialog_id",
+}
+
+// PgVectorService 基于 PostgreSQL pgvector 扩展的向量库，向量与业务数据在同一个数据库中
+type PgVectorService struct{}
+
+func (p *PgVectorService) InitCollection() error {
+	if global.DB.Dialector.Name() != "postgres" {
+		return fmt.Errorf("pgvector 需要使用 PostgreSQL 作为数据库，当前为 %s", global.DB.Dialector.Name())
+	}
+	if err := global.DB.Exec("CREATE EXTENSION IF NOT EXISTS vector").Error; err != nil {
+		return fmt.Errorf("启用 pgvector 扩展失败: %v", err)
+	}
+	if err := global.DB.AutoMigrate(&ConversationVectorModel{}); err != nil {
+		return fmt.Errorf("创建向量表失败: %v", err)
+	}
+
+	current, err := columnDimension(global.DB)
+	if err != nil {
+		return fmt.Errorf("读取向量列维度失败: %v", err)
+	}
+	dimension, err := embedding_service.Dimension()
+	if err != nil {
+		if current == 0 {
+			logrus.Warnf("%v，向量列暂不固定维度，检索不使用索引", err)
+			return nil
+		}
+		dimension = current
+	}
+
+	switch {
+	case current == 0:
+		// 新建的表和旧版本创建的表没有固定维度，已有向量与当前模型维度一致时直接改为定长
+		if err := setDimension(global.DB, dimension); err != nil {
+			logrus.Warnf("向量列无法改为 %d 维: %v，请执行 dialogtree vector reindex 重建索引", dimension, err)
+			return nil
+		}
+	case current != dimension:
+		logrus.Warnf("向量列为 %d 维，当前 embedding 模型为 %d 维，请执行 dialogtree vector reindex 重建索引", current, dimension)
+		dimension = current
+	}
+	return createIndex(global.DB, dimension)
+}
+
+// Resize 把向量列改为指定维度，原地重建索引前调用
+// 维度变化时旧向量无法转换，先清空，由重建重新写入
+func (p *PgVectorService) Resize(dimension int) error {
+	return global.DB.Transaction(func(tx *gorm.DB) error {
+		current, err := columnDimension(tx)
+		if err != nil {
+			return err
+		}
+		if current == dimension {
+			return nil
+		}
+		if err := tx.Where("1 = 1").Delete(&ConversationVectorModel{}).Error; err != nil {
+			return err
+		}
+		if err := setDimension(tx, dimension); err != nil {
+			return err
+		}
+		return createIndex(tx, dimension)
+	})
+}
+
+// hnswMaxDimension pgvector 的 HNSW 索引支持的最大维度
+const hnswMaxDimension = 2000
+
+const embeddingIndex = "idx_conversation_vector_models_embedding"
+
+func tableName(db *gorm.DB) (string, error) {
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(&ConversationVectorModel{}); err != nil {
+		return "", err
+	}
+	return stmt.Schema.Table, nil
+}
+
+// columnDimension 返回向量列的固定维度，没有固定维度时返回 0
+func columnDimension(db *gorm.DB) (int, error) {
+	table, err := tableName(db)
+	if err != nil {
+		return 0, err
+	}
+	var typmod int
+	err = db.Raw("SELECT atttypmod FROM pg_attribute WHERE attrelid = ?::regclass AND attname = 'embedding'", table).
+		Scan(&typmod).Error
+	if err != nil {
+		return 0, err
+	}
+	if typmod < 0 {
+		return 0, nil
+	}
+	return typmod, nil
+}
+
+// setDimension 把向量列改为定长，已有向量维度不一致时失败
+func setDimension(db *gorm.DB, dimension int) error {
+	table, err := tableName(db)
+	if err != nil {
+		return err
+	}
+	if err := db.Exec("DROP INDEX IF EXISTS " + embeddingIndex).Error; err != nil {
+		return err
+	}
+	return db.Exec(fmt.Sprintf("ALTER TABLE %s ALTER COLUMN embedding TYPE vector(%d)", table, dimension)).Error
+}
+
+// createIndex 为向量列创建余弦距离的 HNSW 索引，超过 HNSW 支持的维度时只能顺序扫描
+func createIndex(db *gorm.DB, dimension int) error {
+	if dimension > hnswMaxDimension {
+		logrus.Warnf("向量为 %d 维，超过 HNSW 索引支持的 %d 维，检索将顺序扫描全部向量", dimension, hnswMaxDimension)
+		return nil
+	}
+	table, err := tableName(db)
+	if err != nil {
+		return err
+	}
+	sql := fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s USING hnsw (embedding vector_cosine_ops)", embeddingIndex, table)
+	if err := db.Exec(sql).Error; err != nil {
+		return fmt.Errorf("创建向量索引失败: %v", err)
+	}
+	return nil
+}
+
+func (p *PgVectorService) Store(id uint64, vector []float32, metadata map[string]interface{}) error {
+	return p.StoreTx(global.DB, id, vector, metadata)
+}
+
+// StoreTx 在调用方的事务中写入向量，与对话记录一起提交或回滚
+func (p *PgVectorService) StoreTx(tx *gorm.DB, id uint64, vector []float32, metadata map[string]interface{}) error {
+	row := ConversationVectorModel{
+		ID:             int64(id),
+		ConversationID: toInt64(metadata["conversation_id"]),
+		SessionID:      toInt64(metadata["session_id"]),
+		DialogID:       toInt64(metadata["dialog_id"]),
+		Embedding:      vector,
+		Payload:        metadata,
+	}
+	return tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"conversation_id", "session_id", "dialog_id", "embedding", "payload", "updated_at"}),
+	}).Create(&row).Error
+}
+
+func (p *PgVectorService) Search(vector []float32, topK int, filter map[string]interface{}) ([]common.SearchResult, error) {
+	query, err := Vector(vector).Value()
+	if err != nil {
+		return nil, err
+	}
+
+	db := global.DB.Model(&ConversationVectorModel{}).
+		Select("id, payload, 1 - (embedding <=> ?::vector) AS score", query)
+	for _, cond := range filterConditions(filter) {
+		if column, ok := columnFilters[cond.key]; ok {
+			db = db.Where(column+" = ?", cond.value)
+		} else {
+			db = db.Where("payload ->> ? = ?", cond.key, fmt.Sprint(cond.value))
+		}
+	}
+	db = db.Where("1 - (embedding <=> ?::vector) >= ?", query, global.Config.Vector.SimilarityThreshold).
+		Order(clause.Expr{SQL: "embedding <=> ?::vector", Vars: []interface{}{query}})
+	if topK > 0 {
+		db = db.Limit(topK)
+	}
+
+	var rows []struct {
+		ID      int64
+		Payload map[string]interface{} `gorm:"serializer:json"`
+		Score   float64
+	}
+	if err := db.Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	results := make([]common.SearchResult, 0, len(rows))
+	for _, row := range rows {
+		results = append(results, common.SearchResult{
+			ID:       uint64(row.ID),
+			Score:    row.Score,
+			Metadata: row.Payload,
+		})
+	}
+	return results, nil
+}
+
+func (p *PgVectorService) Delete(id uint64) error {
+	return global.DB.Delete(&ConversationVectorModel{}, int64(id)).Error
+}
+
+func (p *PgVectorService) DeleteBatch(ids []uint64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	return global.DB.Delete(&ConversationVectorModel{}, "id IN ?", toInt64s(ids)).Error
+}
+
+func (p *PgVectorService) SetPayload(ids []uint64, metadata map[string]interface{}) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	return global.DB.Transaction(func(tx *gorm.DB) error {
+		var rows []ConversationVectorModel
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id IN ?", toInt64s(ids)).Find(&rows).Error; err != nil {
+			return err
+		}
+		for _, row := range rows {
+			if row.Payload == nil {
+				row.Payload = map[string]interface{}{}
+			}
+			for k, v := range metadata {
+				row.Payload[k] = v
+			}
+			row.ConversationID = toInt64(row.Payload["conversation_id"])
+			row.SessionID = toInt64(row.Payload["session_id"])
+			row.DialogID = toInt64(row.Payload["dialog_id"])
+			if err := tx.Model(&row).Select("conversation_id", "session_id", "dialog_id", "payload").Updates(&row).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (p *PgVectorService) GetAllPoints() ([]common.SearchResult, error) {
+	var rows []ConversationVectorModel
+	if err := global.DB.Order("id ASC").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	results := make([]common.SearchResult, 0, len(rows))
+	for _, row := range rows {
+		results = append(results, common.SearchResult{
+			ID:       uint64(row.ID),
+			Metadata: row.Payload,
+			Vector:   row.Embedding,
+		})
+	}
+	return results, nil
+}
+
+func (p *PgVectorService) ClearCollection() error {
+	return global.DB.Where("1 = 1").Delete(&ConversationVectorModel{}).Error
+}
+
+type condition struct {
+	key   string
+	value interface{}
+}
+
+// filterConditions 把过滤条件展开为等值条件
+// 支持 {"session_id": 1} 这样的等值条件，以及 Qdrant 格式的 {"must": [{"key": "session_id", "match": {"value": 1}}]}
+func filterConditions(filter map[string]interface{}) []condition {
+	var conds []condition
+	for key, value := range filter {
+		if key != "must" {
+			conds = append(conds, condition{key: key, value: value})
+			continue
+		}
+		items, _ := value.([]interface{})
+		for _, item := range items {
+			c, _ := item.(map[string]interface{})
+			match, _ := c["match"].(map[string]interface{})
+			if field, ok := c["key"].(string); ok {
+				conds = append(conds, condition{key: field, value: match["value"]})
+			}
+		}
+	}
+	return conds
+}
+
+func toInt64s(ids []uint64) []int64 {
+	result := make([]int64, len(ids))
+	for i, id := range ids {
+		result[i] = int64(id)
+	}
+	return result
+}
+
+func toInt64(v interface{}) int64 {
+	switch n := v.(type) {
+	case int:
+		return int64(n)
+	case int64:
+		return n
+	case uint64:
+		return int64(n)
+	case float64:
+		return int64(n)
+	}
+	return 0
+}
@@ -0,0 +1,38 @@
+package pgvector_service
+
+import (
+	"testing"
+)
+
+func TestVectorValueScan(t *testing.T) {
+	v := Vector{0.5, -1, 2.25}
+	value, err := v.Value()
+	if err != nil || value != "[0.5,-1,2.25]" {
+		t.Fatalf("向量应编码为 pgvector 文本格式，实际 %v %v", value, err)
+	}
+
+	var scanned Vector
+	if err := scanned.Scan([]byte("[0.5, -1, 2.25]")); err != nil {
+		t.Fatalf("解析失败: %v", err)
+	}
+	if len(scanned) != 3 || scanned[0] != 0.5 || scanned[1] != -1 || scanned[2] != 2.25 {
+		t.Errorf("解析结果不正确: %v", scanned)
+	}
+	if err := scanned.Scan(1); err == nil {
+		t.Error("非文本应解析失败")
+	}
+}
+
+func TestFilterConditions(t *testing.T) {
+	conds := filterConditions(map[string]interface{}{"must": []interface{}{
+		map[string]interface{}{"key": "dialog_id", "match": map[string]interface{}{"value": 3}},
+	}})
+	if len(conds) != 1 || conds[0].key != "dialog_id" || conds[0].value != 3 {
+		t.Errorf("Qdrant 格式的条件解析不正确: %+v", conds)
+	}
+
+	conds = filterConditions(map[string]interface{}{"session_id": int64(1)})
+	if len(conds) != 1 || conds[0].key != "session_id" || conds[0].value != int64(1) {
+		t.Errorf("等值条件解析不正确: %+v", conds)
+	}
+}
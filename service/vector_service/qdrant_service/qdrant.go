@@ -5,12 +5,15 @@ package qdrant_service
 import (
 	"bytes"
 	"dialogTree/global"
+	"dialogTree/service/embedding_service"
 	"dialogTree/service/vector_service/common"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"time"
+
+	"github.com/sirupsen/logrus"
 )
 
 type QdrantService struct {
@@ -26,10 +29,11 @@ type QdrantPoint struct {
 }
 
 type QdrantSearchRequest struct {
-	Vector      []float32              `json:"vector"`
-	Limit       int                    `json:"limit"`
-	WithPayload bool                   `json:"with_payload"`
-	Filter      map[string]interface{} `json:"filter,omitempty"`
+	Vector         []float32              `json:"vector"`
+	Limit          int                    `json:"limit"`
+	WithPayload    bool                   `json:"with_payload"`
+	Filter         map[string]interface{} `json:"filter,omitempty"`
+	ScoreThreshold float64                `json:"score_threshold,omitempty"` // 在服务端过滤低于阈值的点，避免占用 limit
 }
 
 type QdrantSearchResponse struct {
@@ -68,19 +72,49 @@ func (q *QdrantService) init() {
 	q.client = &http.Client{Timeout: 30 * time.Second}
 }
 
+// defaultDimension 无法确定 embedding 维度时使用 text-embedding-3-small 的维度
+const defaultDimension = 1536
+
+// InitCollection 配置的集合名可以是集合本身，也可以是重建索引后指向新集合的别名
 func (q *QdrantService) InitCollection() error {
 	q.init()
 
+	dimension, err := embedding_service.Dimension()
+	if err != nil {
+		logrus.Warnf("%v，按 %d 维创建集合", err, defaultDimension)
+		dimension = defaultDimension
+	}
+
+	aliases, err := q.listAliases()
+	if err != nil {
+		return err
+	}
+	name, isAlias := aliases[q.collection]
+	if !isAlias {
+		name = q.collection
+		if err := q.CreateCollection(name, dimension); err != nil {
+			return err
+		}
+	}
+
+	if size, err := q.collectionSize(name); err == nil && size != dimension {
+		logrus.Warnf("向量集合 %s 为 %d 维，当前 embedding 模型为 %d 维，请执行 dialogtree vector reindex 重建索引", name, size, dimension)
+	}
+	return nil
+}
+
+// CreateCollection 以指定维度新建集合，集合已存在时不做处理
+func (q *QdrantService) CreateCollection(name string, dimension int) error {
 	// 创建集合的配置
 	createReq := map[string]interface{}{
 		"vectors": map[string]interface{}{
-			"size":     1536, // text-embedding-3-small 的维度
+			"size":     dimension,
 			"distance": "Cosine",
 		},
 	}
 
 	reqBody, _ := json.Marshal(createReq)
-	url := fmt.Sprintf("%s/collections/%s", q.baseURL, q.collection)
+	url := fmt.Sprintf("%s/collections/%s", q.baseURL, name)
 
 	req, err := http.NewRequest("PUT", url, bytes.NewBuffer(reqBody))
 	if err != nil {
@@ -107,6 +141,126 @@ func (q *QdrantService) InitCollection() error {
 	return nil
 }
 
+// collectionSize 返回集合的向量维度
+func (q *QdrantService) collectionSize(name string) (int, error) {
+	respBody, err := q.makeRequestWithResponse("GET", fmt.Sprintf("/collections/%s", name), nil)
+	if err != nil {
+		return 0, err
+	}
+	var info struct {
+		Result struct {
+			Config struct {
+				Params struct {
+					Vectors struct {
+						Size int `json:"size"`
+					} `json:"vectors"`
+				} `json:"params"`
+			} `json:"config"`
+		} `json:"result"`
+	}
+	if err := json.Unmarshal(respBody, &info); err != nil {
+		return 0, fmt.Errorf("failed to unmarshal collection info: %v", err)
+	}
+	return info.Result.Config.Params.Vectors.Size, nil
+}
+
+// listAliases 返回别名到集合的映射
+func (q *QdrantService) listAliases() (map[string]string, error) {
+	respBody, err := q.makeRequestWithResponse("GET", "/aliases", nil)
+	if err != nil {
+		return nil, fmt.Errorf("list aliases failed: %v", err)
+	}
+	var resp struct {
+		Result struct {
+			Aliases []struct {
+				AliasName      string `json:"alias_name"`
+				CollectionName string `json:"collection_name"`
+			} `json:"aliases"`
+		} `json:"result"`
+	}
+	if err := json.Unmarshal(respBody, &resp); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal aliases: %v", err)
+	}
+	aliases := make(map[string]string, len(resp.Result.Aliases))
+	for _, a := range resp.Result.Aliases {
+		aliases[a.AliasName] = a.CollectionName
+	}
+	return aliases, nil
+}
+
+// collectionExists 集合是否存在，别名不算
+func (q *QdrantService) collectionExists(name string) (bool, error) {
+	respBody, err := q.makeRequestWithResponse("GET", "/collections", nil)
+	if err != nil {
+		return false, fmt.Errorf("list collections failed: %v", err)
+	}
+	var resp struct {
+		Result struct {
+			Collections []struct {
+				Name string `json:"name"`
+			} `json:"collections"`
+		} `json:"result"`
+	}
+	if err := json.Unmarshal(respBody, &resp); err != nil {
+		return false, fmt.Errorf("failed to unmarshal collections: %v", err)
+	}
+	for _, c := range resp.Result.Collections {
+		if c.Name == name {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// StoreBatch 批量写入指定集合，等待写入完成后返回
+func (q *QdrantService) StoreBatch(collection string, points []common.Point) error {
+	reqBody := map[string]interface{}{
+		"points": points,
+	}
+	return q.makeRequest("PUT", fmt.Sprintf("/collections/%s/points?wait=true", collection), reqBody)
+}
+
+// SwitchAlias 把配置的集合名作为别名指向新集合，删除旧别名和创建新别名在同一个请求中原子完成
+// 配置的集合名还是集合本身时（首次重建索引），Qdrant 不允许别名与集合同名，只能先删除该集合再创建别名，
+// 两步之间检索和写入不可用；创建别名失败时旧集合已经删除，再次调用会跳过删除直接创建别名
+func (q *QdrantService) SwitchAlias(collection string) (previous string, err error) {
+	aliases, err := q.listAliases()
+	if err != nil {
+		return "", err
+	}
+
+	var actions []map[string]interface{}
+	previous, isAlias := aliases[q.collection]
+	if isAlias {
+		actions = append(actions, map[string]interface{}{
+			"delete_alias": map[string]interface{}{"alias_name": q.collection},
+		})
+	} else {
+		exists, err := q.collectionExists(q.collection)
+		if err != nil {
+			return "", err
+		}
+		if exists {
+			if err := q.DropCollection(q.collection); err != nil {
+				return "", fmt.Errorf("删除旧集合失败: %v", err)
+			}
+		}
+	}
+	actions = append(actions, map[string]interface{}{
+		"create_alias": map[string]interface{}{"collection_name": collection, "alias_name": q.collection},
+	})
+
+	if err := q.makeRequest("POST", "/collections/aliases", map[string]interface{}{"actions": actions}); err != nil {
+		return "", fmt.Errorf("切换别名失败: %v", err)
+	}
+	return previous, nil
+}
+
+// DropCollection 删除集合
+func (q *QdrantService) DropCollection(name string) error {
+	return q.makeRequest("DELETE", fmt.Sprintf("/collections/%s", name), nil)
+}
+
 func (q *QdrantService) Store(id uint64, vector []float32, metadata map[string]interface{}) error {
 	point := QdrantPoint{
 		ID:      id,
@@ -127,6 +281,8 @@ func (q *QdrantService) Search(vector []float32, topK int, filter map[string]int
 		Limit:       topK,
 		WithPayload: true,
 		Filter:      filter,
+
+		ScoreThreshold: global.Config.Vector.SimilarityThreshold,
 	}
 
 	reqBody, _ := json.Marshal(searchReq)
@@ -175,6 +331,36 @@ func (q *QdrantService) Delete(id uint64) error {
 	return q.makeRequest("POST", fmt.Sprintf("/collections/%s/points/delete", q.collection), reqBody)
 }
 
+// hasIDFilter 按点ID过滤，点不存在时不会报错
+func hasIDFilter(ids []uint64) map[string]interface{} {
+	return map[string]interface{}{
+		"must": []interface{}{map[string]interface{}{"has_id": ids}},
+	}
+}
+
+func (q *QdrantService) DeleteBatch(ids []uint64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	reqBody := map[string]interface{}{
+		"filter": hasIDFilter(ids),
+	}
+
+	return q.makeRequest("POST", fmt.Sprintf("/collections/%s/points/delete", q.collection), reqBody)
+}
+
+func (q *QdrantService) SetPayload(ids []uint64, metadata map[string]interface{}) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	reqBody := map[string]interface{}{
+		"payload": metadata,
+		"filter":  hasIDFilter(ids),
+	}
+
+	return q.makeRequest("POST", fmt.Sprintf("/collections/%s/points/payload", q.collection), reqBody)
+}
+
 func (q *QdrantService) GetAllPoints() ([]common.SearchResult, error) {
 	var allResults []common.SearchResult
 	limit := 100             // 每页获取100个点